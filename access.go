@@ -0,0 +1,49 @@
+package snmp
+
+import (
+	"sync"
+	"time"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+// SetAccessTracking enables recording the last time each managed object
+// instance was read or written, see LastAccess. It's disabled by default
+// since it adds a map update to each access.
+func (a *Agent) SetAccessTracking(enabled bool) {
+	a.accesses.Lock()
+	defer a.accesses.Unlock()
+
+	if !enabled {
+		a.accesses.times = nil
+	} else if a.accesses.times == nil {
+		a.accesses.times = make(map[string]time.Time)
+	}
+}
+
+// LastAccess returns the last time the getter or the setter of a managed
+// object instance was called for the given absolute OID. It returns false for
+// instances never accessed or when access tracking is disabled.
+func (a *Agent) LastAccess(oid asn1.Oid) (time.Time, bool) {
+	a.accesses.Lock()
+	defer a.accesses.Unlock()
+
+	t, ok := a.accesses.times[oid.String()]
+	return t, ok
+}
+
+// accessTracker keeps the last access time of each instance.
+type accessTracker struct {
+	sync.Mutex
+	times map[string]time.Time
+}
+
+// touch records an access to an instance, if tracking is enabled.
+func (t *accessTracker) touch(oid asn1.Oid) {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.times != nil {
+		t.times[oid.String()] = time.Now()
+	}
+}
@@ -0,0 +1,43 @@
+package snmp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+func TestLastAccess(t *testing.T) {
+
+	nameOid := asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0}
+	agent := NewAgent()
+	agent.AddRoManagedObject(nameOid,
+		func(oid asn1.Oid) (interface{}, error) {
+			return "name", nil
+		})
+	get := func() {
+		_, err := agent.ProcessMessage(&Message{
+			Community: "public",
+			Pdu:       GetRequestPdu{Variables: []Variable{{nameOid, asn1.Null{}}}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get()
+	if _, ok := agent.LastAccess(nameOid); ok {
+		t.Fatalf("Accesses should not be tracked by default\n")
+	}
+
+	agent.SetAccessTracking(true)
+	before := time.Now()
+	get()
+	last, ok := agent.LastAccess(nameOid)
+	if !ok || last.Before(before) || last.After(time.Now()) {
+		t.Fatalf("Wrong last access %s\n", last)
+	}
+	if _, ok := agent.LastAccess(asn1.Oid{1, 3, 6, 1, 2, 1, 1, 6, 0}); ok {
+		t.Fatalf("Unknown OID should not have accesses\n")
+	}
+}
@@ -30,6 +30,13 @@ const (
 	InconsistentName    = 18
 )
 
+// SNMP versions as encoded in the Message version field.
+const (
+	Version1  = 0
+	Version2c = 1
+	Version3  = 3
+)
+
 // Message is the top level element of the SNMP protocol.
 type Message struct {
 	Version   int
@@ -37,6 +44,31 @@ type Message struct {
 	Pdu       interface{} `asn1:"choice:pdu"`
 }
 
+// Variables returns the variable bindings of the message PDU. It returns
+// false for SNMPv1 traps, whose variables are accessed through V1TrapPdu, and
+// for unknown PDU types.
+func (m Message) Variables() ([]Variable, bool) {
+	switch pdu := m.Pdu.(type) {
+	case GetRequestPdu:
+		return pdu.Variables, true
+	case GetNextRequestPdu:
+		return pdu.Variables, true
+	case GetResponsePdu:
+		return pdu.Variables, true
+	case SetRequestPdu:
+		return pdu.Variables, true
+	case GetBulkRequestPdu:
+		return pdu.Variables, true
+	case InformRequestPdu:
+		return pdu.Variables, true
+	case V2TrapPdu:
+		return pdu.Variables, true
+	case ReportPdu:
+		return pdu.Variables, true
+	}
+	return nil, false
+}
+
 // Pdu is a generic type for other Protocol Data Units.
 type Pdu struct {
 	Identifier  int
@@ -85,12 +117,95 @@ type InformRequestPdu Pdu
 // V2TrapPdu is used when sending a trap in SNMPv2.
 type V2TrapPdu Pdu
 
+// ReportPdu is used by SNMPv3 engines to report processing errors, such as
+// during engine discovery and timeliness checks.
+type ReportPdu Pdu
+
 // Variable represents an entry of the variable bindings
 type Variable struct {
 	Name  asn1.Oid
 	Value interface{} `asn1:"choice:val"`
 }
 
+// NewVariable creates a variable binding, checking that the value is of one of
+// the types supported by the agent:
+//
+//	int
+//	string
+//	asn1.Null
+//	asn1.Oid
+//	snmp.Bits
+//	snmp.Counter32
+//	snmp.Counter64
+//	snmp.DateAndTime
+//	snmp.InetAddress
+//	snmp.IPAddress
+//	snmp.Opaque
+//	snmp.TimeTicks
+//	snmp.Unsigned32
+func NewVariable(oid asn1.Oid, value interface{}) (Variable, error) {
+	if err := checkOid(oid); err != nil {
+		return Variable{}, err
+	}
+	if !supportedValue(value) {
+		return Variable{}, fmt.Errorf("unsupported type %T for OID %s", value, oid)
+	}
+	return Variable{oid, value}, nil
+}
+
+// supportedValue checks if a value is of one of the types listed in
+// NewVariable.
+func supportedValue(value interface{}) bool {
+	switch value.(type) {
+	case int, string, asn1.Null, asn1.Oid, Bits, Counter32, Counter64,
+		DateAndTime, InetAddress, IPAddress, Opaque, TimeTicks, Unsigned32:
+		return true
+	}
+	return false
+}
+
+// ASN.1 tag classes returned by ValueTag.
+const (
+	ClassUniversal       = 0
+	ClassApplication     = 1
+	ClassContextSpecific = 2
+)
+
+// ValueTag reports the ASN.1 class and tag used to encode a Variable value.
+// It returns false for values that can't be encoded. The class is one of
+// ClassUniversal, ClassApplication and ClassContextSpecific.
+func ValueTag(v interface{}) (class int, tag int, ok bool) {
+	switch v.(type) {
+	case int:
+		return ClassUniversal, 2, true
+	case string, Bits, DateAndTime, InetAddress:
+		return ClassUniversal, 4, true
+	case asn1.Null:
+		return ClassUniversal, 5, true
+	case asn1.Oid:
+		return ClassUniversal, 6, true
+	case IPAddress:
+		return ClassApplication, 0, true
+	case Counter32:
+		return ClassApplication, 1, true
+	case Unsigned32:
+		return ClassApplication, 2, true
+	case TimeTicks:
+		return ClassApplication, 3, true
+	case Opaque:
+		return ClassApplication, 4, true
+	case Counter64:
+		return ClassApplication, 6, true
+	case NoSuchObject:
+		return ClassContextSpecific, 0, true
+	case NoSuchInstance:
+		return ClassContextSpecific, 1, true
+	case EndOfMibView:
+		return ClassContextSpecific, 2, true
+	}
+	return 0, 0, false
+}
+
 // Types available for Variable.Value
 
 // IPAddress is a IPv4 address.
@@ -136,6 +251,90 @@ func (e EndOfMibView) String() string { return "EndOfMibView" }
 // Asn1Context returns a new allocated asn1.Context and registers all the
 // choice types necessary for SNMPv1 and SNMPv2.
 func Asn1Context() *asn1.Context {
+	return asn1Context(true)
+}
+
+// Asn1V1Context works like Asn1Context but doesn't register the SNMPv2
+// exceptions. Variables with exception values fail to decode, as expected in
+// strict SNMPv1.
+func Asn1V1Context() *asn1.Context {
+	return asn1Context(false)
+}
+
+// EncodeMessage returns the BER encoding of a SNMP message.
+func EncodeMessage(message *Message) ([]byte, error) {
+	return Asn1Context().Encode(*message)
+}
+
+// EncodePdu wraps a PDU in a message with the given version and community and
+// returns its BER encoding. The PDU must be of one of the PDU types of this
+// package, like GetRequestPdu.
+func EncodePdu(version int, community string, pdu interface{}) ([]byte, error) {
+	switch pdu.(type) {
+	case GetRequestPdu, GetNextRequestPdu, GetResponsePdu, SetRequestPdu,
+		V1TrapPdu, GetBulkRequestPdu, InformRequestPdu, V2TrapPdu, ReportPdu:
+	default:
+		return nil, fmt.Errorf("invalid PDU type %T", pdu)
+	}
+	return EncodeMessage(&Message{
+		Version:   version,
+		Community: community,
+		Pdu:       pdu,
+	})
+}
+
+// DecodeMessage decodes a binary SNMP message. Trailing bytes after the
+// message are considered an error.
+func DecodeMessage(data []byte) (*Message, error) {
+	message := &Message{}
+	remaining, err := Asn1Context().Decode(data, message)
+	if err != nil {
+		return nil, err
+	}
+	if len(remaining) > 0 {
+		return nil, fmt.Errorf("%d remaining bytes", len(remaining))
+	}
+	return message, nil
+}
+
+// PeekHeader decodes only the version and community of a binary SNMP message,
+// without decoding its PDU, so a message can be routed or dropped cheaply.
+// The PDU is not validated.
+func PeekHeader(data []byte) (version int, community string, err error) {
+	message, _, err := readElement(data)
+	if err != nil {
+		return 0, "", err
+	}
+	if message.class != ClassUniversal || !message.constructed ||
+		message.tag != 16 {
+		return 0, "", fmt.Errorf("message is not a SEQUENCE")
+	}
+	v, rest, err := readElement(message.content)
+	if err != nil {
+		return 0, "", err
+	}
+	if v.class != ClassUniversal || v.constructed || v.tag != 2 ||
+		len(v.content) == 0 || len(v.content) > 4 {
+		return 0, "", fmt.Errorf("invalid version")
+	}
+	// Two's complement, big endian
+	version = int(int8(v.content[0]))
+	for _, b := range v.content[1:] {
+		version = version<<8 | int(b)
+	}
+	c, _, err := readElement(rest)
+	if err != nil {
+		return 0, "", err
+	}
+	if c.class != ClassUniversal || c.constructed || c.tag != 4 {
+		return 0, "", fmt.Errorf("invalid community")
+	}
+	return version, string(c.content), nil
+}
+
+// asn1Context creates an asn1.Context optionally registering the SNMPv2
+// exceptions.
+func asn1Context(exceptions bool) *asn1.Context {
 	ctx := asn1.NewContext()
 	ctx.AddChoice("pdu", []asn1.Choice{
 		{
@@ -170,8 +369,12 @@ func Asn1Context() *asn1.Context {
 			Type:    reflect.TypeOf(V2TrapPdu{}),
 			Options: "tag:7",
 		},
+		{
+			Type:    reflect.TypeOf(ReportPdu{}),
+			Options: "tag:8",
+		},
 	})
-	ctx.AddChoice("val", []asn1.Choice{
+	values := []asn1.Choice{
 		// Simple syntax
 		{
 			Type: reflect.TypeOf(asn1.Null{}),
@@ -185,6 +388,17 @@ func Asn1Context() *asn1.Context {
 		{
 			Type: reflect.TypeOf(asn1.Oid{}),
 		},
+		// BITS, DateAndTime and InetAddress share the OCTET STRING
+		// encoding and are decoded as strings.
+		{
+			Type: reflect.TypeOf(Bits{}),
+		},
+		{
+			Type: reflect.TypeOf(DateAndTime{}),
+		},
+		{
+			Type: reflect.TypeOf(InetAddress{}),
+		},
 		// Application wide
 		{
 			Type:    reflect.TypeOf(IPAddress{}),
@@ -211,19 +425,24 @@ func Asn1Context() *asn1.Context {
 			Type:    reflect.TypeOf(Counter64(0)),
 			Options: "application,tag:6",
 		},
-		// Exceptions
-		{
-			Type:    reflect.TypeOf(NoSuchObject{}),
-			Options: "tag:0",
-		},
-		{
-			Type:    reflect.TypeOf(NoSuchInstance{}),
-			Options: "tag:1",
-		},
-		{
-			Type:    reflect.TypeOf(EndOfMibView{}),
-			Options: "tag:2",
-		},
-	})
+	}
+	// Exceptions
+	if exceptions {
+		values = append(values, []asn1.Choice{
+			{
+				Type:    reflect.TypeOf(NoSuchObject{}),
+				Options: "tag:0",
+			},
+			{
+				Type:    reflect.TypeOf(NoSuchInstance{}),
+				Options: "tag:1",
+			},
+			{
+				Type:    reflect.TypeOf(EndOfMibView{}),
+				Options: "tag:2",
+			},
+		}...)
+	}
+	ctx.AddChoice("val", values)
 	return ctx
 }
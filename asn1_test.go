@@ -0,0 +1,308 @@
+package snmp
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+func TestReportPdu(t *testing.T) {
+	message := Message{
+		Version:   3,
+		Community: "",
+		Pdu: ReportPdu{
+			Identifier: 1,
+			Variables: []Variable{
+				// usmStatsUnknownEngineIDs
+				{asn1.Oid{1, 3, 6, 1, 6, 3, 15, 1, 1, 4, 0}, Counter32(1)},
+			},
+		},
+	}
+	ctx := Asn1Context()
+	data, err := ctx.Encode(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := Message{}
+	_, err = ctx.Decode(data, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := decoded.Pdu.(ReportPdu); !ok {
+		t.Fatalf("Invalid PDU type: %T\n", decoded.Pdu)
+	}
+	if !reflect.DeepEqual(decoded, message) {
+		t.Fatalf("Wrong decoded message %#v\n", decoded)
+	}
+}
+
+func TestReportPduScoped(t *testing.T) {
+	// Reports travel in the scoped PDU of SNMPv3 messages
+	scoped := ScopedPdu{
+		ContextEngineID: []byte{0x80, 0, 0, 0, 4, 1},
+		Pdu: ReportPdu{
+			Identifier: 7,
+			Variables: []Variable{
+				// usmStatsNotInTimeWindows
+				{asn1.Oid{1, 3, 6, 1, 6, 3, 15, 1, 1, 2, 0}, Counter32(3)},
+			},
+		},
+	}
+	data, err := EncodeScopedPdu(scoped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeScopedPdu(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, scoped) {
+		t.Fatalf("Wrong decoded scoped PDU %#v\n", decoded)
+	}
+}
+
+func TestNewVariable(t *testing.T) {
+	oid := asn1.Oid{1, 3, 6, 1, 4, 1, 1, 0}
+	values := []interface{}{
+		0,
+		"",
+		asn1.Null{},
+		asn1.Oid{1, 3},
+		NewBits(1),
+		Counter32(0),
+		Counter64(0),
+		IPAddress{127, 0, 0, 1},
+		Opaque{},
+		TimeTicks(0),
+		Unsigned32(0),
+	}
+	for _, value := range values {
+		v, err := NewVariable(oid, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Name.Cmp(oid) != 0 || !reflect.DeepEqual(v.Value, value) {
+			t.Fatalf("Wrong variable %v\n", v)
+		}
+	}
+
+	for _, value := range []interface{}{uint32(0), nil, NoSuchObject{}} {
+		if _, err := NewVariable(oid, value); err == nil {
+			t.Fatalf("Variable with value of type %T should fail.\n", value)
+		}
+	}
+	if _, err := NewVariable(asn1.Oid{}, 0); err == nil {
+		t.Fatal("Variable with an empty OID should fail.")
+	}
+}
+
+func TestStrictV1(t *testing.T) {
+
+	uptimeOid := asn1.Oid{1, 3, 6, 1, 2, 1, 1, 3, 0}
+	message := Message{
+		Version:   Version1,
+		Community: "public",
+		Pdu: SetRequestPdu{
+			Variables: []Variable{{uptimeOid, EndOfMibView{}}},
+		},
+	}
+	data, err := Asn1Context().Encode(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := Message{}
+	_, err = Asn1V1Context().Decode(data, &decoded)
+	if err == nil {
+		t.Fatal("Decoding an exception in strict SNMPv1 should fail.")
+	}
+
+	agent := NewAgent()
+	agent.AddRoManagedObject(uptimeOid,
+		func(oid asn1.Oid) (interface{}, error) {
+			return 0, nil
+		})
+	_, err = agent.ProcessDatagram(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent.SetStrictV1(true)
+	_, err = agent.ProcessDatagram(data)
+	if e, ok := err.(ProcessError); !ok || e.Kind != Drop {
+		t.Fatalf("Message with an exception should be dropped: %v\n", err)
+	}
+}
+
+func TestEncodeDecodeMessage(t *testing.T) {
+	message := &Message{
+		Version:   Version1,
+		Community: "public",
+		Pdu: GetRequestPdu{
+			Identifier: 1,
+			Variables: []Variable{
+				{asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0}, asn1.Null{}},
+			},
+		},
+	}
+	data, err := EncodeMessage(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeMessage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(message, decoded) {
+		t.Fatalf("Wrong decoded message: %#v\n", decoded)
+	}
+	if _, err := DecodeMessage(append(data, 0)); err == nil {
+		t.Fatalf("Trailing bytes should fail\n")
+	}
+}
+
+func TestEncodePdu(t *testing.T) {
+	variables := []Variable{
+		{asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0}, "name"},
+	}
+	pdu := Pdu{Identifier: 7, Variables: variables}
+	pdus := []interface{}{
+		GetRequestPdu(pdu),
+		GetNextRequestPdu(pdu),
+		GetResponsePdu(pdu),
+		SetRequestPdu(pdu),
+		V1TrapPdu{
+			Enterprise: asn1.Oid{1, 3, 6, 1, 4, 1, 9999},
+			AgentAddr:  IPAddress{10, 0, 0, 1},
+			Timestamp:  TimeTicks(100),
+			Variables:  variables,
+		},
+		GetBulkRequestPdu{
+			Identifier:     7,
+			MaxRepetitions: 10,
+			Variables:      variables,
+		},
+		InformRequestPdu(pdu),
+		V2TrapPdu(pdu),
+		ReportPdu(pdu),
+	}
+	for _, p := range pdus {
+		data, err := EncodePdu(Version2c, "public", p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		message, err := DecodeMessage(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if message.Version != Version2c || message.Community != "public" {
+			t.Fatalf("Wrong message header: %#v\n", message)
+		}
+		if !reflect.DeepEqual(message.Pdu, p) {
+			t.Fatalf("Wrong decoded PDU %#v instead of %#v\n", message.Pdu, p)
+		}
+	}
+
+	if _, err := EncodePdu(Version1, "public", pdu); err == nil {
+		t.Fatalf("Pdu is not a valid PDU type\n")
+	}
+}
+
+func TestValueTag(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		class int
+		tag   int
+	}{
+		{int(1), ClassUniversal, 2},
+		{"", ClassUniversal, 4},
+		{NewBits(1), ClassUniversal, 4},
+		{DateAndTime{}, ClassUniversal, 4},
+		{InetAddress{}, ClassUniversal, 4},
+		{asn1.Null{}, ClassUniversal, 5},
+		{asn1.Oid{1, 3}, ClassUniversal, 6},
+		{IPAddress{}, ClassApplication, 0},
+		{Counter32(0), ClassApplication, 1},
+		{Unsigned32(0), ClassApplication, 2},
+		{TimeTicks(0), ClassApplication, 3},
+		{Opaque{}, ClassApplication, 4},
+		{Counter64(0), ClassApplication, 6},
+		{NoSuchObject{}, ClassContextSpecific, 0},
+		{NoSuchInstance{}, ClassContextSpecific, 1},
+		{EndOfMibView{}, ClassContextSpecific, 2},
+	}
+	for _, test := range tests {
+		class, tag, ok := ValueTag(test.value)
+		if !ok || class != test.class || tag != test.tag {
+			t.Fatalf("Wrong tag for %T: %d %d %t\n", test.value, class, tag, ok)
+		}
+		// Encoded as 30 <len> 06 01 2b <value>, where the first byte of the
+		// value holds the class and the tag.
+		data, err := Asn1Context().Encode(Variable{asn1.Oid{1, 3}, test.value})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := data[5]; int(b>>6) != class || int(b&0x1f) != tag {
+			t.Fatalf("Wrong encoded identifier 0x%x for %T\n", b, test.value)
+		}
+	}
+	if _, _, ok := ValueTag(1.5); ok {
+		t.Fatalf("float64 is not a supported type\n")
+	}
+}
+
+func TestMessageVariables(t *testing.T) {
+	variables := []Variable{
+		{asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0}, asn1.Null{}},
+		{asn1.Oid{1, 3, 6, 1, 2, 1, 1, 6, 0}, asn1.Null{}},
+	}
+	messages := []Message{
+		{Pdu: GetRequestPdu{Variables: variables}},
+		{Pdu: GetResponsePdu{Variables: variables}},
+	}
+	for _, message := range messages {
+		v, ok := message.Variables()
+		if !ok || !reflect.DeepEqual(v, variables) {
+			t.Fatalf("Wrong variables %v for %T\n", v, message.Pdu)
+		}
+	}
+	if _, ok := (Message{Pdu: V1TrapPdu{Variables: variables}}).Variables(); ok {
+		t.Fatalf("SNMPv1 traps should not be handled\n")
+	}
+	if _, ok := (Message{}).Variables(); ok {
+		t.Fatalf("Messages without PDU should not be handled\n")
+	}
+}
+
+func TestPeekHeader(t *testing.T) {
+	version, community, err := PeekHeader(getResquestForTest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 0 || community != "publ" {
+		t.Fatalf("Wrong header: version %d, community %q\n", version, community)
+	}
+
+	data, err := EncodePdu(Version2c, "secret", GetRequestPdu{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, community, err = PeekHeader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != Version2c || community != "secret" {
+		t.Fatalf("Wrong header: version %d, community %q\n", version, community)
+	}
+
+	for _, data := range [][]byte{
+		{},
+		{0x02, 0x01, 0x00},
+		{0x30, 0x03, 0x02, 0x01, 0x00},
+		{0x30, 0x06, 0x02, 0x01, 0x00, 0x02, 0x01, 0x00},
+	} {
+		if _, _, err := PeekHeader(data); err == nil {
+			t.Fatalf("Peeking % x should fail\n", data)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package snmp
+
+import (
+	"fmt"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+// BatchGetter is a function called to return the values of several instances
+// of a table column at once, in the order of oids.
+type BatchGetter func(oids []asn1.Oid) ([]interface{}, error)
+
+// ColumnNextN is a function called to return up to count successive
+// instances of a table column after start, with their values, in ascending
+// order. Fewer instances are returned at the end of the column.
+type ColumnNextN func(start asn1.Oid, count int) ([]Variable, error)
+
+// columnBatch holds the functions retrieving several instances of a table
+// column in a single call.
+type columnBatch struct {
+	get   BatchGetter
+	nextN ColumnNextN
+}
+
+// SetColumnBatchGetter defines a function that retrieves the values of all the
+// instances of a registered table column requested by a GetRequest in a
+// single call, so a backend can fetch a row at once. The getter of the column
+// is still used by other requests.
+func (a *Agent) SetColumnBatchGetter(columnOid asn1.Oid, get BatchGetter) error {
+	h := a.lookupRelative(columnOid)
+	if h == nil || h.indexer == nil {
+		return fmt.Errorf("OID %s is not a registered table column", columnOid)
+	}
+	if h.batch == nil {
+		h.batch = &columnBatch{}
+	}
+	h.batch.get = get
+	return nil
+}
+
+// SetColumnNextN defines a function that returns several successive instances
+// of a registered table column in a single call. GetBulk requests walking the
+// column use it for all their repetitions instead of calling the getter of
+// each instance.
+func (a *Agent) SetColumnNextN(columnOid asn1.Oid, nextN ColumnNextN) error {
+	h := a.lookupRelative(columnOid)
+	if h == nil || h.indexer == nil {
+		return fmt.Errorf("OID %s is not a registered table column", columnOid)
+	}
+	if h.batch == nil {
+		h.batch = &columnBatch{}
+	}
+	h.batch.nextN = nextN
+	return nil
+}
+
+// nextInstances returns up to count instances following oid, when oid is
+// under a table column with a NextN function. The instances stop at the
+// first one out of the column or of view. Each call is a step of the walk
+// limited by SetMaxWalkSteps.
+func (a *Agent) nextInstances(oid asn1.Oid, count int, steps *int,
+	view mibView) ([]Variable, error) {
+
+	var column *managedObject
+	for i := range a.handlers {
+		h := &a.handlers[i]
+		if h.batch != nil && h.batch.nextN != nil && hasPrefix(oid, h.oid) {
+			column = h
+			break
+		}
+	}
+	if column == nil || count <= 0 {
+		return nil, nil
+	}
+	*steps++
+	if a.maxSteps > 0 && *steps > a.maxSteps {
+		return nil, nil
+	}
+	variables, err := a.batchNextN(column, oid, count)
+	if err != nil {
+		return nil, err
+	}
+	last := oid
+	for i, v := range variables {
+		if i >= count || !hasPrefix(v.Name, column.oid) ||
+			v.Name.Cmp(last) <= 0 || !view.contains(v.Name) {
+			return variables[:i], nil
+		}
+		a.accesses.touch(v.Name)
+		value, err := a.checkValue(column, v.Value, nil)
+		if err == ErrNoSuchInstance {
+			// The usual GetNext skips the instance
+			return variables[:i], nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		variables[i].Value = value
+		last = v.Name
+	}
+	return variables, nil
+}
+
+// batchNextN calls the NextN function of a column, recovering from panics
+// like getValue.
+func (a *Agent) batchNextN(column *managedObject, start asn1.Oid,
+	count int) (variables []Variable, err error) {
+
+	defer a.recoverHandler(column.oid, &err)
+	return column.batch.nextN(start, count)
+}
+
+// batchValue is the result of a batch getter for one instance.
+type batchValue struct {
+	value interface{}
+	err   error
+}
+
+// batchValues calls the batch getters of the table columns of a GetRequest,
+// once for all the instances of each column in view. The results are keyed
+// by instance OID.
+func (a *Agent) batchValues(variables []Variable, view mibView) map[string]batchValue {
+	var columns []*columnBatch
+	instances := make(map[*columnBatch][]*managedObject)
+	seen := make(map[string]bool)
+	for _, v := range variables {
+		h := a.getManagedObject(v.Name, false)
+		if h == nil || h.batch == nil || h.batch.get == nil ||
+			!view.contains(v.Name) || seen[h.oid.String()] {
+			continue
+		}
+		seen[h.oid.String()] = true
+		if instances[h.batch] == nil {
+			columns = append(columns, h.batch)
+		}
+		instances[h.batch] = append(instances[h.batch], h)
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+
+	results := make(map[string]batchValue)
+	for _, c := range columns {
+		objects := instances[c]
+		oids := make([]asn1.Oid, len(objects))
+		for i, h := range objects {
+			oids[i] = h.oid
+			a.accesses.touch(h.oid)
+		}
+		values, err := a.batchGet(c.get, oids)
+		if err == nil && len(values) != len(oids) {
+			err = VarErrorf(GenErr, "batch getter returned %d values for %d OIDs",
+				len(values), len(oids))
+		}
+		for i, h := range objects {
+			if err != nil {
+				results[h.oid.String()] = batchValue{nil, err}
+				continue
+			}
+			value, err := a.checkValue(h, values[i], nil)
+			results[h.oid.String()] = batchValue{value, err}
+		}
+	}
+	return results
+}
+
+// batchGet calls a batch getter, recovering from panics like getValue.
+func (a *Agent) batchGet(get BatchGetter, oids []asn1.Oid) (values []interface{},
+	err error) {
+
+	defer a.recoverHandler(oids[0], &err)
+	return get(oids)
+}
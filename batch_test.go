@@ -0,0 +1,110 @@
+package snmp
+
+import (
+	"testing"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+func TestColumnBatchGetter(t *testing.T) {
+
+	column := asn1.Oid{1, 3, 6, 1, 4, 1, 1, 2, 1, 2}
+	agent := NewAgent()
+	cells := 0
+	agent.AddRoTableColumn(column,
+		func(oid asn1.Oid, index []int) (interface{}, error) {
+			cells++
+			return index[0], nil
+		},
+		func() [][]int {
+			return [][]int{{1}, {2}, {3}}
+		})
+	if agent.SetColumnBatchGetter(asn1.Oid{1, 3, 6, 1}, nil) == nil {
+		t.Fatalf("Expected an error for an OID that is not a column\n")
+	}
+	var calls [][]asn1.Oid
+	err := agent.SetColumnBatchGetter(column, func(oids []asn1.Oid) ([]interface{}, error) {
+		calls = append(calls, oids)
+		values := make([]interface{}, len(oids))
+		for i, oid := range oids {
+			values[i] = int(oid[len(oid)-1]) * 10
+		}
+		return values, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := agent.ProcessMessage(&Message{
+		Community: "public",
+		Pdu: GetRequestPdu{
+			Variables: []Variable{
+				{append(column, 3), asn1.Null{}},
+				{append(column, 1), asn1.Null{}},
+				{append(column, 3), asn1.Null{}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdu := response.Pdu.(GetResponsePdu)
+	if pdu.ErrorStatus != NoError {
+		t.Fatalf("Response contains an error: %d\n", pdu.ErrorStatus)
+	}
+	if len(calls) != 1 || len(calls[0]) != 2 || cells != 0 {
+		t.Fatalf("Expected a single batch call for 2 instances, got %v and %d cells\n",
+			calls, cells)
+	}
+	for i, expected := range []int{30, 10, 30} {
+		if pdu.Variables[i].Value != expected {
+			t.Fatalf("Expected %d, got %v\n", expected, pdu.Variables[i].Value)
+		}
+	}
+
+	// Other requests still use the getter of the column
+	response, err = agent.ProcessMessage(&Message{
+		Community: "public",
+		Pdu: GetNextRequestPdu{
+			Variables: []Variable{{append(column, 1), asn1.Null{}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pdu := response.Pdu.(GetResponsePdu); pdu.Variables[0].Value != 2 || cells != 1 {
+		t.Fatalf("Expected the getter value 2, got %v\n", pdu.Variables[0].Value)
+	}
+}
+
+func TestColumnNextN(t *testing.T) {
+
+	agent := newBulkAgentForTest()
+	column := asn1.Oid{1, 3, 6, 1, 4, 1, 9999, 2, 1, 1}
+	var calls []int
+	err := agent.SetColumnNextN(column, func(start asn1.Oid, count int) ([]Variable, error) {
+		calls = append(calls, count)
+		var variables []Variable
+		for i := 1; i <= 3 && len(variables) < count; i++ {
+			if oid := append(append(asn1.Oid{}, column...), uint(i)); oid.Cmp(start) > 0 {
+				variables = append(variables, Variable{oid, 100 + i})
+			}
+		}
+		return variables, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The column ends after 3 repetitions, the walk goes on with the getters
+	pdu := getBulkForTest(t, agent, 0, 4, column)
+	checkVariables(t, pdu.Variables, []Variable{
+		{asn1.Oid{1, 3, 6, 1, 4, 1, 9999, 2, 1, 1, 1}, 101},
+		{asn1.Oid{1, 3, 6, 1, 4, 1, 9999, 2, 1, 1, 2}, 102},
+		{asn1.Oid{1, 3, 6, 1, 4, 1, 9999, 2, 1, 1, 3}, 103},
+		{asn1.Oid{1, 3, 6, 1, 4, 1, 9999, 2, 1, 2, 1}, 21},
+	})
+	if len(calls) != 1 || calls[0] != 4 {
+		t.Fatalf("Expected a single call for 4 repetitions, got %v\n", calls)
+	}
+}
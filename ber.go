@@ -0,0 +1,110 @@
+package snmp
+
+import (
+	"fmt"
+)
+
+// Universal tags of the BER elements built by hand for SNMPv3 messages.
+const (
+	tagInteger     = 2
+	tagOctetString = 4
+	tagSequence    = 16
+)
+
+// encodeElement returns the BER encoding of an element with a single byte
+// identifier.
+func encodeElement(class int, constructed bool, tag int, content []byte) []byte {
+	identifier := byte(class<<6 | tag)
+	if constructed {
+		identifier |= 0x20
+	}
+	data := []byte{identifier}
+	if n := len(content); n < 0x80 {
+		data = append(data, byte(n))
+	} else {
+		var length []byte
+		for ; n > 0; n >>= 8 {
+			length = append([]byte{byte(n)}, length...)
+		}
+		data = append(data, 0x80|byte(len(length)))
+		data = append(data, length...)
+	}
+	return append(data, content...)
+}
+
+// encodeSequence returns a SEQUENCE of already encoded elements.
+func encodeSequence(elements ...[]byte) []byte {
+	var content []byte
+	for _, e := range elements {
+		content = append(content, e...)
+	}
+	return encodeElement(ClassUniversal, true, tagSequence, content)
+}
+
+// encodeOctetString returns the BER encoding of an OCTET STRING.
+func encodeOctetString(s []byte) []byte {
+	return encodeElement(ClassUniversal, false, tagOctetString, s)
+}
+
+// encodeInteger returns the BER encoding of an INTEGER.
+func encodeInteger(n int64) []byte {
+	// Minimal two's complement representation
+	var content []byte
+	for {
+		content = append([]byte{byte(n)}, content...)
+		if n >= -0x80 && n < 0x80 {
+			break
+		}
+		n >>= 8
+	}
+	return encodeElement(ClassUniversal, false, tagInteger, content)
+}
+
+// readUniversal reads an element checking it has the given universal tag.
+func readUniversal(data []byte, tag int, name string) (e berElement,
+	rest []byte, err error) {
+
+	e, rest, err = readElement(data)
+	if err != nil {
+		return e, nil, fmt.Errorf("invalid %s: %s", name, err)
+	}
+	if e.class != ClassUniversal || e.tag != tag ||
+		e.constructed != (tag == tagSequence) {
+		return e, nil, fmt.Errorf("invalid %s: unexpected tag %s", name,
+			tagName(e.class, e.tag))
+	}
+	return e, rest, nil
+}
+
+// readInteger reads an INTEGER that fits in 32 bits, signed or not.
+func readInteger(data []byte, name string) (n int64, rest []byte, err error) {
+	e, rest, err := readUniversal(data, tagInteger, name)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(e.content) == 0 || len(e.content) > 5 {
+		return 0, nil, fmt.Errorf("invalid %s: %d bytes integer", name,
+			len(e.content))
+	}
+	n = int64(int8(e.content[0]))
+	for _, b := range e.content[1:] {
+		n = n<<8 | int64(b)
+	}
+	return n, rest, nil
+}
+
+// readOctetString reads an OCTET STRING.
+func readOctetString(data []byte, name string) (s []byte, rest []byte, err error) {
+	e, rest, err := readUniversal(data, tagOctetString, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return e.content, rest, nil
+}
+
+// offsetIn returns the position of s in data, which must be a slice of data
+// obtained with readElement. Both share the same array, so the difference of
+// their capacities is the number of bytes of data before s.
+func offsetIn(data, s []byte) int {
+	return cap(data) - cap(s)
+}
@@ -0,0 +1,51 @@
+package snmp
+
+// Bits is a value of the SMIv2 BITS construct (RFC 2578). It's encoded as an
+// OCTET STRING where bit 0 is the most significant bit of the first octet,
+// bit 8 the most significant bit of the second octet and so on.
+//
+// Bits values are decoded as strings, since they can't be distinguished from
+// an OCTET STRING. Setters can convert them back with Bits(value).
+type Bits []byte
+
+// NewBits returns a Bits value with the given bits set, ignoring negative
+// ones. Octets after the highest bit set are omitted.
+func NewBits(bits ...int) Bits {
+	b := Bits{}
+	for _, n := range bits {
+		b = b.Set(n)
+	}
+	return b
+}
+
+// Set returns b with the bit n set, growing it if needed. Negative bits
+// don't exist and are ignored.
+func (b Bits) Set(n int) Bits {
+	if n < 0 {
+		return b
+	}
+	for len(b) <= n/8 {
+		b = append(b, 0)
+	}
+	b[n/8] |= 0x80 >> uint(n%8)
+	return b
+}
+
+// IsSet checks if the bit n is set.
+func (b Bits) IsSet(n int) bool {
+	if n < 0 || n/8 >= len(b) {
+		return false
+	}
+	return b[n/8]&(0x80>>uint(n%8)) != 0
+}
+
+// Positions returns the bits set in ascending order.
+func (b Bits) Positions() []int {
+	var bits []int
+	for n := 0; n < len(b)*8; n++ {
+		if b.IsSet(n) {
+			bits = append(bits, n)
+		}
+	}
+	return bits
+}
@@ -0,0 +1,54 @@
+package snmp
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+func TestBits(t *testing.T) {
+	bits := NewBits(0, 7, 8)
+	if !bytes.Equal(bits, []byte{0x81, 0x80}) {
+		t.Fatalf("Wrong encoding %#v\n", bits)
+	}
+	for n := 0; n < 24; n++ {
+		if bits.IsSet(n) != (n == 0 || n == 7 || n == 8) {
+			t.Fatalf("Wrong value for bit %d\n", n)
+		}
+	}
+	if positions := bits.Positions(); len(positions) != 3 ||
+		positions[0] != 0 || positions[1] != 7 || positions[2] != 8 {
+		t.Fatalf("Wrong positions %v\n", positions)
+	}
+
+	// Encode as a variable value
+	ctx := Asn1Context()
+	oid := asn1.Oid{1, 3, 6, 1, 4, 1, 1, 0}
+	data, err := ctx.Encode(Variable{oid, bits})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(data, []byte{0x04, 0x02, 0x81, 0x80}) {
+		t.Fatalf("Wrong variable encoding %#v\n", data)
+	}
+	v := Variable{}
+	_, err = ctx.Decode(data, &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := v.Value.(string); !ok || !bytes.Equal(Bits(s), bits) {
+		t.Fatalf("Wrong decoded value %#v\n", v.Value)
+	}
+}
+
+func TestNegativeBits(t *testing.T) {
+	for _, n := range []int{-1, -7, -8, -100} {
+		if bits := NewBits(n, 1); !bytes.Equal(bits, []byte{0x40}) {
+			t.Fatalf("Bit %d should be ignored: %#v\n", n, bits)
+		}
+		if (Bits{0xff}).IsSet(n) {
+			t.Fatalf("Bit %d should never be set\n", n)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package snmp
+
+import (
+	"context"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+// processBulk handles a GetBulk request as defined by RFC 3416, section
+// 4.2.3. Each of the first NonRepeaters variables is answered like in a
+// GetNext. The remaining variables are walked up to MaxRepetitions times,
+// bounded by SetMaxRepetitions and by what maxSize can hold, with EndOfMibView for the variables past the end of the MIB. Repetitions
+// stop early when all of them reached the end or when the variables exceed
+// maxSize bytes (if not 0); truncateBulk then removes the repetitions that
+// don't fit. Only objects of view are returned. Table columns with a NextN
+// function return all the repetitions of a variable at once.
+func (a *Agent) processBulk(ctx context.Context, request *Message,
+	pdu BulkPdu, maxSize int, view mibView) GetResponsePdu {
+
+	nonRepeaters, repeaters := bulkVariables(pdu)
+	maxRepetitions := pdu.MaxRepetitions
+	if maxRepetitions < 0 {
+		maxRepetitions = 0
+	}
+	if a.maxRepetitions > 0 && maxRepetitions > a.maxRepetitions {
+		maxRepetitions = a.maxRepetitions
+	}
+	// Repetitions that can't fit in the response are never walked
+	if maxSize > 0 && repeaters > 0 &&
+		maxRepetitions > maxSize/(minVariableSize*repeaters) {
+		maxRepetitions = maxSize / (minVariableSize * repeaters)
+	}
+
+	res := GetResponsePdu{Identifier: pdu.Identifier}
+	variables := []Variable{}
+	size := 0
+	steps := 0
+	fail := func(index int, status int) GetResponsePdu {
+		res.ErrorIndex = index + 1
+		res.ErrorStatus = status
+		res.Variables = pdu.Variables
+		return res
+	}
+	// next returns the variable following oid, or EndOfMibView.
+	next := func(oid asn1.Oid) (Variable, bool, error) {
+		a.logf(LogDebug, "oid: %s\n", a.ResolveOid(oid))
+		h, value, err := a.nextValue(oid, &steps, view)
+		if err != nil {
+			return Variable{}, false, err
+		}
+		if h != nil {
+			return Variable{h.oid, value}, false, nil
+		}
+		if a.endOfMib != nil {
+			if r, ok := a.endOfMib(oid); ok {
+				return r, false, nil
+			}
+		}
+		return Variable{oid, EndOfMibView{}}, true, nil
+	}
+
+	for i, v := range pdu.Variables[:nonRepeaters] {
+		if err := ctx.Err(); err != nil {
+			a.logf(LogError, "request aborted: %s\n", err)
+			return fail(i, GenErr)
+		}
+		r, _, err := next(v.Name)
+		if err != nil {
+			return fail(i, errorStatus(err))
+		}
+		variables = append(variables, r)
+		size += variableSize(r)
+	}
+
+	last := pdu.Variables[nonRepeaters:]
+	ended := make([]bool, repeaters)
+	queued := make([][]Variable, repeaters)
+	drained := make([]bool, repeaters)
+	for n := 0; n < maxRepetitions && repeaters > 0; n++ {
+		if maxSize > 0 && size > maxSize {
+			break
+		}
+		repetition := make([]Variable, repeaters)
+		all := true
+		for j, v := range last {
+			if err := ctx.Err(); err != nil {
+				a.logf(LogError, "request aborted: %s\n", err)
+				return fail(nonRepeaters+j, GenErr)
+			}
+			if ended[j] {
+				repetition[j] = v
+				continue
+			}
+			if len(queued[j]) == 0 && !drained[j] {
+				var err error
+				queued[j], err = a.nextInstances(v.Name, maxRepetitions-n,
+					&steps, view)
+				if err != nil {
+					return fail(nonRepeaters+j, errorStatus(err))
+				}
+				// A short result means the end of the column
+				drained[j] = len(queued[j]) < maxRepetitions-n
+			}
+			if len(queued[j]) > 0 {
+				repetition[j] = queued[j][0]
+				queued[j] = queued[j][1:]
+				all = false
+				continue
+			}
+			r, end, err := next(v.Name)
+			if err != nil {
+				return fail(nonRepeaters+j, errorStatus(err))
+			}
+			repetition[j] = r
+			ended[j] = end
+			all = all && end
+		}
+		for _, r := range repetition {
+			size += variableSize(r)
+		}
+		variables = append(variables, repetition...)
+		last = repetition
+		if all {
+			break
+		}
+	}
+	res.Variables = variables
+	return res
+}
+
+// truncateBulk removes the last repetitions of the response to a GetBulk
+// request until it's not larger than maxSize bytes, so that rows are never
+// returned partially. The variables of the non-repeaters are kept.
+func truncateBulk(response *Message, request BulkPdu, maxSize int) GetResponsePdu {
+	res := response.Pdu.(GetResponsePdu)
+	if res.ErrorStatus != NoError {
+		return res
+	}
+	nonRepeaters, repeaters := bulkVariables(request)
+	for repeaters > 0 && len(res.Variables) > nonRepeaters &&
+		estimateMessageSize(response) > maxSize {
+
+		// Drop the last repetition, which may be incomplete
+		repetitions := (len(res.Variables) - nonRepeaters - 1) / repeaters
+		res.Variables = res.Variables[:nonRepeaters+repetitions*repeaters]
+		response.Pdu = res
+	}
+	return res
+}
+
+// bulkVariables returns the number of non-repeating and repeating variables
+// of a GetBulk request, with NonRepeaters limited to the valid range.
+func bulkVariables(pdu BulkPdu) (nonRepeaters, repeaters int) {
+	nonRepeaters = pdu.NonRepeaters
+	if nonRepeaters < 0 {
+		nonRepeaters = 0
+	}
+	if nonRepeaters > len(pdu.Variables) {
+		nonRepeaters = len(pdu.Variables)
+	}
+	return nonRepeaters, len(pdu.Variables) - nonRepeaters
+}
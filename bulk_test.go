@@ -0,0 +1,241 @@
+package snmp
+
+import (
+	"testing"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+// newBulkAgentForTest creates a SNMPv2c agent with a scalar followed by a
+// table of two columns and three rows.
+func newBulkAgentForTest() *Agent {
+	agent := NewAgent()
+	agent.SetSupportedVersions(Version2c)
+	agent.AddRoManagedObject(asn1.Oid{1, 3, 6, 1, 4, 1, 9999, 1, 0},
+		func(oid asn1.Oid) (interface{}, error) {
+			return "scalar", nil
+		})
+	for _, column := range []uint{1, 2} {
+		column := column
+		agent.AddRoTableColumn(asn1.Oid{1, 3, 6, 1, 4, 1, 9999, 2, 1, column},
+			func(oid asn1.Oid, index []int) (interface{}, error) {
+				return int(column)*10 + index[0], nil
+			},
+			func() [][]int {
+				return [][]int{{1}, {2}, {3}}
+			})
+	}
+	return agent
+}
+
+// getBulkForTest sends a GetBulk request to agent.
+func getBulkForTest(t *testing.T, agent *Agent, nonRepeaters, maxRepetitions int,
+	oids ...asn1.Oid) GetResponsePdu {
+
+	variables := make([]Variable, len(oids))
+	for i, oid := range oids {
+		variables[i] = Variable{oid, asn1.Null{}}
+	}
+	response, err := agent.ProcessMessage(&Message{
+		Version:   Version2c,
+		Community: "public",
+		Pdu: GetBulkRequestPdu{
+			Identifier:     1,
+			NonRepeaters:   nonRepeaters,
+			MaxRepetitions: maxRepetitions,
+			Variables:      variables,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdu := response.Pdu.(GetResponsePdu)
+	if pdu.ErrorStatus != NoError {
+		t.Fatalf("Response contains an error: %d\n", pdu.ErrorStatus)
+	}
+	return pdu
+}
+
+// checkVariables compares variables with the expected names and values.
+func checkVariables(t *testing.T, variables []Variable, expected []Variable) {
+	if len(variables) != len(expected) {
+		t.Fatalf("Expected %d variables, got %d: %v\n", len(expected),
+			len(variables), variables)
+	}
+	for i, e := range expected {
+		if variables[i].Name.Cmp(e.Name) != 0 || variables[i].Value != e.Value {
+			t.Fatalf("Wrong variable %d: %v instead of %v\n", i, variables[i], e)
+		}
+	}
+}
+
+func TestGetBulk(t *testing.T) {
+	agent := newBulkAgentForTest()
+	column1 := asn1.Oid{1, 3, 6, 1, 4, 1, 9999, 2, 1, 1}
+	column2 := asn1.Oid{1, 3, 6, 1, 4, 1, 9999, 2, 1, 2}
+
+	pdu := getBulkForTest(t, agent, 1, 2,
+		asn1.Oid{1, 3, 6, 1, 4, 1, 9999}, column1, column2)
+	if pdu.Identifier != 1 {
+		t.Fatalf("Wrong identifier %d\n", pdu.Identifier)
+	}
+	checkVariables(t, pdu.Variables, []Variable{
+		{asn1.Oid{1, 3, 6, 1, 4, 1, 9999, 1, 0}, "scalar"},
+		{append(column1, 1), 11},
+		{append(column2, 1), 21},
+		{append(column1, 2), 12},
+		{append(column2, 2), 22},
+	})
+}
+
+func TestGetBulkNonRepeaters(t *testing.T) {
+	agent := newBulkAgentForTest()
+	column1 := asn1.Oid{1, 3, 6, 1, 4, 1, 9999, 2, 1, 1}
+	column2 := asn1.Oid{1, 3, 6, 1, 4, 1, 9999, 2, 1, 2}
+
+	// The non-repeaters come first, then the repeaters row by row
+	pdu := getBulkForTest(t, agent, 2, 3,
+		asn1.Oid{1, 3, 6, 1, 4, 1, 9999}, append(column1, 2), column1, column2)
+	checkVariables(t, pdu.Variables, []Variable{
+		{asn1.Oid{1, 3, 6, 1, 4, 1, 9999, 1, 0}, "scalar"},
+		{append(column1, 3), 13},
+		{append(column1, 1), 11},
+		{append(column2, 1), 21},
+		{append(column1, 2), 12},
+		{append(column2, 2), 22},
+		{append(column1, 3), 13},
+		{append(column2, 3), 23},
+	})
+}
+
+func TestGetBulkEndOfMibView(t *testing.T) {
+	agent := newBulkAgentForTest()
+	column2 := asn1.Oid{1, 3, 6, 1, 4, 1, 9999, 2, 1, 2}
+	last := append(column2, 3)
+
+	// Walking stops once all variables reached the end of the MIB
+	pdu := getBulkForTest(t, agent, 0, 10, append(column2, 2))
+	checkVariables(t, pdu.Variables, []Variable{
+		{last, 23},
+		{last, EndOfMibView{}},
+	})
+
+	// Variables that reached the end keep EndOfMibView
+	pdu = getBulkForTest(t, agent, 0, 3, append(column2, 1), last)
+	checkVariables(t, pdu.Variables, []Variable{
+		{append(column2, 2), 22},
+		{last, EndOfMibView{}},
+		{last, 23},
+		{last, EndOfMibView{}},
+		{last, EndOfMibView{}},
+		{last, EndOfMibView{}},
+	})
+
+	// Non-repeaters past the end
+	pdu = getBulkForTest(t, agent, 1, 0, last)
+	checkVariables(t, pdu.Variables, []Variable{{last, EndOfMibView{}}})
+}
+
+func TestGetBulkTruncation(t *testing.T) {
+	agent := newBulkAgentForTest()
+	column1 := asn1.Oid{1, 3, 6, 1, 4, 1, 9999, 2, 1, 1}
+	column2 := asn1.Oid{1, 3, 6, 1, 4, 1, 9999, 2, 1, 2}
+
+	pdu := getBulkForTest(t, agent, 0, 3, column1, column2)
+	full := &Message{Version: Version2c, Community: "public", Pdu: pdu}
+	twoRows := &Message{Version: Version2c, Community: "public",
+		Pdu: GetResponsePdu{Identifier: 1, Variables: pdu.Variables[:4]}}
+
+	// Only complete rows are returned
+	agent.SetMaxResponseSize(estimateMessageSize(full) - 1)
+	pdu = getBulkForTest(t, agent, 0, 3, column1, column2)
+	if len(pdu.Variables) != 4 {
+		t.Fatalf("Expected 2 rows, got %v\n", pdu.Variables)
+	}
+	agent.SetMaxResponseSize(estimateMessageSize(twoRows) - 1)
+	pdu = getBulkForTest(t, agent, 0, 3, column1, column2)
+	if len(pdu.Variables) != 2 {
+		t.Fatalf("Expected 1 row, got %v\n", pdu.Variables)
+	}
+}
+
+func TestGetBulkVersion1(t *testing.T) {
+	agent := newBulkAgentForTest()
+	agent.SetSupportedVersions(Version1, Version2c)
+	_, err := agent.ProcessMessage(&Message{
+		Version:   Version1,
+		Community: "public",
+		Pdu:       GetBulkRequestPdu{MaxRepetitions: 1},
+	})
+	if e, ok := err.(ProcessError); !ok || e.Kind != Unsupported {
+		t.Fatalf("GetBulk should not be supported in SNMPv1: %v\n", err)
+	}
+}
+
+func TestGetBulkDatagram(t *testing.T) {
+	agent := newBulkAgentForTest()
+	last := asn1.Oid{1, 3, 6, 1, 4, 1, 9999, 2, 1, 2, 3}
+	data, err := EncodePdu(Version2c, "public", GetBulkRequestPdu{
+		Identifier:     7,
+		MaxRepetitions: 2,
+		Variables:      []Variable{{last, asn1.Null{}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = agent.ProcessDatagram(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := DecodeMessage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdu := response.Pdu.(GetResponsePdu)
+	if pdu.Identifier != 7 {
+		t.Fatalf("Wrong identifier %d\n", pdu.Identifier)
+	}
+	checkVariables(t, pdu.Variables, []Variable{{last, EndOfMibView{}}})
+}
+
+func TestGetBulkMaxRepetitions(t *testing.T) {
+	agent := newBulkAgentForTest()
+	column1 := asn1.Oid{1, 3, 6, 1, 4, 1, 9999, 2, 1, 1}
+	var counts []int
+	agent.SetColumnNextN(column1, func(start asn1.Oid, count int) ([]Variable, error) {
+		counts = append(counts, count)
+		return nil, nil
+	})
+
+	tests := []struct {
+		limit, expected int
+	}{
+		{0, 1000},
+		{2, 2},
+	}
+	for _, test := range tests {
+		if test.limit > 0 {
+			agent.SetMaxRepetitions(test.limit)
+		}
+		counts = nil
+		pdu := getBulkForTest(t, agent, 0, 1000000, column1)
+		if len(counts) != 1 || counts[0] != test.expected {
+			t.Fatalf("Expected a NextN call for %d instances, got %v\n",
+				test.expected, counts)
+		}
+		if len(pdu.Variables) > test.expected {
+			t.Fatalf("Expected at most %d variables, got %d\n", test.expected,
+				len(pdu.Variables))
+		}
+	}
+
+	// The repetitions are also bounded by the response size
+	agent.SetMaxRepetitions(0)
+	agent.SetMaxResponseSize(700)
+	counts = nil
+	getBulkForTest(t, agent, 0, 1000000, column1)
+	if len(counts) != 1 || counts[0] != 700/minVariableSize {
+		t.Fatalf("Expected a NextN call for %d instances, got %v\n",
+			700/minVariableSize, counts)
+	}
+}
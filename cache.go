@@ -0,0 +1,48 @@
+package snmp
+
+import (
+	"sync"
+	"time"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+// AddCachedRoManagedObject registers a read-only managed object whose value is
+// kept for ttl after each successful call to getter. Requests received within
+// that window are answered with the cached value.
+func (a *Agent) AddCachedRoManagedObject(oid asn1.Oid, getter Getter,
+	ttl time.Duration) error {
+
+	if getter == nil {
+		return a.AddRoManagedObject(oid, nil)
+	}
+	cache := &valueCache{get: getter, ttl: ttl}
+	return a.AddRoManagedObject(oid, cache.Get)
+}
+
+// valueCache memoizes the value returned by a Getter.
+type valueCache struct {
+	sync.Mutex
+	get     Getter
+	ttl     time.Duration
+	value   interface{}
+	expires time.Time
+}
+
+// Get returns the cached value or calls the getter when it has expired. Errors
+// are never cached.
+func (c *valueCache) Get(oid asn1.Oid) (interface{}, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	now := timeNow()
+	if now.Before(c.expires) {
+		return c.value, nil
+	}
+	value, err := c.get(oid)
+	if err != nil {
+		return nil, err
+	}
+	c.value, c.expires = value, now.Add(c.ttl)
+	return value, nil
+}
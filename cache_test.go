@@ -0,0 +1,46 @@
+package snmp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+func TestCachedGet(t *testing.T) {
+
+	uptimeOid := asn1.Oid{1, 3, 6, 1, 2, 1, 1, 3, 0}
+	data := getResquestForTest()
+
+	clock := time.Now()
+	timeNow = func() time.Time { return clock }
+	defer func() { timeNow = time.Now }()
+
+	calls := 0
+	agent := NewAgent()
+	agent.SetCommunities("publ", "priv")
+	agent.AddCachedRoManagedObject(uptimeOid,
+		func(oid asn1.Oid) (interface{}, error) {
+			calls++
+			return calls, nil
+		}, 50*time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		_, err := agent.ProcessDatagram(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("Getter should be called once. Got %d calls.\n", calls)
+	}
+
+	clock = clock.Add(50 * time.Millisecond)
+	_, err := agent.ProcessDatagram(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Fatalf("Cache should expire after the TTL. Got %d calls.\n", calls)
+	}
+}
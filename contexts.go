@@ -0,0 +1,43 @@
+package snmp
+
+import (
+	"fmt"
+)
+
+// AddContext creates a SNMPv3 context, whose managed objects are registered
+// in the returned agent. Requests with the name of the context in their
+// scoped PDU are processed by it, with its own processing options, after
+// being authenticated and authorized by a. The managed objects registered in
+// a are those of the default context, with an empty name. SNMPv1 and SNMPv2c
+// requests always use the default context.
+func (a *Agent) AddContext(name string) (*Agent, error) {
+	if name == "" || len(name) > 32 {
+		return nil, fmt.Errorf("invalid context name length %d", len(name))
+	}
+	if _, ok := a.contexts[name]; ok {
+		return nil, fmt.Errorf("context %s already exists", name)
+	}
+	context := NewAgent()
+	context.versions = []int{Version3}
+	context.logLevel = a.logLevel
+	context.SetLogger(a.log)
+	if a.contexts == nil {
+		a.contexts = make(map[string]*Agent)
+	}
+	a.contexts[name] = context
+	return context, nil
+}
+
+// Context returns the agent of a context created by AddContext, or nil.
+func (a *Agent) Context(name string) *Agent {
+	return a.contexts[name]
+}
+
+// context returns the agent processing the requests of a context, a itself
+// for the default one, or nil for unknown contexts.
+func (a *Agent) context(name string) *Agent {
+	if name == "" {
+		return a
+	}
+	return a.contexts[name]
+}
@@ -0,0 +1,119 @@
+package snmp
+
+import (
+	"testing"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+// getContextForTest sends a SNMPv3 Get of sysName.0 in a context.
+func getContextForTest(t *testing.T, agent *Agent, user UsmUser,
+	context string) (GetResponsePdu, error) {
+
+	data, err := agent.encodeV3(42, user.securityLevel()|FlagReportable, user,
+		ScopedPdu{ContextName: context, Pdu: GetRequestPdu{Identifier: 7,
+			Variables: []Variable{
+				{asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0}, asn1.Null{}}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = agent.ProcessDatagram(data)
+	if err != nil {
+		return GetResponsePdu{}, err
+	}
+	return decodeV3ResponseForTest(t, data, user), nil
+}
+
+func TestContexts(t *testing.T) {
+	user := newUsmUserForTest("md5", AuthMD5, NoPriv)
+	agent := newV3AgentForTest(t, user)
+	vrf, err := agent.AddContext("vrf1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vrf.AddRoManagedObject(asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0},
+		func(oid asn1.Oid) (interface{}, error) {
+			return "vrf1", nil
+		})
+	if _, err := agent.AddContext("vrf1"); err == nil {
+		t.Fatal("Contexts can't be added twice.")
+	}
+	if agent.Context("vrf1") != vrf || agent.Context("vrf2") != nil {
+		t.Fatal("Wrong context returned.")
+	}
+
+	for context, expected := range map[string]string{"": "name", "vrf1": "vrf1"} {
+		pdu, err := getContextForTest(t, agent, user, context)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pdu.Variables[0].Value != expected {
+			t.Fatalf("Wrong value in context %q: %v\n", context, pdu.Variables)
+		}
+	}
+
+	// Unknown contexts are reported to reportable messages, at their
+	// security level, and counted
+	for i, flags := range []int{FlagAuth | FlagReportable, FlagAuth} {
+		data, err := agent.encodeV3(42, flags, user, ScopedPdu{
+			ContextName: "vrf2", Pdu: GetRequestPdu{Identifier: 7}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err = agent.ProcessDatagram(data)
+		if flags&FlagReportable == 0 {
+			if e, ok := err.(ProcessError); !ok || e.Kind != Drop {
+				t.Fatalf("Unknown contexts should be dropped: %v\n", err)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := DecodeV3Message(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		report, ok := m.ScopedPdu.Pdu.(ReportPdu)
+		if !ok || m.Flags != FlagAuth || report.Identifier != 7 ||
+			len(report.Variables) != 1 ||
+			report.Variables[0].Name.Cmp(snmpUnknownContextsOid) != 0 ||
+			report.Variables[0].Value != Counter32(i+1) {
+			t.Fatalf("Wrong report of an unknown context: %#v\n", m)
+		}
+	}
+	if v := agent.snmp.unknownContexts; v != 2 {
+		t.Fatalf("Expected 2 unknown contexts, got %d\n", v)
+	}
+}
+
+func TestContextAccess(t *testing.T) {
+	user := newUsmUserForTest("md5", AuthMD5, NoPriv)
+	agent := newV3AgentForTest(t, user)
+	for _, name := range []string{"vrf1", "tenant1"} {
+		context, _ := agent.AddContext(name)
+		context.AddRoManagedObject(asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0},
+			func(oid asn1.Oid) (interface{}, error) {
+				return "name", nil
+			})
+	}
+	agent.AddViewSubtree("all", asn1.Oid{1}, nil, true)
+	agent.SetGroup(SecurityModelUsm, user.Name, "vrfs")
+	agent.SetGroupAccess("vrfs", GroupAccess{Context: "vrf",
+		ContextPrefix: true, SecurityLevel: FlagAuth, ReadView: "all"})
+
+	tests := map[string]interface{}{
+		"vrf1":    "name",
+		"tenant1": NoSuchObject{},
+		"":        NoSuchObject{},
+	}
+	for context, expected := range tests {
+		pdu, err := getContextForTest(t, agent, user, context)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pdu.Variables[0].Value != expected {
+			t.Fatalf("Wrong value in context %q: %v\n", context, pdu.Variables)
+		}
+	}
+}
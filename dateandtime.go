@@ -0,0 +1,81 @@
+package snmp
+
+import (
+	"fmt"
+	"time"
+)
+
+// DateAndTime is a value of the DateAndTime textual convention (RFC 2579): an
+// OCTET STRING of 8 or 11 bytes holding the year (2 bytes), month, day, hour,
+// minutes, seconds, deci-seconds and optionally the direction ('+' or '-'),
+// hours and minutes from UTC.
+//
+// DateAndTime values are decoded as strings, since they can't be
+// distinguished from an OCTET STRING. Setters can convert them back with
+// DateAndTime(value).
+type DateAndTime []byte
+
+// NewDateAndTime converts a time to the 11 bytes form, including the offset
+// of its location from UTC. Precision is limited to deci-seconds.
+func NewDateAndTime(t time.Time) DateAndTime {
+	_, offset := t.Zone()
+	direction := byte('+')
+	if offset < 0 {
+		direction = '-'
+		offset = -offset
+	}
+	return DateAndTime{
+		byte(t.Year() >> 8), byte(t.Year()),
+		byte(t.Month()),
+		byte(t.Day()),
+		byte(t.Hour()),
+		byte(t.Minute()),
+		byte(t.Second()),
+		byte(t.Nanosecond() / 1e8),
+		direction,
+		byte(offset / 3600),
+		byte(offset % 3600 / 60),
+	}
+}
+
+// Time converts the value back to a time. Values without the offset from UTC
+// are considered local time.
+func (d DateAndTime) Time() (time.Time, error) {
+	if len(d) != 8 && len(d) != 11 {
+		return time.Time{}, fmt.Errorf("invalid DateAndTime length %d", len(d))
+	}
+	if d[2] < 1 || d[2] > 12 || d[3] < 1 || d[3] > 31 || d[4] > 23 ||
+		d[5] > 59 || d[6] > 60 || d[7] > 9 {
+		return time.Time{}, fmt.Errorf("invalid DateAndTime % x", []byte(d))
+	}
+	location := time.Local
+	if len(d) == 11 {
+		// RFC 2579 limits the hours to 13, but some zones are 14 hours ahead
+		if (d[8] != '+' && d[8] != '-') || d[9] > 14 || d[10] > 59 {
+			return time.Time{}, fmt.Errorf("invalid DateAndTime offset % x",
+				[]byte(d[8:]))
+		}
+		offset := int(d[9])*3600 + int(d[10])*60
+		if d[8] == '-' {
+			offset = -offset
+		}
+		location = time.FixedZone("", offset)
+	}
+	year := int(d[0])<<8 | int(d[1])
+	return time.Date(year, time.Month(d[2]), int(d[3]), int(d[4]), int(d[5]),
+		int(d[6]), int(d[7])*1e8, location), nil
+}
+
+// String returns the time in the format of the DISPLAY-HINT of the textual
+// convention, like "2024-3-9,14:5:30.2,+1:0".
+func (d DateAndTime) String() string {
+	if _, err := d.Time(); err != nil {
+		return fmt.Sprintf("% x", []byte(d))
+	}
+	s := fmt.Sprintf("%d-%d-%d,%d:%d:%d.%d", int(d[0])<<8|int(d[1]), d[2], d[3],
+		d[4], d[5], d[6], d[7])
+	if len(d) == 11 {
+		s += fmt.Sprintf(",%c%d:%d", d[8], d[9], d[10])
+	}
+	return s
+}
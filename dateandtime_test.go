@@ -0,0 +1,92 @@
+package snmp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+func TestDateAndTime(t *testing.T) {
+	ctx := Asn1Context()
+	oid := asn1.Oid{1, 3, 6, 1, 4, 1, 1, 0}
+	zone := time.FixedZone("", -(3*3600 + 30*60))
+	tm := time.Date(2024, time.March, 9, 14, 5, 30, 200000000, zone)
+
+	d := NewDateAndTime(tm)
+	expected := DateAndTime{0x07, 0xe8, 3, 9, 14, 5, 30, 2, '-', 3, 30}
+	if string(d) != string(expected) {
+		t.Fatalf("Wrong encoding % x\n", []byte(d))
+	}
+	if d.String() != "2024-3-9,14:5:30.2,-3:30" {
+		t.Fatalf("Wrong string %s\n", d)
+	}
+
+	data, err := ctx.Encode(Variable{oid, d})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := Variable{}
+	if _, err = ctx.Decode(data, &v); err != nil {
+		t.Fatal(err)
+	}
+	s, ok := v.Value.(string)
+	if !ok {
+		t.Fatalf("Invalid value type: %T\n", v.Value)
+	}
+	decoded, err := DateAndTime(s).Time()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Equal(tm) {
+		t.Fatalf("Wrong time %s instead of %s\n", decoded, tm)
+	}
+	if _, offset := decoded.Zone(); offset != -(3*3600 + 30*60) {
+		t.Fatalf("Wrong offset %d\n", offset)
+	}
+}
+
+func TestDateAndTimeLineIslands(t *testing.T) {
+	// UTC+14 is beyond the 13 hours of RFC 2579
+	tm := time.Date(2024, time.March, 9, 14, 5, 30, 0, time.FixedZone("", 14*3600))
+	d := NewDateAndTime(tm)
+	if d[8] != '+' || d[9] != 14 || d[10] != 0 {
+		t.Fatalf("Wrong offset % x\n", []byte(d[8:]))
+	}
+	decoded, err := d.Time()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Equal(tm) {
+		t.Fatalf("Wrong time %s instead of %s\n", decoded, tm)
+	}
+	d[9] = 15
+	if _, err := d.Time(); err == nil {
+		t.Fatalf("Invalid offset % x should fail\n", []byte(d[8:]))
+	}
+}
+
+func TestDateAndTimeWithoutZone(t *testing.T) {
+	d := DateAndTime{0x07, 0xe8, 3, 9, 14, 5, 30, 2}
+	tm, err := d.Time()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := time.Date(2024, time.March, 9, 14, 5, 30, 200000000, time.Local)
+	if !tm.Equal(expected) {
+		t.Fatalf("Wrong time %s instead of %s\n", tm, expected)
+	}
+	if d.String() != "2024-3-9,14:5:30.2" {
+		t.Fatalf("Wrong string %s\n", d)
+	}
+
+	for _, d := range []DateAndTime{
+		{0x07, 0xe8, 3, 9, 14, 5, 30},
+		{0x07, 0xe8, 13, 9, 14, 5, 30, 2},
+		{0x07, 0xe8, 3, 9, 14, 5, 30, 2, '*', 0, 0},
+	} {
+		if _, err := d.Time(); err == nil {
+			t.Fatalf("Invalid value % x should fail\n", []byte(d))
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package snmp
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Describe returns a human readable summary of the agent configuration,
+// useful for diagnostics. The private community and those added by
+// AddCommunity are never included, only their length.
+func (a *Agent) Describe() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "public community: %s\n", printableCommunity(a.public))
+	fmt.Fprintf(&b, "private community: <%d bytes>\n", len(a.private))
+	for _, community := range a.communities {
+		if community == a.public || community == a.private {
+			continue
+		}
+		fmt.Fprintf(&b, "community of group %q: <%d bytes>\n",
+			a.communityGroup(community), len(community))
+	}
+	fmt.Fprintf(&b, "managed objects: %d\n", len(a.handlers))
+	fmt.Fprintf(&b, "supported versions:")
+	for _, version := range a.versions {
+		fmt.Fprintf(&b, " %s", versionName(version))
+	}
+	fmt.Fprintf(&b, "\n")
+	if a.maxSize > 0 {
+		fmt.Fprintf(&b, "max response size: %d\n", a.maxSize)
+	} else {
+		fmt.Fprintf(&b, "max response size: unlimited\n")
+	}
+	if a.maxSteps > 0 {
+		fmt.Fprintf(&b, "max walk steps: %d\n", a.maxSteps)
+	} else {
+		fmt.Fprintf(&b, "max walk steps: unlimited\n")
+	}
+	return b.String()
+}
+
+// communityGroup returns the VACM group of a community.
+func (a *Agent) communityGroup(community string) string {
+	a.vacm.Lock()
+	defer a.vacm.Unlock()
+	return a.vacm.groups[vacmSecurity{SecurityModelV2c, community}]
+}
+
+// versionName returns the usual name of an SNMP version.
+func versionName(version int) string {
+	switch version {
+	case Version1:
+		return "v1"
+	case Version2c:
+		return "v2c"
+	case Version3:
+		return "v3"
+	}
+	return fmt.Sprintf("unknown(%d)", version)
+}
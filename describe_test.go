@@ -0,0 +1,31 @@
+package snmp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+func TestDescribe(t *testing.T) {
+	agent := NewAgent()
+	agent.SetCommunities("publ", "s3cr3t")
+	agent.AddCommunity("m0nit0r", "monitoring")
+	agent.AddRoManagedObject(asn1.Oid{1, 3, 6, 1, 2, 1, 1, 3, 0},
+		func(oid asn1.Oid) (interface{}, error) {
+			return 0, nil
+		})
+
+	description := agent.Describe()
+	for _, s := range []string{"s3cr3t", "m0nit0r"} {
+		if strings.Contains(description, s) {
+			t.Fatalf("Description contains the community %q:\n%s", s, description)
+		}
+	}
+	for _, s := range []string{"\"publ\"", "managed objects: 1", "v1",
+		"community of group \"monitoring\": <7 bytes>"} {
+		if !strings.Contains(description, s) {
+			t.Fatalf("Description is missing %q:\n%s", s, description)
+		}
+	}
+}
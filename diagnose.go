@@ -0,0 +1,135 @@
+package snmp
+
+import (
+	"fmt"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+// berElement is a BER element read by readElement.
+type berElement struct {
+	class       int
+	constructed bool
+	tag         int
+	content     []byte
+	// raw is the whole encoding of the element.
+	raw []byte
+}
+
+// readElement reads a BER element with a single byte identifier.
+func readElement(data []byte) (e berElement, rest []byte, err error) {
+	raw := data
+	if len(data) < 2 {
+		err = fmt.Errorf("truncated element")
+		return
+	}
+	e.class = int(data[0] >> 6)
+	e.constructed = data[0]&0x20 != 0
+	e.tag = int(data[0] & 0x1f)
+	if e.tag == 0x1f {
+		err = fmt.Errorf("multi-byte tags are not supported")
+		return
+	}
+	length, data := int(data[1]), data[2:]
+	if length&0x80 != 0 {
+		n := length & 0x7f
+		if n == 0 || n > 4 || len(data) < n {
+			err = fmt.Errorf("invalid length")
+			return
+		}
+		length = 0
+		for _, b := range data[:n] {
+			length = length<<8 | int(b)
+		}
+		data = data[n:]
+	}
+	if length > len(data) {
+		err = fmt.Errorf("truncated element")
+		return
+	}
+	e.content = data[:length]
+	e.raw = raw[:len(raw)-len(data)+length]
+	return e, data[length:], nil
+}
+
+// tagName returns the ASN.1 notation of a class and tag, like
+// "[APPLICATION 1]".
+func tagName(class, tag int) string {
+	switch class {
+	case ClassUniversal:
+		return fmt.Sprintf("[UNIVERSAL %d]", tag)
+	case ClassApplication:
+		return fmt.Sprintf("[APPLICATION %d]", tag)
+	case ClassContextSpecific:
+		return fmt.Sprintf("[%d]", tag)
+	}
+	return fmt.Sprintf("[PRIVATE %d]", tag)
+}
+
+// knownValueTag checks if a class and tag identify one of the types listed in
+// NewVariable or, if exceptions is set, one of the SNMPv2 exceptions.
+func knownValueTag(class, tag int, exceptions bool) bool {
+	values := []interface{}{0, "", asn1.Null{}, asn1.Oid{}, IPAddress{},
+		Counter32(0), Unsigned32(0), TimeTicks(0), Opaque{}, Counter64(0)}
+	if exceptions {
+		values = append(values, NoSuchObject{}, NoSuchInstance{},
+			EndOfMibView{})
+	}
+	for _, v := range values {
+		if c, t, _ := ValueTag(v); c == class && t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// describeValueError looks for a variable whose value has an unknown tag in a
+// message that failed to decode. It returns nil if none is found.
+func describeValueError(data []byte, exceptions bool) error {
+	message, _, err := readElement(data)
+	if err != nil {
+		return nil
+	}
+	// Skip version and community
+	content := message.content
+	for i := 0; i < 2; i++ {
+		if _, content, err = readElement(content); err != nil {
+			return nil
+		}
+	}
+	pdu, _, err := readElement(content)
+	if err != nil || !pdu.constructed {
+		return nil
+	}
+	// Skip the fields before the variable bindings
+	var element berElement
+	content = pdu.content
+	for len(content) > 0 {
+		if element, content, err = readElement(content); err != nil {
+			return nil
+		}
+	}
+	for variables := element.content; len(variables) > 0; {
+		var variable, name, value berElement
+		if variable, variables, err = readElement(variables); err != nil {
+			return nil
+		}
+		rest := variable.content
+		if name, rest, err = readElement(rest); err != nil {
+			return nil
+		}
+		if value, _, err = readElement(rest); err != nil {
+			return nil
+		}
+		if !knownValueTag(value.class, value.tag, exceptions) {
+			var oid asn1.Oid
+			if _, err := Asn1Context().Decode(name.raw, &oid); err != nil {
+				return fmt.Errorf("unsupported value tag %s",
+					tagName(value.class, value.tag))
+			}
+			return fmt.Errorf("unsupported value tag %s for OID %s",
+				tagName(value.class, value.tag), oid)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package snmp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+func TestDecodeValueTags(t *testing.T) {
+
+	counterOid := asn1.Oid{1, 3, 6, 1, 2, 1, 2, 2, 1, 10, 1}
+	data, err := EncodePdu(Version1, "private", SetRequestPdu{
+		Variables: []Variable{{counterOid, Counter32(5)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The value is the last element: 0x41 0x01 0x05
+	if data[len(data)-3] != 0x41 {
+		t.Fatalf("Wrong Counter32 encoding %x\n", data)
+	}
+
+	agent := NewAgent()
+	if err := agent.ValidateDatagram(data); err == nil ||
+		strings.Contains(err.Error(), "invalid message") {
+		t.Fatalf("Counter32 should be decoded: %v\n", err)
+	}
+
+	// [APPLICATION 5] is not a valid type
+	data[len(data)-3] = 0x45
+	_, err = agent.ProcessDatagram(data)
+	expected := "unsupported value tag [APPLICATION 5] for OID " + counterOid.String()
+	if err == nil || !strings.Contains(err.Error(), expected) {
+		t.Fatalf("Wrong error %q\n", err)
+	}
+	if e, ok := err.(ProcessError); !ok || e.Kind != Drop {
+		t.Fatalf("Invalid message should be dropped: %v\n", err)
+	}
+}
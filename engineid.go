@@ -0,0 +1,160 @@
+package snmp
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"time"
+)
+
+// EngineID is a SNMP engine ID (RFC 3411). Engine IDs built by this package
+// use the format with the enterprise number of the implementation followed
+// by a format byte and its data.
+type EngineID []byte
+
+// Formats of engine IDs (RFC 3411, SnmpEngineID).
+const (
+	EngineIDIPv4   = 1
+	EngineIDIPv6   = 2
+	EngineIDMAC    = 3
+	EngineIDText   = 4
+	EngineIDOctets = 5
+)
+
+// NewEngineID builds an engine ID for an enterprise number, with data in the
+// given format. IP and MAC addresses must have their exact length and text
+// and octets formats from 1 to 27 bytes.
+func NewEngineID(enterprise uint32, format int, data []byte) (EngineID, error) {
+	valid := false
+	switch format {
+	case EngineIDIPv4:
+		valid = len(data) == net.IPv4len
+	case EngineIDIPv6:
+		valid = len(data) == net.IPv6len
+	case EngineIDMAC:
+		valid = len(data) == 6
+	case EngineIDText, EngineIDOctets:
+		valid = len(data) > 0 && len(data) <= 27
+	default:
+		return nil, fmt.Errorf("invalid engine ID format %d", format)
+	}
+	if !valid {
+		return nil, fmt.Errorf("invalid data length %d for engine ID format %d",
+			len(data), format)
+	}
+	id := make(EngineID, 5, 5+len(data))
+	binary.BigEndian.PutUint32(id, enterprise|0x80000000)
+	id[4] = byte(format)
+	return append(id, data...), nil
+}
+
+// NewIPEngineID builds an engine ID from an IPv4 or IPv6 address.
+func NewIPEngineID(enterprise uint32, ip net.IP) (EngineID, error) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return NewEngineID(enterprise, EngineIDIPv4, ip4)
+	}
+	return NewEngineID(enterprise, EngineIDIPv6, ip)
+}
+
+// NewMACEngineID builds an engine ID from a MAC address.
+func NewMACEngineID(enterprise uint32, mac net.HardwareAddr) (EngineID, error) {
+	return NewEngineID(enterprise, EngineIDMAC, mac)
+}
+
+// NewRandomEngineID builds an engine ID of the octets format with 8 random
+// bytes, as done for the default engine ID of agents.
+func NewRandomEngineID(enterprise uint32) EngineID {
+	data := make([]byte, 8)
+	rand.Read(data)
+	id, _ := NewEngineID(enterprise, EngineIDOctets, data)
+	return id
+}
+
+// Enterprise returns the enterprise number of the engine ID.
+func (id EngineID) Enterprise() uint32 {
+	if len(id) < 4 {
+		return 0
+	}
+	return binary.BigEndian.Uint32(id) &^ 0x80000000
+}
+
+// Format returns the format of the engine ID, 0 for engine IDs of the SNMPv1
+// format (without the leading bit).
+func (id EngineID) Format() int {
+	if len(id) < 5 || id[0]&0x80 == 0 {
+		return 0
+	}
+	return int(id[4])
+}
+
+// String returns the engine ID in hexadecimal, as usually configured in
+// managers.
+func (id EngineID) String() string {
+	return hex.EncodeToString(id)
+}
+
+// EngineStore saves the SNMPv3 engine boots and time of an agent, so that
+// they are kept across restarts (RFC 3414, section 2.2.2).
+type EngineStore interface {
+	// LoadEngineState returns the state saved for an engine ID, zeros if
+	// there is none.
+	LoadEngineState(id EngineID) (boots, engineTime int, err error)
+	// SaveEngineState saves the state of an engine ID.
+	SaveEngineState(id EngineID, boots, engineTime int) error
+}
+
+// SetEngineID defines the SNMPv3 engine ID of the agent, from 5 to 32 bytes.
+// By default a random engine ID is used. Localized keys depend on the engine
+// ID, so it should be defined before users are added.
+func (a *Agent) SetEngineID(id EngineID) error {
+	if len(id) < 5 || len(id) > 32 {
+		return fmt.Errorf("invalid engine ID length %d", len(id))
+	}
+	a.usm.Lock()
+	defer a.usm.Unlock()
+	a.usm.engineID = append(EngineID{}, id...)
+	return nil
+}
+
+// EngineID returns the SNMPv3 engine ID of the agent.
+func (a *Agent) EngineID() EngineID {
+	return append(EngineID{}, a.usm.id()...)
+}
+
+// SetEngineStore restores the engine state saved in store. The engine boots
+// is incremented and saved, and the engine time restarts from zero, as
+// required on each restart of the agent. The engine ID should be defined
+// before.
+func (a *Agent) SetEngineStore(store EngineStore) error {
+	a.usm.Lock()
+	defer a.usm.Unlock()
+	boots, _, err := store.LoadEngineState(a.usm.engineID)
+	if err != nil {
+		return err
+	}
+	if boots < maxEngineBoots {
+		boots++
+	}
+	if err = store.SaveEngineState(a.usm.engineID, boots, 0); err != nil {
+		return err
+	}
+	a.usm.store = store
+	a.usm.boots = boots
+	a.usm.start = time.Now()
+	return nil
+}
+
+// SaveEngineState saves the current engine boots and time in the store
+// defined by SetEngineStore, like when the agent is stopped.
+func (a *Agent) SaveEngineState() error {
+	boots, engineTime := a.usm.clock()
+	a.usm.Lock()
+	store := a.usm.store
+	a.usm.Unlock()
+	if store == nil {
+		return fmt.Errorf("no engine store defined")
+	}
+	return store.SaveEngineState(a.EngineID(), boots, engineTime)
+}
@@ -0,0 +1,117 @@
+package snmp
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNewEngineID(t *testing.T) {
+	tests := []struct {
+		id       EngineID
+		expected string
+	}{
+		{mustEngineID(NewIPEngineID(9999, net.IPv4(192, 168, 0, 1))),
+			"8000270f01c0a80001"},
+		{mustEngineID(NewMACEngineID(9999,
+			net.HardwareAddr{0, 1, 2, 3, 4, 5})), "8000270f03000102030405"},
+		{mustEngineID(NewEngineID(9999, EngineIDText, []byte("agent"))),
+			"8000270f046167656e74"},
+	}
+	for _, test := range tests {
+		if test.id.String() != test.expected {
+			t.Fatalf("Wrong engine ID %s, expected %s\n", test.id, test.expected)
+		}
+		if test.id.Enterprise() != 9999 {
+			t.Fatalf("Wrong enterprise %d\n", test.id.Enterprise())
+		}
+	}
+	if id := mustEngineID(NewIPEngineID(1, net.ParseIP("::1"))); len(id) != 21 ||
+		id.Format() != EngineIDIPv6 {
+		t.Fatalf("Wrong IPv6 engine ID %s\n", id)
+	}
+
+	if _, err := NewEngineID(1, EngineIDText, bytes.Repeat([]byte{'a'}, 28)); err == nil {
+		t.Fatal("Long engine IDs should be refused.")
+	}
+	if _, err := NewEngineID(1, EngineIDMAC, []byte{1, 2, 3}); err == nil {
+		t.Fatal("Invalid MAC addresses should be refused.")
+	}
+	if _, err := NewEngineID(1, 200, []byte{1, 2, 3}); err == nil {
+		t.Fatal("Invalid formats should be refused.")
+	}
+	random := NewRandomEngineID(1)
+	if random.Format() != EngineIDOctets ||
+		bytes.Equal(random, NewRandomEngineID(1)) {
+		t.Fatalf("Wrong random engine ID %s\n", random)
+	}
+}
+
+// mustEngineID returns the engine ID of a constructor that can't fail.
+func mustEngineID(id EngineID, err error) EngineID {
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+func TestEngineID(t *testing.T) {
+	agent := NewAgent()
+	if id := agent.EngineID(); len(id) < 5 || id[0]&0x80 == 0 {
+		t.Fatalf("Invalid default engine ID %x\n", id)
+	}
+	if bytes.Equal(NewAgent().EngineID(), agent.EngineID()) {
+		t.Fatal("Default engine IDs should be random.")
+	}
+	id := EngineID{0x80, 0, 0, 0, 4, 't', 'e', 's', 't'}
+	if err := agent.SetEngineID(id); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(agent.EngineID(), id) {
+		t.Fatalf("Wrong engine ID %x\n", agent.EngineID())
+	}
+	if agent.SetEngineID(id[:4]) == nil {
+		t.Fatal("Short engine IDs should be refused.")
+	}
+}
+
+// memoryEngineStore keeps engine states in memory.
+type memoryEngineStore map[string][2]int
+
+func (s memoryEngineStore) LoadEngineState(id EngineID) (int, int, error) {
+	state := s[id.String()]
+	return state[0], state[1], nil
+}
+
+func (s memoryEngineStore) SaveEngineState(id EngineID, boots, engineTime int) error {
+	s[id.String()] = [2]int{boots, engineTime}
+	return nil
+}
+
+func TestEngineStore(t *testing.T) {
+	store := memoryEngineStore{}
+	agent := NewAgent()
+	if err := agent.SaveEngineState(); err == nil {
+		t.Fatal("Saving without a store should fail.")
+	}
+	for _, expected := range []int{1, 2, 3} {
+		if err := agent.SetEngineStore(store); err != nil {
+			t.Fatal(err)
+		}
+		if boots, _ := agent.usm.clock(); boots != expected {
+			t.Fatalf("Wrong engine boots %d, expected %d\n", boots, expected)
+		}
+		if state := store[agent.EngineID().String()]; state[0] != expected {
+			t.Fatalf("Wrong saved state %v\n", state)
+		}
+	}
+
+	agent.usm.start = agent.usm.start.Add(-time.Minute)
+	if err := agent.SaveEngineState(); err != nil {
+		t.Fatal(err)
+	}
+	if state := store[agent.EngineID().String()]; state != [2]int{3, 60} {
+		t.Fatalf("Wrong saved state %v\n", state)
+	}
+}
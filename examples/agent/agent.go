@@ -60,7 +60,7 @@ func main() {
 			log.Fatal(err)
 		}
 
-		buffer, err = agent.ProcessDatagram(buffer[:n])
+		buffer, err = agent.ProcessDatagramFrom(buffer[:n], source)
 		if err != nil {
 			log.Println(err)
 			continue
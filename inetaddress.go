@@ -0,0 +1,56 @@
+package snmp
+
+import (
+	"net"
+)
+
+// InetAddressType values defined by RFC 4001.
+const (
+	InetAddressUnknown = 0
+	InetAddressIPv4    = 1
+	InetAddressIPv6    = 2
+)
+
+// InetAddress is a value of the InetAddress textual convention (RFC 4001),
+// holding either an IPv4 or an IPv6 address. Unlike IPAddress, which is the
+// IPv4 only [APPLICATION 0] type, it's encoded as an OCTET STRING whose format
+// is given by an InetAddressType object.
+//
+// InetAddress values are decoded as strings, since they can't be
+// distinguished from an OCTET STRING. Setters can convert them back with
+// InetAddress(value).
+type InetAddress []byte
+
+// NewInetAddress converts an IP address. IPv4 addresses are always converted
+// to the 4 bytes form.
+func NewInetAddress(ip net.IP) InetAddress {
+	if ip4 := ip.To4(); ip4 != nil {
+		return InetAddress(ip4)
+	}
+	return InetAddress(ip.To16())
+}
+
+// Type returns the InetAddressType of the address.
+func (a InetAddress) Type() int {
+	switch len(a) {
+	case net.IPv4len:
+		return InetAddressIPv4
+	case net.IPv6len:
+		return InetAddressIPv6
+	}
+	return InetAddressUnknown
+}
+
+// IP converts the address to a net.IP. It returns nil if the address is
+// neither IPv4 nor IPv6.
+func (a InetAddress) IP() net.IP {
+	if a.Type() == InetAddressUnknown {
+		return nil
+	}
+	return append(net.IP{}, a...)
+}
+
+// String returns the textual representation of the address.
+func (a InetAddress) String() string {
+	return a.IP().String()
+}
@@ -0,0 +1,49 @@
+package snmp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+func TestInetAddress(t *testing.T) {
+	ctx := Asn1Context()
+	oid := asn1.Oid{1, 3, 6, 1, 4, 1, 1, 0}
+	tests := []struct {
+		ip  string
+		typ int
+		len int
+	}{
+		{"192.168.0.1", InetAddressIPv4, 4},
+		{"2001:db8::1", InetAddressIPv6, 16},
+	}
+	for _, test := range tests {
+		ip := net.ParseIP(test.ip)
+		addr := NewInetAddress(ip)
+		if addr.Type() != test.typ || len(addr) != test.len {
+			t.Fatalf("Wrong address %#v for %s\n", addr, test.ip)
+		}
+
+		data, err := ctx.Encode(Variable{oid, addr})
+		if err != nil {
+			t.Fatal(err)
+		}
+		v := Variable{}
+		_, err = ctx.Decode(data, &v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, ok := v.Value.(string)
+		if !ok {
+			t.Fatalf("Invalid value type: %T\n", v.Value)
+		}
+		decoded := InetAddress(s)
+		if !decoded.IP().Equal(ip) || decoded.String() != test.ip {
+			t.Fatalf("Wrong decoded address %s for %s\n", decoded, test.ip)
+		}
+	}
+	if InetAddress("abc").IP() != nil {
+		t.Fatal("Address with invalid length should not be converted.")
+	}
+}
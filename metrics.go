@@ -0,0 +1,104 @@
+package snmp
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// agentStats counts the messages processed by an agent.
+type agentStats struct {
+	sync.Mutex
+	// requests is indexed by PDU type name.
+	requests map[string]uint64
+	// responses is indexed by PDU type name and error status.
+	responses map[[2]string]uint64
+	// errors is indexed by error kind.
+	errors map[string]uint64
+}
+
+// pduName returns the name of a PDU type without the Pdu suffix, like
+// "GetRequest".
+func pduName(pdu interface{}) string {
+	if pdu == nil {
+		return "none"
+	}
+	return strings.TrimSuffix(reflect.TypeOf(pdu).Name(), "Pdu")
+}
+
+// add records the outcome of a request.
+func (s *agentStats) add(request, response *Message, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.requests == nil {
+		s.requests = make(map[string]uint64)
+		s.responses = make(map[[2]string]uint64)
+		s.errors = make(map[string]uint64)
+	}
+	pdu := pduName(request.Pdu)
+	s.requests[pdu]++
+	if err != nil {
+		kind := "unknown"
+		if e, ok := err.(ProcessError); ok {
+			kind = strings.ToLower(e.Kind.String())
+		}
+		s.errors[kind]++
+		return
+	}
+	if res, ok := response.Pdu.(GetResponsePdu); ok {
+		s.responses[[2]string{pdu, statusName(res.ErrorStatus)}]++
+	}
+}
+
+// WriteMetrics writes the counters of processed messages in the Prometheus
+// text exposition format.
+func (a *Agent) WriteMetrics(w io.Writer) error {
+	s := &a.stats
+	s.Lock()
+	defer s.Unlock()
+
+	var lines []string
+	for pdu, n := range s.requests {
+		lines = append(lines, fmt.Sprintf("snmp_agent_requests_total{pdu=%q} %d", pdu, n))
+	}
+	if err := writeMetric(w, "snmp_agent_requests_total",
+		"Requests received by the agent.", lines); err != nil {
+		return err
+	}
+
+	lines = nil
+	for key, n := range s.responses {
+		lines = append(lines, fmt.Sprintf(
+			"snmp_agent_responses_total{pdu=%q,status=%q} %d", key[0], key[1], n))
+	}
+	if err := writeMetric(w, "snmp_agent_responses_total",
+		"Responses sent by the agent.", lines); err != nil {
+		return err
+	}
+
+	lines = nil
+	for kind, n := range s.errors {
+		lines = append(lines, fmt.Sprintf("snmp_agent_errors_total{kind=%q} %d", kind, n))
+	}
+	return writeMetric(w, "snmp_agent_errors_total",
+		"Requests that produced no response.", lines)
+}
+
+// writeMetric writes the header and the sorted samples of a counter.
+func writeMetric(w io.Writer, name, help string, samples []string) error {
+	sort.Strings(samples)
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	if err != nil {
+		return err
+	}
+	for _, sample := range samples {
+		if _, err := fmt.Fprintln(w, sample); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package snmp
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+func TestWriteMetrics(t *testing.T) {
+
+	agent := NewAgent()
+	agent.AddRoManagedObject(asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0},
+		func(oid asn1.Oid) (interface{}, error) {
+			return "name", nil
+		})
+	for _, oid := range []asn1.Oid{
+		{1, 3, 6, 1, 2, 1, 1, 5, 0},
+		{1, 3, 6, 1, 2, 1, 1, 5, 0},
+		{1, 3, 6, 1, 2, 1, 1, 6, 0},
+	} {
+		agent.ProcessMessage(&Message{
+			Community: "public",
+			Pdu:       GetRequestPdu{Variables: []Variable{{oid, asn1.Null{}}}},
+		})
+	}
+	agent.ProcessMessage(&Message{Community: "invalid", Pdu: GetRequestPdu{}})
+
+	var buf bytes.Buffer
+	if err := agent.WriteMetrics(&buf); err != nil {
+		t.Fatal(err)
+	}
+	output := buf.String()
+
+	comment := regexp.MustCompile(`^# (HELP|TYPE) [a-z_]+ .+$`)
+	sample := regexp.MustCompile(`^[a-z_]+\{([a-z]+="[^"]*",?)+\} [0-9]+$`)
+	for _, line := range strings.Split(strings.TrimSuffix(output, "\n"), "\n") {
+		if !comment.MatchString(line) && !sample.MatchString(line) {
+			t.Fatalf("Invalid line %q\n", line)
+		}
+	}
+	for _, expected := range []string{
+		`snmp_agent_requests_total{pdu="GetRequest"} 4`,
+		`snmp_agent_responses_total{pdu="GetRequest",status="noError"} 2`,
+		`snmp_agent_responses_total{pdu="GetRequest",status="noSuchName"} 1`,
+		`snmp_agent_errors_total{kind="drop"} 1`,
+	} {
+		if !strings.Contains(output, expected+"\n") {
+			t.Fatalf("Missing %q in:\n%s\n", expected, output)
+		}
+	}
+}
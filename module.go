@@ -0,0 +1,134 @@
+package snmp
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+// Access is the maximum access allowed to a managed object, as defined by the
+// MAX-ACCESS clause of a MIB.
+type Access int
+
+// Access levels.
+const (
+	AccessReadOnly Access = iota
+	AccessReadWrite
+	// AccessReadCreate is only valid for table columns, see
+	// AddRcTableColumn.
+	AccessReadCreate
+)
+
+// ObjectDefinition describes a scalar managed object whose value is kept by
+// the agent. It's used by LoadModule.
+type ObjectDefinition struct {
+	// Oid is the OID of the object instance in dotted notation.
+	Oid string
+	// Type is a value of the object type, like snmp.Counter32(0). It must
+	// be one of the types listed in NewVariable.
+	Type interface{}
+	// Access defines whether the object can be written by managers.
+	Access Access
+	// Value is the initial value of the object. The zero value of Type is
+	// used when it's nil.
+	Value interface{}
+}
+
+// LoadModule registers a set of scalar managed objects, whose OIDs are
+// relative to the root OID of the agent. The values of the objects are kept
+// by the agent and writes are checked against the declared types. The whole
+// module is validated before any object is registered.
+func (a *Agent) LoadModule(definitions []ObjectDefinition) error {
+	objects := make([]managedObject, len(definitions))
+	for i, def := range definitions {
+		oid, err := parseSubIdentifiers(def.Oid)
+		if err != nil {
+			return err
+		}
+		oid = a.absoluteOid(oid)
+		if err := checkOid(oid); err != nil {
+			return err
+		}
+		for _, h := range objects[:i] {
+			if h.oid.Cmp(oid) == 0 {
+				return fmt.Errorf("OID %s is defined twice", oid)
+			}
+		}
+		if a.overlaps(managedObject{oid: oid}) {
+			return fmt.Errorf("OID %d is already registered", oid)
+		}
+		h, err := newScalar(oid, def)
+		if err != nil {
+			return err
+		}
+		objects[i] = h
+	}
+	for _, h := range objects {
+		a.handlers = append(a.handlers, h)
+	}
+	sort.Sort(sortableManagedObjects(a.handlers))
+	return nil
+}
+
+// scalar holds the value of an object loaded by LoadModule.
+type scalar struct {
+	sync.Mutex
+	value interface{}
+}
+
+// newScalar validates an object definition and creates its managed object.
+func newScalar(oid asn1.Oid, def ObjectDefinition) (managedObject, error) {
+	h := managedObject{oid: oid, set: notWritable, readOnly: true}
+	_, null := def.Type.(asn1.Null)
+	if !supportedValue(def.Type) || null {
+		return h, fmt.Errorf("unsupported type %T for OID %s", def.Type, oid)
+	}
+	h.typ = reflect.TypeOf(def.Type)
+	value := def.Value
+	if value == nil {
+		value = reflect.Zero(h.typ).Interface()
+	} else if reflect.TypeOf(value) != h.typ {
+		return h, fmt.Errorf("initial value of OID %s should be of type %s",
+			oid, h.typ)
+	}
+	switch def.Access {
+	case AccessReadOnly:
+	case AccessReadWrite:
+		if h.isCounter() {
+			return h, fmt.Errorf("counter %s can't be writable", oid)
+		}
+	default:
+		return h, fmt.Errorf("invalid access %d for OID %s", def.Access, oid)
+	}
+
+	s := &scalar{value: value}
+	h.get = func(oid asn1.Oid) (interface{}, error) {
+		s.Lock()
+		defer s.Unlock()
+		return s.value, nil
+	}
+	if def.Access == AccessReadWrite {
+		h.readOnly = false
+		h.set = func(oid asn1.Oid, value interface{}) error {
+			if !h.accepts(value) {
+				return VarErrorf(WrongType, "invalid type %T for %s", value, oid)
+			}
+			// Values with the same tag, like strings for Bits, are stored
+			// with the declared type
+			if v := reflect.ValueOf(value); v.Type() != h.typ {
+				if !v.Type().ConvertibleTo(h.typ) {
+					return VarErrorf(WrongType, "invalid type %T for %s", value, oid)
+				}
+				value = v.Convert(h.typ).Interface()
+			}
+			s.Lock()
+			defer s.Unlock()
+			s.value = value
+			return nil
+		}
+	}
+	return h, nil
+}
@@ -0,0 +1,153 @@
+package snmp
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+func TestLoadModule(t *testing.T) {
+	agent := NewAgent()
+	agent.SetSupportedVersions(Version1, Version2c)
+	err := agent.LoadModule([]ObjectDefinition{
+		{"1.3.6.1.4.1.1.1.0", "", AccessReadWrite, "initial"},
+		{"1.3.6.1.4.1.1.2.0", Counter32(0), AccessReadOnly, Counter32(7)},
+		{"1.3.6.1.4.1.1.3.0", 0, AccessReadWrite, nil},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Walk the module
+	expected := []Variable{
+		{asn1.Oid{1, 3, 6, 1, 4, 1, 1, 1, 0}, "initial"},
+		{asn1.Oid{1, 3, 6, 1, 4, 1, 1, 2, 0}, Counter32(7)},
+		{asn1.Oid{1, 3, 6, 1, 4, 1, 1, 3, 0}, 0},
+	}
+	oid := asn1.Oid{1, 3, 6, 1, 4, 1, 1}
+	for _, e := range expected {
+		request := &Message{
+			Community: "public",
+			Pdu: GetNextRequestPdu{
+				Variables: []Variable{{oid, asn1.Null{}}},
+			},
+		}
+		response, err := agent.ProcessMessage(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v := response.Pdu.(GetResponsePdu).Variables[0]
+		if v.Name.Cmp(e.Name) != 0 || v.Value != e.Value {
+			t.Fatalf("Wrong variable %v, expected %v\n", v, e)
+		}
+		oid = v.Name
+	}
+
+	// Write the objects
+	tests := []struct {
+		v      Variable
+		status int
+	}{
+		{Variable{expected[0].Name, "new"}, NoError},
+		{Variable{expected[0].Name, 1}, WrongType},
+		{Variable{expected[1].Name, Counter32(1)}, NotWritable},
+		{Variable{expected[2].Name, 3}, NoError},
+	}
+	for _, test := range tests {
+		request := &Message{
+			Version:   Version2c,
+			Community: "private",
+			Pdu:       SetRequestPdu{Variables: []Variable{test.v}},
+		}
+		response, err := agent.ProcessMessage(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pdu := response.Pdu.(GetResponsePdu); pdu.ErrorStatus != test.status {
+			t.Fatalf("Wrong status %d for %v, expected %d\n",
+				pdu.ErrorStatus, test.v, test.status)
+		}
+	}
+}
+
+func TestLoadModuleConversions(t *testing.T) {
+	agent := NewAgent()
+	err := agent.LoadModule([]ObjectDefinition{
+		{"1.3.6.1.4.1.1.1.0", Bits{}, AccessReadWrite, nil},
+		{"1.3.6.1.4.1.1.2.0", DateAndTime{}, AccessReadWrite, nil},
+		{"1.3.6.1.4.1.1.3.0", InetAddress{}, AccessReadWrite, nil},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Octet strings are received as strings and kept with the declared type
+	tests := []struct {
+		v     Variable
+		value interface{}
+	}{
+		{Variable{asn1.Oid{1, 3, 6, 1, 4, 1, 1, 1, 0}, "\x81"}, Bits{0x81}},
+		{Variable{asn1.Oid{1, 3, 6, 1, 4, 1, 1, 2, 0}, "\x07\xe2\x01\x02\x03\x04\x05\x00"},
+			DateAndTime{0x07, 0xe2, 1, 2, 3, 4, 5, 0}},
+		{Variable{asn1.Oid{1, 3, 6, 1, 4, 1, 1, 3, 0}, "\x0a\x00\x00\x01"},
+			InetAddress{10, 0, 0, 1}},
+	}
+	for _, test := range tests {
+		request := &Message{
+			Community: "private",
+			Pdu:       SetRequestPdu{Variables: []Variable{test.v}},
+		}
+		response, err := agent.ProcessMessage(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pdu := response.Pdu.(GetResponsePdu); pdu.ErrorStatus != NoError {
+			t.Fatalf("Wrong status %d for %v, expected %d\n",
+				pdu.ErrorStatus, test.v, NoError)
+		}
+		response, err = agent.ProcessMessage(&Message{
+			Community: "public",
+			Pdu: GetRequestPdu{
+				Variables: []Variable{{test.v.Name, asn1.Null{}}},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		v := response.Pdu.(GetResponsePdu).Variables[0]
+		if !reflect.DeepEqual(v.Value, test.value) {
+			t.Fatalf("Wrong value %#v, expected %#v\n", v.Value, test.value)
+		}
+	}
+}
+
+func TestLoadInvalidModule(t *testing.T) {
+	modules := [][]ObjectDefinition{
+		{
+			{"1.3.6.1.4.1.1.1.0", 0, AccessReadOnly, nil},
+			{"1.3.6.1.4.1.1.1.0", 0, AccessReadOnly, nil},
+		},
+		{
+			{"1.3.6.1.4.1.1.1.0", Counter64(0), AccessReadWrite, nil},
+		},
+		{
+			{"1.3.6.1.4.1.1.1.0", 0, AccessReadOnly, "wrong"},
+		},
+		{
+			{"1.3.6.1.4.1.1.1.0", uint(0), AccessReadOnly, nil},
+		},
+		{
+			{"1.3.6.1.4.1.1.1.x", 0, AccessReadOnly, nil},
+		},
+	}
+	for _, module := range modules {
+		agent := NewAgent()
+		if agent.LoadModule(module) == nil {
+			t.Fatalf("Loading module %v should fail.\n", module)
+		}
+		if len(agent.handlers) > 0 {
+			t.Fatalf("Module %v was partially loaded.\n", module)
+		}
+	}
+}
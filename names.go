@@ -0,0 +1,46 @@
+package snmp
+
+import (
+	"strings"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+// RegisterNames registers symbolic names for OIDs, used by ResolveOid. The
+// keys of names are OIDs in dotted notation.
+func (a *Agent) RegisterNames(names map[string]string) error {
+	parsed := make(map[string]string, len(names))
+	for s, name := range names {
+		oid, err := ParseOid(s)
+		if err != nil {
+			return err
+		}
+		parsed[oid.String()] = name
+	}
+	if a.names == nil {
+		a.names = make(map[string]string)
+	}
+	for oid, name := range parsed {
+		a.names[oid] = name
+	}
+	return nil
+}
+
+// ResolveOid returns a symbolic representation of an OID, like
+// "sysUpTime.0", using the names registered with RegisterNames. The longest
+// registered prefix of the OID is used. The OID in dotted notation is
+// returned when no prefix is registered.
+func (a *Agent) ResolveOid(oid asn1.Oid) string {
+	for n := len(oid); n > 0 && len(a.names) > 0; n-- {
+		name, ok := a.names[oid[:n].String()]
+		if !ok {
+			continue
+		}
+		if n == len(oid) {
+			return name
+		}
+		suffix := oid[n:].String()
+		return name + "." + strings.TrimPrefix(suffix, ".")
+	}
+	return oid.String()
+}
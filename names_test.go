@@ -0,0 +1,59 @@
+package snmp
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+func TestResolveOid(t *testing.T) {
+
+	uptimeOid := asn1.Oid{1, 3, 6, 1, 2, 1, 1, 3, 0}
+	data := getResquestForTest()
+
+	var buffer bytes.Buffer
+	agent := NewAgent()
+	agent.SetLogger(log.New(&buffer, "", 0))
+	agent.SetCommunities("publ", "priv")
+	agent.AddRoManagedObject(uptimeOid,
+		func(oid asn1.Oid) (interface{}, error) {
+			return 0, nil
+		})
+	err := agent.RegisterNames(map[string]string{
+		"1.3.6.1.2.1.1":   "system",
+		"1.3.6.1.2.1.1.3": "sysUpTime",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]asn1.Oid{
+		"sysUpTime.0": uptimeOid,
+		"sysUpTime":   {1, 3, 6, 1, 2, 1, 1, 3},
+		"system.5.0":  {1, 3, 6, 1, 2, 1, 1, 5, 0},
+	}
+	for name, oid := range tests {
+		if resolved := agent.ResolveOid(oid); resolved != name {
+			t.Fatalf("Wrong name %q for %s, expected %q\n", resolved, oid, name)
+		}
+	}
+	unknown := asn1.Oid{1, 3, 6, 1, 4, 1}
+	if resolved := agent.ResolveOid(unknown); resolved != unknown.String() {
+		t.Fatalf("Wrong name %q for unknown OID %s\n", resolved, unknown)
+	}
+
+	_, err = agent.ProcessDatagram(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buffer.String(), "oid: sysUpTime.0") {
+		t.Fatalf("OID should be logged by name:\n%s", buffer.String())
+	}
+
+	if agent.RegisterNames(map[string]string{"1.x": "invalid"}) == nil {
+		t.Fatal("Registering an invalid OID should fail.")
+	}
+}
@@ -0,0 +1,93 @@
+package snmp
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+// snmpTrapOIDOid is the OID of the snmpTrapOID.0 variable of SNMPv2
+// notifications.
+var snmpTrapOIDOid = asn1.Oid{1, 3, 6, 1, 6, 3, 1, 1, 4, 1, 0}
+
+// NotificationType describes a NOTIFICATION-TYPE, so the variables sent in a
+// notification always match its MIB definition.
+type NotificationType struct {
+	// Oid identifies the notification, sent as the value of snmpTrapOID.0.
+	Oid asn1.Oid
+	// Objects lists the OBJECTS clause of the notification, in order.
+	Objects []NotificationObject
+}
+
+// NotificationObject is an object carried by a notification.
+type NotificationObject struct {
+	// Oid is the OID of the object. Variables carry one of its instances.
+	Oid asn1.Oid
+	// Type is a value of the type of the object, for example
+	// snmp.Counter32(0), or nil to accept any type.
+	Type interface{}
+}
+
+// Build returns the variables of a SNMPv2 notification: snmpTrapOID.0
+// followed by an instance of each object, in the order given by Objects. The
+// sysUpTime.0 variable that precedes them is left to the sender. The keys of
+// values are the instance OIDs in dotted notation. Every object must have
+// exactly one value of its type and values must belong to one of the objects.
+func (n NotificationType) Build(values map[string]interface{}) ([]Variable, error) {
+	// Map iteration is random, sort the keys to report errors consistently
+	keys := make([]string, 0, len(values))
+	for s := range values {
+		keys = append(keys, s)
+	}
+	sort.Strings(keys)
+	instances := make([]Variable, len(keys))
+	for i, s := range keys {
+		oid, err := ParseOid(s)
+		if err != nil {
+			return nil, err
+		}
+		instances[i] = Variable{oid, values[s]}
+	}
+
+	variables := []Variable{{snmpTrapOIDOid, n.Oid}}
+	used := make([]bool, len(instances))
+	for _, object := range n.Objects {
+		found := -1
+		for i, instance := range instances {
+			if !hasPrefix(instance.Name, object.Oid) {
+				continue
+			}
+			if found >= 0 {
+				return nil, fmt.Errorf("object %s of notification %s is given twice",
+					object.Oid, n.Oid)
+			}
+			found = i
+		}
+		if found < 0 {
+			return nil, fmt.Errorf("object %s of notification %s is missing",
+				object.Oid, n.Oid)
+		}
+		v, err := NewVariable(instances[found].Name, instances[found].Value)
+		if err != nil {
+			return nil, err
+		}
+		if object.Type != nil {
+			class, tag, _ := ValueTag(v.Value)
+			declaredClass, declaredTag, _ := ValueTag(object.Type)
+			if class != declaredClass || tag != declaredTag {
+				return nil, fmt.Errorf("invalid type %T for object %s, expected %T",
+					v.Value, object.Oid, object.Type)
+			}
+		}
+		used[found] = true
+		variables = append(variables, v)
+	}
+	for i, instance := range instances {
+		if !used[i] {
+			return nil, fmt.Errorf("%s is not an object of notification %s",
+				instance.Name, n.Oid)
+		}
+	}
+	return variables, nil
+}
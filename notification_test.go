@@ -0,0 +1,79 @@
+package snmp
+
+import (
+	"testing"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+func TestNotificationType(t *testing.T) {
+	// linkDown from IF-MIB
+	linkDown := NotificationType{
+		Oid: asn1.Oid{1, 3, 6, 1, 6, 3, 1, 1, 5, 3},
+		Objects: []NotificationObject{
+			{asn1.Oid{1, 3, 6, 1, 2, 1, 2, 2, 1, 1}, 0},
+			{asn1.Oid{1, 3, 6, 1, 2, 1, 2, 2, 1, 7}, 0},
+			{asn1.Oid{1, 3, 6, 1, 2, 1, 2, 2, 1, 8}, 0},
+		},
+	}
+
+	variables, err := linkDown.Build(map[string]interface{}{
+		"1.3.6.1.2.1.2.2.1.8.3": 2,
+		"1.3.6.1.2.1.2.2.1.1.3": 3,
+		"1.3.6.1.2.1.2.2.1.7.3": 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"1.3.6.1.6.3.1.1.4.1.0",
+		"1.3.6.1.2.1.2.2.1.1.3",
+		"1.3.6.1.2.1.2.2.1.7.3",
+		"1.3.6.1.2.1.2.2.1.8.3",
+	}
+	if len(variables) != len(expected) {
+		t.Fatalf("Wrong variables %v\n", variables)
+	}
+	for i, s := range expected {
+		if variables[i].Name.String() != s {
+			t.Fatalf("Wrong variable %d: %s instead of %s\n", i,
+				variables[i].Name, s)
+		}
+	}
+	if oid, ok := variables[0].Value.(asn1.Oid); !ok || oid.Cmp(linkDown.Oid) != 0 {
+		t.Fatalf("Wrong snmpTrapOID value %v\n", variables[0].Value)
+	}
+
+	invalid := []map[string]interface{}{
+		// Missing ifOperStatus
+		{
+			"1.3.6.1.2.1.2.2.1.1.3": 3,
+			"1.3.6.1.2.1.2.2.1.7.3": 1,
+		},
+		// Wrong type
+		{
+			"1.3.6.1.2.1.2.2.1.1.3": 3,
+			"1.3.6.1.2.1.2.2.1.7.3": 1,
+			"1.3.6.1.2.1.2.2.1.8.3": "down",
+		},
+		// Unknown object
+		{
+			"1.3.6.1.2.1.2.2.1.1.3": 3,
+			"1.3.6.1.2.1.2.2.1.7.3": 1,
+			"1.3.6.1.2.1.2.2.1.8.3": 2,
+			"1.3.6.1.2.1.2.2.1.2.3": "eth0",
+		},
+		// Object given twice
+		{
+			"1.3.6.1.2.1.2.2.1.1.3": 3,
+			"1.3.6.1.2.1.2.2.1.1.4": 4,
+			"1.3.6.1.2.1.2.2.1.7.3": 1,
+			"1.3.6.1.2.1.2.2.1.8.3": 2,
+		},
+	}
+	for _, values := range invalid {
+		if _, err := linkDown.Build(values); err == nil {
+			t.Fatalf("Building with %v should fail\n", values)
+		}
+	}
+}
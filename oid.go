@@ -0,0 +1,72 @@
+package snmp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+// ParseOid parses an OID in dotted notation, like "1.3.6.1.2.1.1.5.0". A
+// leading dot is accepted.
+func ParseOid(s string) (asn1.Oid, error) {
+	oid, err := parseSubIdentifiers(s)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkOid(oid); err != nil {
+		return nil, err
+	}
+	return oid, nil
+}
+
+// parseSubIdentifiers parses an OID in dotted notation without checking if it
+// can be encoded, as needed for relative OIDs.
+func parseSubIdentifiers(s string) (asn1.Oid, error) {
+	parts := strings.Split(strings.TrimPrefix(s, "."), ".")
+	oid := make(asn1.Oid, len(parts))
+	for i, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OID \"%s\"", s)
+		}
+		oid[i] = uint(n)
+	}
+	return oid, nil
+}
+
+// Enterprise returns the OID of an object under the subtree of an enterprise,
+// 1.3.6.1.4.1.enterpriseNumber, followed by rest.
+func Enterprise(enterpriseNumber int, rest ...int) asn1.Oid {
+	return appendIndex(asn1.Oid{1, 3, 6, 1, 4, 1},
+		append([]int{enterpriseNumber}, rest...))
+}
+
+// AppendIndex returns a new OID made of base followed by the sub-identifiers
+// of index, like the instance of a table column.
+func AppendIndex(base asn1.Oid, index ...int) asn1.Oid {
+	return appendIndex(base, index)
+}
+
+// AppendStringIndex returns a new OID made of base followed by an OCTET
+// STRING index, encoded as its length and then one sub-identifier per byte
+// (RFC 2578, section 7.7).
+func AppendStringIndex(base asn1.Oid, index string) asn1.Oid {
+	return append(AppendIndex(base, len(index)), stringSubIdentifiers(index)...)
+}
+
+// AppendImpliedStringIndex is like AppendStringIndex but omits the length, as
+// done for the last index of a table declared IMPLIED.
+func AppendImpliedStringIndex(base asn1.Oid, index string) asn1.Oid {
+	return append(AppendIndex(base), stringSubIdentifiers(index)...)
+}
+
+// stringSubIdentifiers returns one sub-identifier per byte of s.
+func stringSubIdentifiers(s string) asn1.Oid {
+	oid := make(asn1.Oid, len(s))
+	for i := 0; i < len(s); i++ {
+		oid[i] = uint(s[i])
+	}
+	return oid
+}
@@ -0,0 +1,61 @@
+package snmp
+
+import (
+	"testing"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+func TestParseOid(t *testing.T) {
+	expected := asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0}
+	for _, s := range []string{"1.3.6.1.2.1.1.5.0", ".1.3.6.1.2.1.1.5.0"} {
+		oid, err := ParseOid(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if oid.Cmp(expected) != 0 {
+			t.Fatalf("Wrong OID %s for %q\n", oid, s)
+		}
+	}
+	for _, s := range []string{"", "1", "1.3.x", "1..3", "4.1", "1.-3"} {
+		if _, err := ParseOid(s); err == nil {
+			t.Fatalf("Parsing %q should fail.\n", s)
+		}
+	}
+}
+
+func TestEnterprise(t *testing.T) {
+	oid := Enterprise(12345, 1, 0)
+	if oid.String() != "1.3.6.1.4.1.12345.1.0" {
+		t.Fatalf("Wrong OID %s\n", oid)
+	}
+	if oid := Enterprise(9); oid.String() != "1.3.6.1.4.1.9" {
+		t.Fatalf("Wrong OID %s\n", oid)
+	}
+}
+
+func TestAppendIndex(t *testing.T) {
+	base := asn1.Oid{1, 3, 6, 1, 2, 1, 2, 2, 1, 2}
+	if oid := AppendIndex(base, 7); oid.String() != "1.3.6.1.2.1.2.2.1.2.7" {
+		t.Fatalf("Wrong OID %s\n", oid)
+	}
+	if oid := AppendIndex(base, 1, 2); oid.String() != "1.3.6.1.2.1.2.2.1.2.1.2" {
+		t.Fatalf("Wrong OID %s\n", oid)
+	}
+	if len(base) != 10 {
+		t.Fatalf("Base OID modified: %s\n", base)
+	}
+}
+
+func TestAppendStringIndex(t *testing.T) {
+	base := asn1.Oid{1, 3, 6, 1, 4, 1, 9999, 1}
+	if oid := AppendStringIndex(base, "ab"); oid.String() != "1.3.6.1.4.1.9999.1.2.97.98" {
+		t.Fatalf("Wrong OID %s\n", oid)
+	}
+	if oid := AppendImpliedStringIndex(base, "ab"); oid.String() != "1.3.6.1.4.1.9999.1.97.98" {
+		t.Fatalf("Wrong OID %s\n", oid)
+	}
+	if oid := AppendStringIndex(base, ""); oid.String() != "1.3.6.1.4.1.9999.1.0" {
+		t.Fatalf("Wrong OID %s\n", oid)
+	}
+}
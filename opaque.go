@@ -0,0 +1,83 @@
+package snmp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Prefix of the Opaque encoding of floats used by net-snmp: a BER element with
+// the context-specific tag 120 (0x9f 0x78) and length 4.
+var opaqueFloatPrefix = []byte{0x9f, 0x78, 0x04}
+
+// OpaqueFloat encodes a float as an Opaque value following the convention
+// used by net-snmp. The float is stored in the IEEE 754 format.
+func OpaqueFloat(f float32) Opaque {
+	value := make(Opaque, len(opaqueFloatPrefix)+4)
+	copy(value, opaqueFloatPrefix)
+	binary.BigEndian.PutUint32(value[len(opaqueFloatPrefix):],
+		math.Float32bits(f))
+	return value
+}
+
+// Float decodes a float encoded with OpaqueFloat.
+func (o Opaque) Float() (float32, error) {
+	if len(o) != len(opaqueFloatPrefix)+4 ||
+		string(o[:len(opaqueFloatPrefix)]) != string(opaqueFloatPrefix) {
+		return 0, fmt.Errorf("opaque value is not a float")
+	}
+	return math.Float32frombits(
+		binary.BigEndian.Uint32(o[len(opaqueFloatPrefix):])), nil
+}
+
+// OpaqueBytes returns an Opaque value holding a copy of b.
+func OpaqueBytes(b []byte) Opaque {
+	return append(Opaque{}, b...)
+}
+
+// OpaqueString returns an Opaque value holding the bytes of s.
+func OpaqueString(s string) Opaque {
+	return Opaque(s)
+}
+
+// Bytes returns a copy of the content of an Opaque value.
+func (o Opaque) Bytes() []byte {
+	return append([]byte{}, o...)
+}
+
+// Text returns the content of an Opaque value as a string.
+func (o Opaque) Text() string {
+	return string(o)
+}
+
+// OpaqueValue encodes a value as an Opaque holding its BER encoding, the
+// convention defined by RFC 2578 for wrapping arbitrary ASN.1 values. The
+// value must be of one of the types listed in NewVariable.
+func OpaqueValue(value interface{}) (Opaque, error) {
+	if !supportedValue(value) {
+		return nil, fmt.Errorf("unsupported type %T", value)
+	}
+	data, err := Asn1Context().EncodeWithOptions(value, "choice:val")
+	if err != nil {
+		return nil, err
+	}
+	return Opaque(data), nil
+}
+
+// Value decodes the BER encoded value of an Opaque created by OpaqueValue.
+// Floats created by OpaqueFloat are returned as float32.
+func (o Opaque) Value() (interface{}, error) {
+	if f, err := o.Float(); err == nil {
+		return f, nil
+	}
+	var value interface{}
+	remaining, err := Asn1Context().DecodeWithOptions(o, &value, "choice:val")
+	if err != nil {
+		return nil, fmt.Errorf("opaque value is not BER encoded: %s", err)
+	}
+	if len(remaining) > 0 {
+		return nil, fmt.Errorf("opaque value has %d remaining bytes",
+			len(remaining))
+	}
+	return value, nil
+}
@@ -0,0 +1,92 @@
+package snmp
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+func TestOpaqueFloat(t *testing.T) {
+	ctx := Asn1Context()
+	oid := asn1.Oid{1, 3, 6, 1, 4, 1, 2021, 10, 1, 6, 1}
+	for _, f := range []float32{0, 1.5, -273.15, math.MaxFloat32} {
+		value := OpaqueFloat(f)
+		if !bytes.HasPrefix(value, []byte{0x9f, 0x78, 0x04}) {
+			t.Fatalf("Wrong encoding %#v\n", value)
+		}
+
+		data, err := ctx.Encode(Variable{oid, value})
+		if err != nil {
+			t.Fatal(err)
+		}
+		v := Variable{}
+		_, err = ctx.Decode(data, &v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opaque, ok := v.Value.(Opaque)
+		if !ok {
+			t.Fatalf("Invalid value type: %T\n", v.Value)
+		}
+		decoded, err := opaque.Float()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded != f {
+			t.Fatalf("Wrong decoded float %v, expected %v\n", decoded, f)
+		}
+	}
+	if _, err := Opaque("abc").Float(); err == nil {
+		t.Fatal("Decoding a float from an invalid opaque should fail.")
+	}
+}
+
+func TestOpaqueValue(t *testing.T) {
+	values := []interface{}{
+		42,
+		"text",
+		asn1.Oid{1, 3, 6, 1, 4, 1, 9999},
+		Counter32(7),
+		Counter64(1 << 40),
+		IPAddress{192, 168, 0, 1},
+	}
+	for _, value := range values {
+		opaque, err := OpaqueValue(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := opaque.Value()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, value) {
+			t.Fatalf("Wrong value %#v instead of %#v\n", decoded, value)
+		}
+	}
+
+	decoded, err := OpaqueFloat(2.5).Value()
+	if err != nil || decoded != float32(2.5) {
+		t.Fatalf("Wrong float value %v: %v\n", decoded, err)
+	}
+	if _, err := OpaqueValue(1.5); err == nil {
+		t.Fatalf("float64 should not be supported\n")
+	}
+	if _, err := OpaqueString("\x04\x10").Value(); err == nil {
+		t.Fatalf("Truncated value should fail\n")
+	}
+}
+
+func TestOpaqueBytes(t *testing.T) {
+	b := []byte{1, 2, 3}
+	opaque := OpaqueBytes(b)
+	b[0] = 9
+	if !bytes.Equal(opaque.Bytes(), []byte{1, 2, 3}) {
+		t.Fatalf("Wrong bytes %v\n", opaque.Bytes())
+	}
+	if text := OpaqueString("vendor").Text(); text != "vendor" {
+		t.Fatalf("Wrong text %q\n", text)
+	}
+}
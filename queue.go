@@ -0,0 +1,32 @@
+package snmp
+
+// InboundMessage is a request received by a custom transport.
+type InboundMessage struct {
+	// Data is the encoded SNMP message.
+	Data []byte
+	// Token identifies the request for the transport. It's returned as is
+	// in the OutboundMessage of the response.
+	Token interface{}
+}
+
+// OutboundMessage is a response to be sent by a custom transport.
+type OutboundMessage struct {
+	// Data is the encoded SNMP message.
+	Data []byte
+	// Token is the Token of the InboundMessage being answered.
+	Token interface{}
+}
+
+// ServeQueue handles the requests received from in and sends the responses to
+// out, allowing transports other than UDP. Requests that fail to be processed
+// are logged and produce no response. It returns when in is closed.
+func (a *Agent) ServeQueue(in <-chan InboundMessage, out chan<- OutboundMessage) {
+	for request := range in {
+		data, err := a.ProcessDatagram(request.Data)
+		if err != nil {
+			a.logf(LogError, "queued request failed: %s\n", err)
+			continue
+		}
+		out <- OutboundMessage{Data: data, Token: request.Token}
+	}
+}
@@ -0,0 +1,46 @@
+package snmp
+
+import (
+	"testing"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+func TestServeQueue(t *testing.T) {
+
+	agent := NewAgent()
+	agent.SetCommunities("publ", "priv")
+	agent.AddRoManagedObject(asn1.Oid{1, 3, 6, 1, 2, 1, 1, 3, 0},
+		func(oid asn1.Oid) (interface{}, error) {
+			return 123, nil
+		})
+
+	in := make(chan InboundMessage)
+	out := make(chan OutboundMessage, 2)
+	done := make(chan struct{})
+	go func() {
+		agent.ServeQueue(in, out)
+		close(done)
+	}()
+
+	in <- InboundMessage{Data: []byte{0x30, 0x00}, Token: "invalid"}
+	in <- InboundMessage{Data: getResquestForTest(), Token: "valid"}
+	close(in)
+	<-done
+
+	if len(out) != 1 {
+		t.Fatalf("Wrong number of responses: %d\n", len(out))
+	}
+	response := <-out
+	if response.Token != "valid" {
+		t.Fatalf("Wrong token %v\n", response.Token)
+	}
+	message, err := DecodeMessage(response.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdu, ok := message.Pdu.(GetResponsePdu)
+	if !ok || pdu.ErrorStatus != NoError || pdu.Variables[0].Value != 123 {
+		t.Fatalf("Wrong response %#v\n", message.Pdu)
+	}
+}
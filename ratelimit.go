@@ -0,0 +1,72 @@
+package snmp
+
+import (
+	"sync"
+	"time"
+)
+
+// SetRateLimit limits the number of requests per second accepted from a
+// community. Requests exceeding the limit are dropped. Up to perSecond
+// requests can be accepted in a burst. A limit of zero removes the limit.
+func (a *Agent) SetRateLimit(community string, perSecond int) {
+	a.limiter.set(community, perSecond)
+}
+
+// rateLimiter keeps a token bucket per community.
+type rateLimiter struct {
+	sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// set defines the rate of the community bucket.
+func (l *rateLimiter) set(community string, perSecond int) {
+	l.Lock()
+	defer l.Unlock()
+
+	if perSecond <= 0 {
+		delete(l.buckets, community)
+		return
+	}
+	if l.buckets == nil {
+		l.buckets = make(map[string]*tokenBucket)
+	}
+	l.buckets[community] = &tokenBucket{
+		rate:   float64(perSecond),
+		tokens: float64(perSecond),
+		last:   timeNow(),
+	}
+}
+
+// allow checks if a request can be accepted from the community.
+func (l *rateLimiter) allow(community string) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	b, ok := l.buckets[community]
+	if !ok {
+		return true
+	}
+	return b.take(timeNow())
+}
+
+// tokenBucket implements the token bucket algorithm. The bucket size is equal
+// to its rate.
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// take refills the bucket and removes a token if available.
+func (b *tokenBucket) take(now time.Time) bool {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
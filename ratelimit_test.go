@@ -0,0 +1,56 @@
+package snmp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+func TestRateLimit(t *testing.T) {
+
+	uptimeOid := asn1.Oid{1, 3, 6, 1, 2, 1, 1, 3, 0}
+	data := getResquestForTest()
+
+	clock := time.Now()
+	timeNow = func() time.Time { return clock }
+	defer func() { timeNow = time.Now }()
+
+	agent := NewAgent()
+	agent.SetCommunities("publ", "priv")
+	agent.AddRoManagedObject(uptimeOid,
+		func(oid asn1.Oid) (interface{}, error) {
+			return 0, nil
+		})
+	agent.SetRateLimit("publ", 20)
+
+	for i := 0; i < 20; i++ {
+		_, err := agent.ProcessDatagram(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := agent.ProcessDatagram(data)
+	if e, ok := err.(ProcessError); !ok || e.Kind != Drop {
+		t.Fatalf("Request exceeding the rate limit should be dropped: %v\n", err)
+	}
+
+	// A token is back after 1/20 second
+	clock = clock.Add(50 * time.Millisecond)
+	_, err = agent.ProcessDatagram(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = agent.ProcessDatagram(data)
+	if e, ok := err.(ProcessError); !ok || e.Kind != Drop {
+		t.Fatalf("Request exceeding the rate limit should be dropped: %v\n", err)
+	}
+
+	agent.SetRateLimit("publ", 0)
+	for i := 0; i < 50; i++ {
+		_, err := agent.ProcessDatagram(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package snmp
+
+import (
+	"github.com/PromonLogicalis/asn1"
+)
+
+// estimateMessageSize returns the number of bytes of the BER encoding of a
+// message with one of the PDUs based on Pdu.
+func estimateMessageSize(message *Message) int {
+	var pdu Pdu
+	switch p := message.Pdu.(type) {
+	case GetRequestPdu:
+		pdu = Pdu(p)
+	case GetNextRequestPdu:
+		pdu = Pdu(p)
+	case GetResponsePdu:
+		pdu = Pdu(p)
+	case SetRequestPdu:
+		pdu = Pdu(p)
+	case InformRequestPdu:
+		pdu = Pdu(p)
+	case V2TrapPdu:
+		pdu = Pdu(p)
+	case ReportPdu:
+		pdu = Pdu(p)
+	}
+	return tlvSize(intSize(int64(message.Version)) +
+		tlvSize(len(message.Community)) + estimateSize(pdu))
+}
+
+// estimateSize returns the number of bytes of the BER encoding of a PDU,
+// without actually encoding it.
+func estimateSize(pdu Pdu) int {
+	size := intSize(int64(pdu.Identifier)) +
+		intSize(int64(pdu.ErrorStatus)) +
+		intSize(int64(pdu.ErrorIndex)) +
+		variablesSize(pdu.Variables)
+	return tlvSize(size)
+}
+
+// variablesSize returns the encoded size of a variable binding list.
+func variablesSize(variables []Variable) int {
+	size := 0
+	for _, v := range variables {
+		size += variableSize(v)
+	}
+	return tlvSize(size)
+}
+
+// minVariableSize is the encoded size of the smallest variable binding, with
+// a single byte OID and a NULL value.
+const minVariableSize = 7
+
+// variableSize returns the encoded size of a single variable binding.
+func variableSize(v Variable) int {
+	return tlvSize(tlvSize(oidSize(v.Name)) + valueSize(v.Value))
+}
+
+// valueSize returns the encoded size of a variable value. Types not supported
+// by the agent are accounted as a NULL.
+func valueSize(value interface{}) int {
+	switch v := value.(type) {
+	case int:
+		return intSize(int64(v))
+	case string:
+		return tlvSize(len(v))
+	case asn1.Oid:
+		return tlvSize(oidSize(v))
+	case IPAddress:
+		return tlvSize(len(v))
+	case Counter32:
+		return uintSize(uint64(v))
+	case Unsigned32:
+		return uintSize(uint64(v))
+	case TimeTicks:
+		return uintSize(uint64(v))
+	case Opaque:
+		return tlvSize(len(v))
+	case Bits:
+		return tlvSize(len(v))
+	case DateAndTime:
+		return tlvSize(len(v))
+	case InetAddress:
+		return tlvSize(len(v))
+	case Counter64:
+		return uintSize(uint64(v))
+	}
+	return tlvSize(0)
+}
+
+// tlvSize returns the size of an element with a single byte tag and content
+// of the given size.
+func tlvSize(content int) int {
+	size := 1 + 1 + content
+	if content >= 0x80 {
+		for ; content > 0; content >>= 8 {
+			size++
+		}
+	}
+	return size
+}
+
+// intSize returns the encoded size of a signed INTEGER.
+func intSize(n int64) int {
+	content := 1
+	for ; n < -0x80 || n >= 0x80; n >>= 8 {
+		content++
+	}
+	return tlvSize(content)
+}
+
+// uintSize returns the encoded size of an unsigned value encoded as INTEGER.
+func uintSize(n uint64) int {
+	content := 1
+	for ; n >= 0x80; n >>= 8 {
+		content++
+	}
+	return tlvSize(content)
+}
+
+// oidSize returns size of the content of an encoded OBJECT IDENTIFIER.
+func oidSize(oid asn1.Oid) int {
+	if len(oid) < 2 {
+		return len(oid)
+	}
+	size := base128Size(uint64(oid[0])*40 + uint64(oid[1]))
+	for _, n := range oid[2:] {
+		size += base128Size(uint64(n))
+	}
+	return size
+}
+
+// base128Size returns the number of bytes used by a sub-identifier.
+func base128Size(n uint64) int {
+	size := 1
+	for ; n >= 0x80; n >>= 7 {
+		size++
+	}
+	return size
+}
@@ -0,0 +1,148 @@
+package snmp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+func TestEstimateSize(t *testing.T) {
+	oid := asn1.Oid{1, 3, 6, 1, 4, 1, 12345, 1, 2, 300000}
+	values := []interface{}{
+		asn1.Null{},
+		0,
+		127,
+		128,
+		-129,
+		1<<31 - 1,
+		"",
+		"short",
+		strings.Repeat("x", 300),
+		oid,
+		IPAddress{192, 168, 0, 1},
+		Counter32(0xffffffff),
+		Unsigned32(0x7f),
+		TimeTicks(0x80),
+		Opaque(strings.Repeat("y", 128)),
+		Counter64(0xffffffffffffffff),
+		NoSuchObject{},
+		NoSuchInstance{},
+		EndOfMibView{},
+	}
+	ctx := Asn1Context()
+	for _, value := range values {
+		pdu := Pdu{
+			Identifier:  0x12345678,
+			ErrorStatus: NoSuchName,
+			ErrorIndex:  1,
+			Variables:   []Variable{{oid, value}, {oid, value}},
+		}
+		data, err := ctx.Encode(GetResponsePdu(pdu))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size := estimateSize(pdu); size != len(data) {
+			t.Fatalf("Wrong estimated size for %T: %d instead of %d\n",
+				value, size, len(data))
+		}
+	}
+}
+
+func TestEstimateMessageSize(t *testing.T) {
+	message := &Message{
+		Version:   Version1,
+		Community: strings.Repeat("c", 200),
+		Pdu: GetResponsePdu{
+			Variables: []Variable{
+				{asn1.Oid{1, 3, 6, 1, 2, 1, 1, 1, 0}, strings.Repeat("d", 100)},
+			},
+		},
+	}
+	data, err := Asn1Context().Encode(*message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := estimateMessageSize(message); size != len(data) {
+		t.Fatalf("Wrong estimated size %d instead of %d\n", size, len(data))
+	}
+}
+
+func TestMinVariableSize(t *testing.T) {
+	if size := variableSize(Variable{asn1.Oid{0, 0}, asn1.Null{}}); size != minVariableSize {
+		t.Fatalf("Expected %d bytes for the smallest variable, got %d\n",
+			minVariableSize, size)
+	}
+}
+
+func TestTooBig(t *testing.T) {
+
+	descrOid := asn1.Oid{1, 3, 6, 1, 2, 1, 1, 1, 0}
+	agent := NewAgent()
+	agent.AddRoManagedObject(descrOid,
+		func(oid asn1.Oid) (interface{}, error) {
+			return strings.Repeat("x", 100), nil
+		})
+
+	request := &Message{
+		Community: "public",
+		Pdu: GetRequestPdu{
+			Variables: []Variable{{descrOid, asn1.Null{}}},
+		},
+	}
+	response, err := agent.ProcessMessage(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pdu := response.Pdu.(GetResponsePdu); pdu.ErrorStatus != NoError {
+		t.Fatalf("Response contains an error: %d\n", pdu.ErrorStatus)
+	}
+
+	agent.SetMaxResponseSize(100)
+	response, err = agent.ProcessMessage(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdu := response.Pdu.(GetResponsePdu)
+	if pdu.ErrorStatus != TooBig || pdu.ErrorIndex != 0 {
+		t.Fatalf(
+			"Response should contain error %d at index 0. Got %d at %d instead.\n",
+			TooBig, pdu.ErrorStatus, pdu.ErrorIndex)
+	}
+	if len(pdu.Variables) != 1 || pdu.Variables[0].Value != (asn1.Null{}) {
+		t.Fatalf("Response should contain the request variables: %v\n",
+			pdu.Variables)
+	}
+}
+
+func TestTooBigV2c(t *testing.T) {
+
+	descrOid := asn1.Oid{1, 3, 6, 1, 2, 1, 1, 1, 0}
+	agent := NewAgent()
+	agent.SetSupportedVersions(Version1, Version2c)
+	agent.SetMaxResponseSize(100)
+	agent.AddRoManagedObject(descrOid,
+		func(oid asn1.Oid) (interface{}, error) {
+			return strings.Repeat("x", 100), nil
+		})
+
+	response, err := agent.ProcessMessage(&Message{
+		Version:   Version2c,
+		Community: "public",
+		Pdu: GetRequestPdu{
+			Variables: []Variable{{descrOid, asn1.Null{}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdu := response.Pdu.(GetResponsePdu)
+	if pdu.ErrorStatus != TooBig || pdu.ErrorIndex != 0 {
+		t.Fatalf(
+			"Response should contain error %d at index 0. Got %d at %d instead.\n",
+			TooBig, pdu.ErrorStatus, pdu.ErrorIndex)
+	}
+	if len(pdu.Variables) != 0 {
+		t.Fatalf("Response should contain no variables: %v\n", pdu.Variables)
+	}
+}
@@ -86,17 +86,24 @@
 //
 package snmp
 
-// TODO Support for traps
 // TODO More flexible ACL and authentication mechanism.
 // TODO Use the origin to process ACLs and authentication.
-// TODO Support for SNMPv2.
 
 import (
+	"context"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math"
+	"math/rand"
+	"net"
 	"reflect"
+	"runtime/debug"
 	"sort"
+	"time"
 
 	"github.com/PromonLogicalis/asn1"
 )
@@ -104,21 +111,61 @@ import (
 // Getter is a function called to return a managed object value.
 type Getter func(oid asn1.Oid) (interface{}, error)
 
+// RequestGetter works like a Getter but also receives the OID of the
+// requested variable, which differs from oid for GetNext requests.
+type RequestGetter func(oid, requested asn1.Oid) (interface{}, error)
+
 // Setter is a function called to set a managed object value.
 type Setter func(oid asn1.Oid, value interface{}) error
 
 // Agent is a transport independent engine to process SNMP requests.
 type Agent struct {
-	log      *log.Logger
-	ctx      *asn1.Context
-	handlers []managedObject
-	public   string
-	private  string
+	log               *log.Logger
+	ctx               *asn1.Context
+	handlers          []managedObject
+	public            string
+	private           string
+	communities       []string
+	vacm              vacm
+	maxSteps          int
+	maxRepetitions    int
+	versions          []int
+	limiter           rateLimiter
+	stats             agentStats
+	accesses          accessTracker
+	strictV1          bool
+	trailingBytes     bool
+	unknown           UnknownPduPolicy
+	nilValue          NilValuePolicy
+	authorize         WriteAuthorizer
+	dedup             bool
+	names             map[string]string
+	commit            func(community string) error
+	undo              func(community string) error
+	maxSize           int
+	root              asn1.Oid
+	logLevel          LogLevel
+	preProcess        func(raw []byte, src net.Addr) error
+	responseCommunity func(community string) string
+	endOfMib          func(lastOid asn1.Oid) (Variable, bool)
+	noCommunity       bool
+	trustedAccess     Access
+	minDelay          time.Duration
+	maxDelay          time.Duration
+	writes            *writeQueue
+	usm               usmEngine
+	snmp              snmpCounters
+	contexts          map[string]*Agent
+	trapDestinations  []TrapDestination
+	trapID            uint32
 }
 
 // NewAgent create and initialize an agent.
 func NewAgent() *Agent {
-	a := &Agent{ctx: Asn1Context()}
+	a := &Agent{ctx: Asn1Context(), versions: []int{Version1},
+		logLevel: LogDebug, trustedAccess: AccessReadWrite,
+		maxRepetitions: defaultMaxRepetitions}
+	a.usm.init()
 	a.SetLogger(nil)
 	a.SetCommunities("public", "private")
 	return a
@@ -133,49 +180,409 @@ func (a *Agent) SetLogger(logger *log.Logger) {
 	a.ctx.SetLogger(logger)
 }
 
-// SetCommunities defines the public and private communities.
+// LogLevel defines which internal messages are logged.
+type LogLevel int
+
+// Log levels, from the least to the most verbose. Each level includes the
+// messages of the previous ones.
+const (
+	// LogOff disables logging.
+	LogOff LogLevel = iota
+	// LogError logs failures only.
+	LogError
+	// LogInfo adds a one-line summary of each request.
+	LogInfo
+	// LogDebug adds full dumps of requests and responses. It's the default.
+	LogDebug
+)
+
+// SetLogLevel defines the verbosity of the agent logs.
+func (a *Agent) SetLogLevel(level LogLevel) {
+	a.logLevel = level
+}
+
+// logf logs a message if the log level of the agent includes it.
+func (a *Agent) logf(level LogLevel, format string, values ...interface{}) {
+	if level <= a.logLevel {
+		a.log.Printf(format, values...)
+	}
+}
+
+// SetCommunities defines the public and private communities, replacing any
+// community added before. They are members of the "public" and "private"
+// VACM groups, which can read and, for the private one, write the "all" view
+// of the whole MIB. The view and the access of the groups are only created
+// when not defined yet, so those configured before under these names are
+// kept.
 func (a *Agent) SetCommunities(public, private string) {
 	a.public, a.private = public, private
+	a.communities = nil
+	a.AddCommunity(public, "public")
+	a.AddCommunity(private, "private")
+	if !a.vacm.hasView("all") {
+		a.AddViewSubtree("all", asn1.Oid{}, nil, true)
+	}
+	if !a.vacm.hasGroupAccess("public") {
+		a.SetGroupAccess("public", GroupAccess{ReadView: "all",
+			NotifyView: "all"})
+	}
+	if !a.vacm.hasGroupAccess("private") {
+		a.SetGroupAccess("private", GroupAccess{ReadView: "all",
+			WriteView: "all", NotifyView: "all"})
+	}
 }
 
-// checkCommunity handles "authentication" and acls
-func (a *Agent) checkCommunity(community string) (rw bool, err error) {
+// SetBinaryCommunities works like SetCommunities for communities that aren't
+// printable text, also replacing any community added before. Communities are
+// always compared byte by byte; AddBinaryCommunity adds more of them.
+func (a *Agent) SetBinaryCommunities(public, private []byte) {
+	a.SetCommunities(string(public), string(private))
+}
 
-	// Access check. Right now only read-only community is implemented
-	if community != a.public && community != a.private {
-		// The agent should ignore invalid communities
-		err = fmt.Errorf("invalid community \"%s\"", community)
+// SetSupportedVersions defines the SNMP versions accepted by the agent.
+// Messages of other versions are discarded. The agent processes Version1,
+// Version2c and Version3 messages; only Version1 is accepted by default.
+func (a *Agent) SetSupportedVersions(versions ...int) error {
+	for _, version := range versions {
+		if version != Version1 && version != Version2c && version != Version3 {
+			return fmt.Errorf("SNMP version %d is not implemented", version)
+		}
+	}
+	a.versions = append([]int{}, versions...)
+	return nil
+}
+
+// SupportedVersions returns the SNMP versions accepted by the agent.
+func (a *Agent) SupportedVersions() []int {
+	return append([]int{}, a.versions...)
+}
+
+// supportsVersion checks if messages of a given version are accepted.
+func (a *Agent) supportsVersion(version int) bool {
+	for _, v := range a.versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// SetStrictV1 enables the strict decoding of SNMPv1 messages. When enabled,
+// SNMPv1 messages with exception values (only defined in SNMPv2) are
+// discarded as malformed.
+func (a *Agent) SetStrictV1(strict bool) {
+	a.strictV1 = strict
+}
+
+// SetAllowTrailingBytes defines whether bytes following a complete message in
+// a datagram, like the padding added by some transports, are ignored. By
+// default such datagrams are discarded.
+func (a *Agent) SetAllowTrailingBytes(allow bool) {
+	a.trailingBytes = allow
+}
+
+// UnknownPduPolicy defines how the agent reports messages with PDU types it
+// doesn't handle.
+type UnknownPduPolicy int
+
+// Policies for unknown PDU types.
+const (
+	// UnknownPduError reports an Unsupported ProcessError. This is the
+	// default.
+	UnknownPduError UnknownPduPolicy = iota
+	// UnknownPduDrop reports ErrDropped.
+	UnknownPduDrop
+)
+
+// NilValuePolicy defines how nil values returned by getters without an error
+// are handled.
+type NilValuePolicy int
+
+// Policies for nil values.
+const (
+	// NilValueNoSuchInstance handles the value as ErrNoSuchInstance: a Get
+	// fails with NoSuchName (returns NoSuchInstance in SNMPv2c) and a GetNext
+	// skips the instance. This is the default.
+	NilValueNoSuchInstance NilValuePolicy = iota
+	// NilValueGenErr reports a GenErr.
+	NilValueGenErr
+)
+
+// SetNilValuePolicy defines how nil values returned by getters without an
+// error are handled, since they can't be encoded.
+func (a *Agent) SetNilValuePolicy(policy NilValuePolicy) {
+	a.nilValue = policy
+}
+
+// SetUnknownPduPolicy defines how messages with unknown PDU types are
+// reported by ProcessMessage and ProcessDatagram.
+func (a *Agent) SetUnknownPduPolicy(policy UnknownPduPolicy) {
+	a.unknown = policy
+}
+
+// WriteAuthorizer is a function called to authorize the write of a managed
+// object before calling its Setter.
+type WriteAuthorizer func(community string, oid asn1.Oid) error
+
+// SetWriteAuthorizer defines a function to authorize every write. When it
+// returns an error the SetRequest fails: the status of a VarError is used in
+// the response and other errors are reported as NoAccess.
+func (a *Agent) SetWriteAuthorizer(authorize WriteAuthorizer) {
+	a.authorize = authorize
+}
+
+// authorizeWrite calls the write authorizer, if any.
+func (a *Agent) authorizeWrite(community string, oid asn1.Oid) error {
+	if a.authorize == nil {
+		return nil
+	}
+	return a.authorize(community, oid)
+}
+
+// SetDeduplication enables the deduplication of the OIDs of a request. When
+// enabled, a value is retrieved only once for an OID repeated in the variable
+// bindings of a GetRequest or a GetNextRequest. The response still contains
+// a variable binding for each one of the request.
+func (a *Agent) SetDeduplication(enabled bool) {
+	a.dedup = enabled
+}
+
+// SetCommitFunc defines functions called to commit or undo the changes made
+// by the setters of a SetRequest. After all setters succeed, commit is called
+// and, if it fails, the response has the CommitFailed status. When a setter or
+// commit fails, undo is called and, if it also fails, the response has the
+// UndoFailed status. SNMPv1 responses have the GenErr status instead. Both
+// functions are optional.
+func (a *Agent) SetCommitFunc(commit, undo func(community string) error) {
+	a.commit, a.undo = commit, undo
+}
+
+// commitSet calls the commit function after a successful SetRequest or, after
+// a failed one, reverts the written objects that can be undone and calls the
+// undo function.
+func (a *Agent) commitSet(community string, res GetResponsePdu,
+	written []writtenValue) GetResponsePdu {
+
+	if res.ErrorStatus == NoError {
+		if a.commit == nil {
+			return res
+		}
+		err := a.commit(community)
+		if err == nil {
+			return res
+		}
+		a.logf(LogError, "commit failed: %s\n", err)
+		res.ErrorStatus = CommitFailed
+		res.ErrorIndex = 0
+	}
+	// Revert in the reverse order of the writes
+	for i := len(written) - 1; i >= 0; i-- {
+		a.undoValue(written[i].h, written[i].value)
+	}
+	if a.undo != nil {
+		if err := a.undo(community); err != nil {
+			a.logf(LogError, "undo failed: %s\n", err)
+			res.ErrorStatus = UndoFailed
+		}
+	}
+	return res
+}
+
+// writtenValue is a value written by the setter of an object that can be
+// undone.
+type writtenValue struct {
+	h     *managedObject
+	value interface{}
+}
+
+// SetResponseCommunity defines a function that returns the community of the
+// response to a request with the given community. By default responses carry
+// the community of the request. A nil function restores the default.
+func (a *Agent) SetResponseCommunity(community func(request string) string) {
+	a.responseCommunity = community
+}
+
+// SetMaxResponseSize defines the maximum size in bytes of an encoded
+// response. Requests whose response would be larger are answered with the
+// TooBig error, or dropped and counted in snmpSilentDrops when even that
+// response is too large. A value of zero (the default) means no limit.
+func (a *Agent) SetMaxResponseSize(size int) {
+	a.maxSize = size
+}
+
+// SetEndOfMibHandler defines a function called when a GetNext finds no object
+// after lastOid. The function may return a variable to be used in the
+// response, otherwise it should return false and the usual end of MIB
+// response is used: a NoSuchName error in SNMPv1 and an EndOfMibView value in
+// SNMPv2c. It's also called for the variables of GetBulk requests.
+func (a *Agent) SetEndOfMibHandler(handler func(lastOid asn1.Oid) (Variable, bool)) {
+	a.endOfMib = handler
+}
+
+// SetMaxWalkSteps limits the number of successor searches performed while
+// processing a single request. When the limit is exceeded the walk ends as if
+// there were no more managed objects. A value of zero (the default) means no
+// limit.
+func (a *Agent) SetMaxWalkSteps(steps int) {
+	a.maxSteps = steps
+}
+
+// defaultMaxRepetitions is the default limit of the repetitions of GetBulk
+// requests.
+const defaultMaxRepetitions = 1000
+
+// SetMaxRepetitions limits the max-repetitions of GetBulk requests, so that
+// a manager can't make the agent walk nor ask the NextN functions of table
+// columns for more instances than that. Larger values are handled as the
+// limit. The default is 1000; a value of zero means no limit.
+func (a *Agent) SetMaxRepetitions(repetitions int) {
+	a.maxRepetitions = repetitions
+}
+
+// checkCommunity verifies the community of a request and returns the views
+// of its VACM group. Invalid communities are counted in counters.
+func (a *Agent) checkCommunity(request *Message,
+	counters *snmpCounters) (views requestViews, err error) {
+
+	// Peers authenticated by the transport don't need a community
+	if a.noCommunity {
+		views.read, views.notify = fullView, fullView
+		if a.trustedAccess == AccessReadWrite {
+			views.write = fullView
+		}
 		return
 	}
 
-	// Super complex ACLs
-	if community == a.private {
-		rw = true
+	// All communities are always compared so the timing doesn't tell which
+	// one matched.
+	known := false
+	for _, community := range a.communities {
+		if sameCommunity(request.Community, community) {
+			known = true
+		}
 	}
+	if !known {
+		// The agent should ignore invalid communities
+		counters.inc(&counters.inBadCommunityNames)
+		err = processErrorf(Drop, "invalid community %s",
+			printableCommunity(request.Community))
+		return
+	}
+
+	// Communities without access entries have empty views
+	views, _ = a.vacm.lookup(communitySecurityModel(request.Version),
+		request.Community, "", 0)
 	return
 }
 
+// sameCommunity compares communities in constant time, so the time taken to
+// reject a request doesn't reveal how many bytes of a community are right.
+// Only the length of the community can be learned.
+func sameCommunity(received, configured string) bool {
+	return subtle.ConstantTimeCompare([]byte(received), []byte(configured)) == 1
+}
+
+// SetCommunityRequired defines whether requests must carry one of the agent
+// communities, which is the default. Transports that already authenticate
+// their peers, like DTLS or unix sockets, may disable the check. Requests are
+// then accepted with any community and the access level defined by
+// SetTrustedAccess.
+func (a *Agent) SetCommunityRequired(required bool) {
+	a.noCommunity = !required
+}
+
+// SetTrustedAccess defines the access level of requests when communities are
+// not required. The default is AccessReadWrite.
+func (a *Agent) SetTrustedAccess(access Access) {
+	a.trustedAccess = access
+}
+
+// printableCommunity returns a representation of a community that is safe to
+// log. Communities containing non-printable characters are hex encoded.
+func printableCommunity(community string) string {
+	for i := 0; i < len(community); i++ {
+		if community[i] < 0x20 || community[i] > 0x7e {
+			return "0x" + hex.EncodeToString([]byte(community))
+		}
+	}
+	return "\"" + community + "\""
+}
+
+// SetRootOid defines a prefix for the OIDs of the managed objects registered
+// afterwards, so that the registration methods take OIDs relative to it.
+// Requests are still matched against the absolute OIDs. This allows agents
+// sharing a process to live under different subtrees. Objects registered
+// before the call keep their OIDs, and the objects of standard MIBs, like
+// the system group, are always registered with their own OIDs.
+func (a *Agent) SetRootOid(root asn1.Oid) {
+	a.root = append(asn1.Oid(nil), root...)
+}
+
+// absoluteOid prepends the root OID of the agent to an OID.
+func (a *Agent) absoluteOid(oid asn1.Oid) asn1.Oid {
+	if len(a.root) == 0 {
+		return oid
+	}
+	abs := make(asn1.Oid, 0, len(a.root)+len(oid))
+	abs = append(abs, a.root...)
+	return append(abs, oid...)
+}
+
 // AddRoManagedObject registers a read-only managed object.
 func (a *Agent) AddRoManagedObject(oid asn1.Oid, getter Getter) error {
 	return a.AddRwManagedObject(oid, getter, nil)
 }
 
+// AddRoRequestManagedObject registers a read-only managed object whose getter
+// receives the requested OID in addition to the object OID.
+func (a *Agent) AddRoRequestManagedObject(oid asn1.Oid, getter RequestGetter) error {
+	if getter == nil {
+		return fmt.Errorf("a managed object should have at least a getter")
+	}
+	return a.addManagedObject(managedObject{
+		oid: oid,
+		get: func(oid asn1.Oid) (interface{}, error) {
+			return getter(oid, oid)
+		},
+		getRequested: getter,
+		set:          notWritable,
+		readOnly:     true,
+	})
+}
+
+// AddRoManagedObjectAliases registers a read-only managed object under several
+// OIDs. The getter receives the OID being requested. No object is registered
+// if any of the OIDs is invalid or already registered.
+func (a *Agent) AddRoManagedObjectAliases(oids []asn1.Oid, getter Getter) error {
+	if getter == nil {
+		return fmt.Errorf("a managed object should have at least a getter")
+	}
+	objects := make([]managedObject, len(oids))
+	for i, oid := range oids {
+		h := managedObject{oid: a.absoluteOid(oid), get: getter, set: notWritable,
+			readOnly: true}
+		if err := checkOid(h.oid); err != nil {
+			return err
+		}
+		for _, o := range objects[:i] {
+			if o.oid.Cmp(h.oid) == 0 {
+				return fmt.Errorf("OID %s is given twice", h.oid)
+			}
+		}
+		if a.overlaps(h) {
+			return fmt.Errorf("OID %d is already registered", h.oid)
+		}
+		objects[i] = h
+	}
+	a.handlers = append(a.handlers, objects...)
+	sort.Sort(sortableManagedObjects(a.handlers))
+	return nil
+}
+
 // AddRwManagedObject registers a read-write managed object.
 //
 // The inteface{} values returned by a Getter or received by a Setter must be
-// of one of the following types:
-//
-//	int
-//	string
-//	asn1.Null
-//	asn1.Oid
-//	snmp.Counter32
-//	snmp.Counter64
-//	snmp.IpAddress
-//	snmp.Opaque
-//	snmp.TimeTicks
-//	snmp.Unsigned32
-//
+// of one of the types listed in NewVariable.
 func (a *Agent) AddRwManagedObject(oid asn1.Oid, getter Getter,
 	setter Setter) error {
 
@@ -183,26 +590,252 @@ func (a *Agent) AddRwManagedObject(oid asn1.Oid, getter Getter,
 		return fmt.Errorf("a managed object should have at least a getter")
 	}
 	if setter == nil {
-		setter = func(oid asn1.Oid, value interface{}) error {
-			return VarErrorf(NotWritable, "OID %s is not writable", oid)
+		return a.addManagedObject(managedObject{oid: oid, get: getter,
+			set: notWritable, readOnly: true})
+	}
+	return a.addManagedObject(managedObject{oid: oid, get: getter, set: setter})
+}
+
+// ManagedObjectSpec describes a managed object for AddManagedObjectsBatch. A
+// nil Setter defines a read-only object.
+type ManagedObjectSpec struct {
+	Oid    asn1.Oid
+	Getter Getter
+	Setter Setter
+}
+
+// AddManagedObjectsBatch registers a set of managed objects. Either all of
+// them are registered or none is. When any registration fails, the returned
+// slice holds the error of each spec, with nil for valid ones. It returns
+// nil on success.
+func (a *Agent) AddManagedObjectsBatch(specs []ManagedObjectSpec) []error {
+	handlers := make([]managedObject, len(a.handlers))
+	copy(handlers, a.handlers)
+
+	errs := make([]error, len(specs))
+	failed := false
+	for i, spec := range specs {
+		errs[i] = a.AddRwManagedObject(spec.Oid, spec.Getter, spec.Setter)
+		if errs[i] != nil {
+			failed = true
 		}
 	}
-	if a.getManagedObject(oid, false) != nil {
-		return fmt.Errorf("OID %d is already registered", oid)
+	if !failed {
+		return nil
+	}
+	a.handlers = handlers
+	return errs
+}
+
+// addStandardObject registers a managed object of a standard MIB, like the
+// system group, whose OID is absolute and ignores the root OID of the agent.
+func (a *Agent) addStandardObject(oid asn1.Oid, getter Getter, setter Setter) error {
+	if setter == nil {
+		return a.register(managedObject{oid: oid, get: getter, set: notWritable,
+			readOnly: true})
+	}
+	return a.register(managedObject{oid: oid, get: getter, set: setter})
+}
+
+// notWritable is the Setter of read-only managed objects, which are rejected
+// before calling it.
+func notWritable(oid asn1.Oid, value interface{}) error {
+	return VarErrorf(NotWritable, "OID %s is not writable", oid)
+}
+
+// addManagedObject validates and registers a managed object. The OID of the
+// object is relative to the root OID of the agent.
+func (a *Agent) addManagedObject(h managedObject) error {
+	h.oid = a.absoluteOid(h.oid)
+	return a.register(h)
+}
+
+// register validates and registers a managed object with an absolute OID.
+func (a *Agent) register(h managedObject) error {
+	if err := checkOid(h.oid); err != nil {
+		return err
+	}
+	if a.overlaps(h) {
+		return fmt.Errorf("OID %d is already registered", h.oid)
 	}
-	h := managedObject{oid, nil, getter, setter}
 	a.handlers = append(a.handlers, h)
 	sort.Sort(sortableManagedObjects(a.handlers))
 	return nil
 }
 
+// overlaps checks if a managed object conflicts with a registered one. Table
+// columns conflict with any object registered under them.
+func (a *Agent) overlaps(h managedObject) bool {
+	for _, r := range a.handlers {
+		if r.oid.Cmp(h.oid) == 0 ||
+			(r.indexer != nil && hasPrefix(h.oid, r.oid)) ||
+			(h.indexer != nil && hasPrefix(r.oid, h.oid)) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetObjectType declares the type of the values of a registered managed
+// object or table column. The type is given by a value of it, for example
+// snmp.Counter32(0). Objects declared as counters are never writable.
+// Getters of objects declared as Counter32, Counter64, TimeTicks or
+// Unsigned32 may return plain uint, uint32 or uint64 values.
+func (a *Agent) SetObjectType(oid asn1.Oid, value interface{}) error {
+	h := a.lookupRelative(oid)
+	if h == nil {
+		return fmt.Errorf("OID %s is not registered", oid)
+	}
+	if value == nil {
+		h.typ = nil
+	} else {
+		h.typ = reflect.TypeOf(value)
+	}
+	return nil
+}
+
+// HasManagedObject reports whether a managed object or table column is
+// registered with exactly the given OID, relative to the root OID of the
+// agent, or absolute for objects registered before SetRootOid and standard
+// ones like the system group. Instances of table columns are not considered.
+func (a *Agent) HasManagedObject(oid asn1.Oid) bool {
+	return a.lookupRelative(oid) != nil
+}
+
+// lookupRelative returns the managed object registered with the given OID,
+// relative to the root OID of the agent, or else absolute.
+func (a *Agent) lookupRelative(oid asn1.Oid) *managedObject {
+	if h := a.registered(a.absoluteOid(oid)); h != nil {
+		return h
+	}
+	return a.registered(oid)
+}
+
+// registered returns the managed object registered with the given absolute
+// OID.
+func (a *Agent) registered(oid asn1.Oid) *managedObject {
+	// Handlers are kept sorted
+	i := sort.Search(len(a.handlers), func(i int) bool {
+		return a.handlers[i].oid.Cmp(oid) >= 0
+	})
+	if i < len(a.handlers) && a.handlers[i].oid.Cmp(oid) == 0 {
+		return &a.handlers[i]
+	}
+	return nil
+}
+
+// checkOid verifies if an OID can be encoded in BER.
+func checkOid(oid asn1.Oid) error {
+	if len(oid) < 2 {
+		return fmt.Errorf("OID %s should have at least two sub-identifiers", oid)
+	}
+	if oid[0] > 2 {
+		return fmt.Errorf("OID %s has an invalid first arc %d", oid, oid[0])
+	}
+	if oid[0] < 2 && oid[1] > 39 {
+		return fmt.Errorf("OID %s has an invalid second arc %d", oid, oid[1])
+	}
+	return nil
+}
+
 // managedObject represents a registered managed object.
 type managedObject struct {
 	oid asn1.Oid
-	// TODO Add type check inside the agent processing.
+	// typ is the declared type of the object values, if any.
 	typ reflect.Type
 	get Getter
-	set Setter
+	// getRequested replaces get for objects whose getters also receive the
+	// requested OID.
+	getRequested RequestGetter
+	set          Setter
+	// undoSet is set for objects whose writes can be reverted when a
+	// later variable of the same SET, or the commit, fails.
+	undoSet func(oid asn1.Oid, value interface{})
+	// readOnly is set for objects registered without a setter.
+	readOnly bool
+	// indexer is set for table columns, whose instances are enumerated
+	// dynamically.
+	indexer Indexer
+	// batch is set for table columns whose instances can be retrieved
+	// several at once.
+	batch *columnBatch
+	// rowStatus is set for RowStatus columns, whose missing instances can
+	// be SET to create rows.
+	rowStatus bool
+	// readCreate is set for read-create columns, which can only be SET
+	// along with the creation of the row.
+	readCreate bool
+	// rowKey identifies the row of an instance of a RowStatus or
+	// read-create column.
+	rowKey func(oid asn1.Oid) string
+}
+
+// isCounter checks if the object is declared as a counter.
+func (h *managedObject) isCounter() bool {
+	return h.typ == reflect.TypeOf(Counter32(0)) ||
+		h.typ == reflect.TypeOf(Counter64(0))
+}
+
+// accepts checks if a value has the ASN.1 type declared for the object, if
+// any. Types sharing an encoding, like string and Bits, are equivalent since
+// they can't be told apart once decoded.
+func (h *managedObject) accepts(value interface{}) bool {
+	if h.typ == nil {
+		return true
+	}
+	class, tag, ok := ValueTag(value)
+	declaredClass, declaredTag, _ := ValueTag(reflect.Zero(h.typ).Interface())
+	return ok && class == declaredClass && tag == declaredTag
+}
+
+// normalize converts a value received in a SET to the declared type of the
+// object, when loosely typed clients use another representation. IP
+// addresses are accepted in dotted notation.
+func (h *managedObject) normalize(value interface{}) interface{} {
+	if s, ok := value.(string); ok && h.typ == reflect.TypeOf(IPAddress{}) {
+		if ip := net.ParseIP(s).To4(); ip != nil {
+			return IPAddress{ip[0], ip[1], ip[2], ip[3]}
+		}
+	}
+	return value
+}
+
+// unsigned converts a uint, uint32 or uint64 returned by a getter to the
+// declared type of the object, which must be Counter32, Counter64, TimeTicks
+// or Unsigned32. Other values are returned unchanged.
+func (h *managedObject) unsigned(value interface{}) (interface{}, error) {
+	var n uint64
+	switch v := value.(type) {
+	case uint:
+		n = uint64(v)
+	case uint32:
+		n = uint64(v)
+	case uint64:
+		n = v
+	default:
+		return value, nil
+	}
+	if h.typ == nil {
+		return nil, VarErrorf(GenErr, "%T value of OID %s has no declared type",
+			value, h.oid)
+	}
+	if h.typ == reflect.TypeOf(Counter64(0)) {
+		return Counter64(n), nil
+	}
+	if n > math.MaxUint32 {
+		return nil, VarErrorf(GenErr, "value %d of OID %s is out of range",
+			n, h.oid)
+	}
+	switch h.typ {
+	case reflect.TypeOf(Counter32(0)):
+		return Counter32(n), nil
+	case reflect.TypeOf(TimeTicks(0)):
+		return TimeTicks(n), nil
+	case reflect.TypeOf(Unsigned32(0)):
+		return Unsigned32(n), nil
+	}
+	return nil, VarErrorf(GenErr, "%T value of OID %s doesn't match its type %s",
+		value, h.oid, h.typ)
 }
 
 // sortableManagedObjects is a helper type to sort managed objects slices.
@@ -217,7 +850,28 @@ func (h sortableManagedObjects) Less(i, j int) bool {
 // getManagedObject returns the exact managed object for the given OID when
 // next=false  or the next object when next=true.
 func (a *Agent) getManagedObject(oid asn1.Oid, next bool) *managedObject {
-	for _, h := range a.handlers {
+	// Only the last object not after oid may hold it, as a table column
+	// cannot overlap other objects, so the search starts there.
+	start := sort.Search(len(a.handlers), func(i int) bool {
+		return a.handlers[i].oid.Cmp(oid) > 0
+	})
+	if start > 0 {
+		start--
+	}
+	for _, h := range a.handlers[start:] {
+		if h.indexer != nil {
+			// Table columns only match their instances
+			if hasPrefix(oid, h.oid) || (next && oid.Cmp(h.oid) < 0) {
+				if i := h.instance(oid, next); i != nil || !next {
+					return i
+				}
+			} else if !next && oid.Cmp(h.oid) < 0 {
+				break
+			}
+			continue
+		}
+		// An OID sorts before the OIDs it is a prefix of, so a GetNext of
+		// an object without the instance suffix returns its instance.
 		cmp := oid.Cmp(h.oid)
 		if (!next && cmp == 0) || (next && cmp < 0) {
 			return &h
@@ -229,113 +883,525 @@ func (a *Agent) getManagedObject(oid asn1.Oid, next bool) *managedObject {
 	return nil
 }
 
+// nextManagedObject returns the managed object following oid. Each call is a
+// step of the walk limited by SetMaxWalkSteps.
+func (a *Agent) nextManagedObject(oid asn1.Oid, steps *int) *managedObject {
+	*steps++
+	if a.maxSteps > 0 && *steps > a.maxSteps {
+		a.logf(LogInfo, "walk limit of %d steps exceeded\n", a.maxSteps)
+		return nil
+	}
+	return a.getManagedObject(oid, true)
+}
+
+// nextValue returns the value of the first managed object after oid in a
+// view. Instances reported as missing by the getter are skipped. It returns
+// a nil object at the end of the MIB.
+func (a *Agent) nextValue(oid asn1.Oid, steps *int, view mibView) (h *managedObject,
+	value interface{}, err error) {
+
+	h = a.nextManagedObject(oid, steps)
+	for h != nil {
+		if view.contains(h.oid) {
+			value, err = a.getValue(h, oid)
+			if err != ErrNoSuchInstance {
+				return
+			}
+		}
+		h = a.nextManagedObject(h.oid, steps)
+	}
+	return nil, nil, nil
+}
+
 // ProcessMessage handles a SNMP Message.
+//
+// The values of the variables of Get and GetNext requests should be NULL.
+// Other values are logged and ignored.
 func (a *Agent) ProcessMessage(request *Message) (response *Message, err error) {
-	// SNMPv1 only for now
-	if request.Version != 0 {
-		// Discard SNMPv2 messages
-		err = fmt.Errorf("invalid SNMP version %d", request.Version)
+	return a.processMessage(context.Background(), request, a.maxSize)
+}
+
+// processMessage handles a SNMP Message whose response should not be larger
+// than maxSize bytes (no limit when maxSize is 0). Variables are no longer
+// processed once ctx is done.
+func (a *Agent) processMessage(ctx context.Context, request *Message,
+	maxSize int) (response *Message, err error) {
+
+	check := func(request *Message) (requestViews, error) {
+		return a.checkCommunity(request, &a.snmp)
+	}
+	if request.Version == Version3 {
+		// Without their security parameters, SNMPv3 requests can't be
+		// authenticated
+		check = func(*Message) (requestViews, error) {
+			return requestViews{}, processErrorf(Unsupported,
+				"SNMPv3 messages are only processed as datagrams")
+		}
+	}
+	return a.process(ctx, request, maxSize, check)
+}
+
+// process handles a SNMP Message whose community, or user name for SNMPv3, is
+// verified by check, which returns the views the request can access.
+func (a *Agent) process(ctx context.Context, request *Message, maxSize int,
+	check func(request *Message) (requestViews, error)) (response *Message,
+	err error) {
+
+	defer func() {
+		if err != nil {
+			a.logf(LogError, "request failed: %s\n", err)
+		}
+		a.stats.add(request, response, err)
+	}()
+
+	if !a.supportsVersion(request.Version) {
+		// Discard messages of other versions
+		a.snmp.inc(&a.snmp.inBadVersions)
+		err = processErrorf(Unsupported, "invalid SNMP version %d",
+			request.Version)
 		return
 	}
 
-	rw, err := a.checkCommunity(request.Community)
+	views, err := check(request)
 	if err != nil {
 		return
 	}
+	if !a.limiter.allow(request.Community) {
+		err = processErrorf(Drop, "rate limit exceeded for community %s",
+			printableCommunity(request.Community))
+		return
+	}
 
 	// Dispatch each type of PDU
-	a.log.Printf("request: %#v\n", request)
+	a.logf(LogDebug, "request: %#v\n", request)
 	var res GetResponsePdu
 	switch pdu := request.Pdu.(type) {
 	case GetRequestPdu:
-		res = a.processPdu(Pdu(pdu), false, false)
+		res = a.processPdu(ctx, request, Pdu(pdu), false, false, views)
 	case GetNextRequestPdu:
-		res = a.processPdu(Pdu(pdu), true, false)
+		res = a.processPdu(ctx, request, Pdu(pdu), true, false, views)
 	case SetRequestPdu:
-		if rw {
-			res = a.processPdu(Pdu(pdu), false, true)
+		if views.write != nil {
+			res = a.processPdu(ctx, request, Pdu(pdu), false, true, views)
 		} else {
+			a.snmp.inc(&a.snmp.inBadCommunityUses)
 			res = GetResponsePdu(pdu)
 			res.ErrorIndex = 1
-			res.ErrorStatus = NoSuchName
+			res.ErrorStatus = NoAccess
 		}
+	case GetBulkRequestPdu:
+		if request.Version == Version1 {
+			// GetBulk is not defined in SNMPv1
+			err = a.unsupportedPdu(request)
+			return
+		}
+		res = a.processBulk(ctx, request, BulkPdu(pdu), maxSize, views.read)
 	default:
-		// SNMPv2 PDUs are ignored
-		err = fmt.Errorf("PDU not supported: %T", request.Pdu)
+		err = a.unsupportedPdu(request)
 		return
 	}
+	if request.Version == Version1 {
+		// Error statuses are given as in SNMPv2 and translated once
+		res.ErrorStatus = v1ErrorStatus(res.ErrorStatus)
+	}
 
 	// Copy request
 	copy := *request
 	response = &copy
+	if a.responseCommunity != nil && request.Version != Version3 {
+		response.Community = a.responseCommunity(request.Community)
+	}
 
 	// Set response
 	response.Pdu = res
-	a.log.Printf("response: %#v\n", response)
+	if bulk, ok := request.Pdu.(GetBulkRequestPdu); ok && maxSize > 0 {
+		res = truncateBulk(response, BulkPdu(bulk), maxSize)
+		response.Pdu = res
+	}
+	if maxSize > 0 && estimateMessageSize(response) > maxSize {
+		a.logf(LogInfo, "response larger than %d bytes\n", maxSize)
+		res.ErrorStatus = TooBig
+		res.ErrorIndex = 0
+		// SNMPv1 returns the variables of the request, SNMPv2 none
+		res.Variables = pduVariables(request.Pdu)
+		if request.Version != Version1 {
+			res.Variables = []Variable{}
+		}
+		response.Pdu = res
+		if estimateMessageSize(response) > maxSize {
+			// Responses that can't even report the error are dropped
+			a.snmp.inc(&a.snmp.silentDrops)
+			response = nil
+			err = processErrorf(Drop, "tooBig response larger than %d bytes",
+				maxSize)
+			return
+		}
+	}
+	a.logf(LogInfo, "%T from community %s: %d variables, error status %d\n",
+		request.Pdu, printableCommunity(request.Community),
+		len(pduVariables(request.Pdu)), res.ErrorStatus)
+	a.logf(LogDebug, "response: %#v\n", response)
+	return
+}
+
+// unsupportedPdu returns the error for a request whose PDU the agent doesn't
+// handle, as defined by SetUnknownPduPolicy.
+func (a *Agent) unsupportedPdu(request *Message) error {
+	if a.unknown == UnknownPduDrop {
+		return ErrDropped
+	}
+	return processErrorf(Unsupported, "PDU not supported: %T", request.Pdu)
+}
+
+// ProcessMessageBytes works like ProcessMessage but returns the encoded
+// response.
+func (a *Agent) ProcessMessageBytes(request *Message) (responseBytes []byte, err error) {
+	response, err := a.ProcessMessage(request)
+	if err != nil {
+		return
+	}
+
+	responseBytes, err = a.ctx.Encode(*response)
+	if err != nil {
+		err = processErrorf(Internal, "failed to encode response: %s", err)
+	}
 	return
 }
 
 // ProcessDatagram handles a binany SNMP message.
 func (a *Agent) ProcessDatagram(requestBytes []byte) (responseBytes []byte, err error) {
-	// Decode message. Invalid messages are discarded
-	request := Message{}
-	ctx := Asn1Context()
-	remaining, err := ctx.Decode(requestBytes, &request)
+	return a.ProcessDatagramContext(context.Background(), requestBytes)
+}
+
+// ProcessDatagramFrom works like ProcessDatagram for a datagram received from
+// src. The hook defined by SetPreProcessHook is called before decoding it.
+func (a *Agent) ProcessDatagramFrom(requestBytes []byte,
+	src net.Addr) (responseBytes []byte, err error) {
+
+	if a.preProcess != nil {
+		if err = a.preProcess(requestBytes, src); err != nil {
+			err = processErrorf(Drop, "datagram from %s rejected: %s", src, err)
+			return
+		}
+	}
+	return a.ProcessDatagram(requestBytes)
+}
+
+// SetPreProcessHook defines a function called with the raw bytes and the
+// source of each datagram handled by ProcessDatagramFrom. Datagrams for
+// which the hook returns an error are dropped.
+func (a *Agent) SetPreProcessHook(hook func(raw []byte, src net.Addr) error) {
+	a.preProcess = hook
+}
+
+// ProcessDatagramContext works like ProcessDatagram but bounds the time spent
+// with the request. Once the context is done, the remaining variables are not
+// processed and a GenErr is returned for the first of them. Getters already
+// running are not interrupted.
+func (a *Agent) ProcessDatagramContext(ctx context.Context,
+	requestBytes []byte) (responseBytes []byte, err error) {
+
+	a.snmp.inc(&a.snmp.inPkts)
+	if isV3Message(requestBytes) {
+		r, err := a.decodeV3Datagram(requestBytes)
+		if err != nil {
+			return nil, err
+		}
+		return a.processV3(ctx, r)
+	}
+	request, err := a.decodeDatagram(requestBytes, &a.snmp)
 	if err != nil {
 		return
 	}
-	if len(remaining) > 0 {
-		err = fmt.Errorf("%d remaining bytes.\n", len(remaining))
+	return a.processDecoded(ctx, request)
+}
+
+// HandleDatagram works like ProcessDatagram but tells whether a response
+// should be sent. Messages that never take a response, like traps, are
+// handled without error and with respond set to false. respond is also false
+// when an error is returned.
+func (a *Agent) HandleDatagram(requestBytes []byte) (responseBytes []byte,
+	respond bool, err error) {
+
+	a.snmp.inc(&a.snmp.inPkts)
+	if isV3Message(requestBytes) {
+		r, err := a.decodeV3Datagram(requestBytes)
+		if err != nil {
+			return nil, false, err
+		}
+		if !needsResponse(r.scoped.Pdu) {
+			a.logf(LogInfo, "%T received, no response sent\n", r.scoped.Pdu)
+			return nil, false, nil
+		}
+		responseBytes, err = a.processV3(context.Background(), r)
+		return responseBytes, err == nil, err
+	}
+	request, err := a.decodeDatagram(requestBytes, &a.snmp)
+	if err != nil {
+		return
+	}
+	if !needsResponse(request.Pdu) {
+		a.logf(LogInfo, "%T received, no response sent\n", request.Pdu)
 		return
 	}
+	responseBytes, err = a.processDecoded(context.Background(), request)
+	respond = err == nil
+	return
+}
+
+// needsResponse checks if a PDU is a request. Traps, responses and reports
+// never take a response.
+func needsResponse(pdu interface{}) bool {
+	switch pdu.(type) {
+	case V1TrapPdu, V2TrapPdu, GetResponsePdu, ReportPdu:
+		return false
+	}
+	return true
+}
 
-	// Process message
-	response, err := a.ProcessMessage(&request)
+// decodeDatagram decodes a binary SNMP message. Invalid messages are
+// reported with Drop errors and counted in counters.
+func (a *Agent) decodeDatagram(requestBytes []byte,
+	counters *snmpCounters) (request *Message, err error) {
+	request = &Message{}
+	remaining, err := a.ctx.Decode(requestBytes, request)
 	if err != nil {
+		// Values with unknown tags are a common interoperability problem
+		if e := describeValueError(requestBytes, true); e != nil {
+			err = e
+		}
+		counters.inc(&counters.inASNParseErrs)
+		err = processErrorf(Drop, "invalid message: %s", err)
+		a.logf(LogError, "%s\n", err)
+		return
+	}
+	if len(remaining) > 0 && a.trailingBytes {
+		a.logf(LogDebug, "ignoring %d trailing bytes\n", len(remaining))
+	} else if len(remaining) > 0 {
+		err = processErrorf(Drop, "%d remaining bytes.\n", len(remaining))
+		a.logf(LogError, "invalid message: %s", err)
 		return
 	}
+	if a.strictV1 && request.Version == Version1 &&
+		hasExceptions(pduVariables(request.Pdu)) {
+		err = processErrorf(Drop, "invalid message: SNMPv2 exception in SNMPv1")
+		a.logf(LogError, "%s\n", err)
+	}
+	return
+}
 
-	responseBytes, err = ctx.Encode(*response)
+// processDecoded handles a decoded message and encodes its response.
+func (a *Agent) processDecoded(ctx context.Context,
+	request *Message) (responseBytes []byte, err error) {
+
+	response, err := a.processMessage(ctx, request, a.maxSize)
+	if err != nil {
+		return
+	}
+
+	responseBytes, err = a.ctx.Encode(*response)
+	if err != nil {
+		err = processErrorf(Internal, "failed to encode response: %s", err)
+		a.logf(LogError, "%s\n", err)
+		return
+	}
+	a.delay(ctx)
 	return
 }
 
-// processPdu handles SNMPv1 requests with exception of SnmpV1TrapPdu.
-func (a *Agent) processPdu(pdu Pdu, next bool, set bool) GetResponsePdu {
+// SetResponseDelay makes datagram responses wait a random duration between
+// min and max, to simulate slow agents when testing managers. The delay is cut
+// short if the context of ProcessDatagramContext is done. A max of zero
+// disables it, which is the default.
+func (a *Agent) SetResponseDelay(min, max time.Duration) {
+	a.minDelay = min
+	a.maxDelay = max
+}
+
+// delay waits the response delay, if any.
+func (a *Agent) delay(ctx context.Context) {
+	if a.maxDelay <= 0 {
+		return
+	}
+	d := a.minDelay
+	if a.maxDelay > a.minDelay {
+		d += time.Duration(rand.Int63n(int64(a.maxDelay - a.minDelay)))
+	}
+	wait(ctx, d)
+}
+
+// Time functions of the agent, replaced by tests to avoid depending on the
+// scheduler.
+var (
+	timeNow = time.Now
+	wait    = waitContext
+)
+
+// waitContext waits for d or until ctx is done.
+func waitContext(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// processPdu handles SNMPv1 and SNMPv2c Get, GetNext and Set requests. In
+// SNMPv2c, missing objects of Get and GetNext requests are reported by
+// exception values instead of a noSuchName error, so the other variables are
+// still answered. Objects out of the read view are handled as missing, while
+// writes out of the write view get a noAccess error.
+func (a *Agent) processPdu(ctx context.Context, request *Message, pdu Pdu,
+	next bool, set bool, views requestViews) (res GetResponsePdu) {
 
 	// Keep returned values in a separated slice for a Get request
 	var variables []Variable
 
+	// Values already retrieved for repeated OIDs
+	var retrieved map[string]Variable
+	if a.dedup && !set {
+		retrieved = make(map[string]Variable)
+	}
+
+	// Rows created by a SET, whose read-create columns can be written, and
+	// values to revert if the SET fails
+	var creating map[string]bool
+	var written []writtenValue
+	if set {
+		creating = a.creatingRows(pdu.Variables)
+		defer func() {
+			res = a.commitSet(request.Community, res, written)
+		}()
+	}
+
+	// Values of table columns retrieved by batch getters
+	var batched map[string]batchValue
+	if !set && !next {
+		batched = a.batchValues(pdu.Variables, views.read)
+	}
+
 	var err error
-	res := GetResponsePdu(pdu)
+	steps := 0
+	res = GetResponsePdu(pdu)
 	for i, v := range pdu.Variables {
-		a.log.Printf("oid: %s\n", v.Name)
+		if err = ctx.Err(); err != nil {
+			a.logf(LogError, "request aborted: %s\n", err)
+			res.ErrorIndex = i + 1
+			res.ErrorStatus = GenErr
+			return res
+		}
+		a.logf(LogDebug, "oid: %s\n", a.ResolveOid(v.Name))
+		if _, null := v.Value.(asn1.Null); !set && !null {
+			a.logf(LogInfo, "ignoring %T value of %s in a read request\n",
+				v.Value, v.Name)
+		}
+		if r, ok := retrieved[v.Name.String()]; ok {
+			variables = append(variables, r)
+			continue
+		}
+		// Objects out of the views are hidden, writes are denied
+		if set && !views.write.contains(v.Name) {
+			res.ErrorIndex = i + 1
+			res.ErrorStatus = NoAccess
+			return res
+		}
+		if !set && !next && !views.read.contains(v.Name) {
+			if request.Version != Version1 {
+				variables = append(variables, Variable{v.Name, NoSuchObject{}})
+				continue
+			}
+			res.ErrorIndex = i + 1
+			res.ErrorStatus = NoSuchName
+			return res
+		}
 		// Retrieve the managed object
-		h := a.getManagedObject(v.Name, next)
+		var h *managedObject
+		var value interface{}
+		if next {
+			h, value, err = a.nextValue(v.Name, &steps, views.read)
+		} else {
+			h = a.getManagedObject(v.Name, false)
+			if h == nil && set {
+				h = a.newInstance(v.Name)
+			}
+		}
+		if h == nil && next && a.endOfMib != nil {
+			if r, ok := a.endOfMib(v.Name); ok {
+				variables = append(variables, r)
+				continue
+			}
+		}
+		if h == nil && !set && request.Version != Version1 {
+			// SNMPv2 reports missing objects in the variable
+			r := Variable{v.Name, EndOfMibView{}}
+			if !next {
+				r.Value = a.missingException(v.Name)
+			}
+			variables = append(variables, r)
+			continue
+		}
 		if h == nil {
 			res.ErrorIndex = i + 1
 			res.ErrorStatus = NoSuchName
+			if set {
+				// SNMPv2 tells the object can't be created
+				res.ErrorStatus = NoCreation
+			}
 			return res
 		}
 		// Set or get the value
-		var value interface{}
 		if set {
-			err = h.set(h.oid, v.Value)
-		} else {
-			value, err = h.get(h.oid)
+			if err = a.authorizeWrite(request.Community, h.oid); err != nil {
+				res.ErrorIndex = i + 1
+				res.ErrorStatus = NoAccess
+				if e, ok := err.(VarError); ok {
+					res.ErrorStatus = e.Status
+				}
+				return res
+			}
+			value = h.normalize(v.Value)
+			if h.readOnly {
+				err = VarErrorf(NotWritable, "OID %s is not writable", h.oid)
+			} else if h.isCounter() {
+				err = VarErrorf(NotWritable, "OID %s is a counter", h.oid)
+			} else if h.readCreate && !a.rowWritable(h, creating) {
+				err = VarErrorf(NotWritable,
+					"OID %s can't be written while its row is active", h.oid)
+			} else if !h.accepts(value) {
+				err = VarErrorf(WrongType, "invalid type %T for %s, expected %s",
+					value, h.oid, h.typ)
+			} else if settable(value) {
+				err = a.setValue(h, value)
+				if err == nil && h.undoSet != nil {
+					written = append(written, writtenValue{h, value})
+				}
+			} else {
+				err = VarErrorf(WrongType, "invalid type %T", value)
+			}
+		} else if !next {
+			if b, ok := batched[h.oid.String()]; ok {
+				value, err = b.value, b.err
+			} else {
+				value, err = a.getValue(h, v.Name)
+			}
+			if err == ErrNoSuchInstance && request.Version != Version1 {
+				value, err = NoSuchInstance{}, nil
+			}
 		}
 		if err != nil {
 			res.ErrorIndex = i + 1
-			if e, ok := err.(VarError); ok {
-				res.ErrorStatus = e.Status
-			} else {
-				res.ErrorStatus = GenErr
-			}
+			res.ErrorStatus = errorStatus(err)
 			return res
 		}
 		// Values returned by a Get are kept in a separated list. If an error
 		// occurs the original list of variables should be returned.
 		if !set {
-			variables = append(variables, Variable{h.oid, value})
+			r := Variable{h.oid, value}
+			variables = append(variables, r)
+			if retrieved != nil {
+				retrieved[v.Name.String()] = r
+			}
 		}
 	}
 	if !set {
@@ -345,6 +1411,130 @@ func (a *Agent) processPdu(pdu Pdu, next bool, set bool) GetResponsePdu {
 	return res
 }
 
+// missingException returns the exception reported in SNMPv2 for a Get of an
+// OID without a managed object: NoSuchInstance when the OID is under a table
+// column or is another instance of a registered scalar (whose instance ends
+// in 0), NoSuchObject otherwise.
+func (a *Agent) missingException(oid asn1.Oid) interface{} {
+	for _, h := range a.handlers {
+		if h.indexer != nil && hasPrefix(oid, h.oid) {
+			return NoSuchInstance{}
+		}
+		n := len(h.oid)
+		if h.indexer == nil && n > 1 && h.oid[n-1] == 0 && len(oid) >= n &&
+			hasPrefix(oid, h.oid[:n-1]) {
+			return NoSuchInstance{}
+		}
+	}
+	return NoSuchObject{}
+}
+
+// getValue calls the getter of a managed object for a variable requested as
+// requested. Panics are converted into a GenErr so that a faulty handler
+// doesn't stop the agent, as well as int values that don't fit in an
+// Integer32. Nil values are handled as defined by SetNilValuePolicy. Plain
+// unsigned values are converted to the declared type of the object.
+func (a *Agent) getValue(h *managedObject, requested asn1.Oid) (value interface{},
+	err error) {
+
+	defer a.recoverHandler(h.oid, &err)
+	a.accesses.touch(h.oid)
+	if h.getRequested != nil {
+		value, err = h.getRequested(h.oid, requested)
+	} else {
+		value, err = h.get(h.oid)
+	}
+	return a.checkValue(h, value, err)
+}
+
+// checkValue verifies and converts a value returned by a getter.
+func (a *Agent) checkValue(h *managedObject, value interface{},
+	err error) (interface{}, error) {
+
+	if value == nil && err == nil {
+		if a.nilValue == NilValueGenErr {
+			err = VarErrorf(GenErr, "getter of OID %s returned nil", h.oid)
+		} else {
+			err = ErrNoSuchInstance
+		}
+	}
+	if n, ok := value.(int); ok && err == nil &&
+		(int64(n) < math.MinInt32 || int64(n) > math.MaxInt32) {
+		err = VarErrorf(GenErr, "value %d of OID %s is out of the Integer32 range",
+			n, h.oid)
+	}
+	if err == nil {
+		value, err = h.unsigned(value)
+	}
+	return value, err
+}
+
+// setValue calls the setter of a managed object, recovering from panics like
+// getValue.
+func (a *Agent) setValue(h *managedObject, value interface{}) error {
+	write := func() (err error) {
+		defer a.recoverHandler(h.oid, &err)
+		a.accesses.touch(h.oid)
+		return h.set(h.oid, value)
+	}
+	if a.writes != nil {
+		return a.writes.do(write)
+	}
+	return write()
+}
+
+// undoValue calls the undo function of a managed object to revert a value
+// written by its setter, recovering from panics like setValue.
+func (a *Agent) undoValue(h *managedObject, value interface{}) {
+	undo := func() (err error) {
+		defer a.recoverHandler(h.oid, &err)
+		h.undoSet(h.oid, value)
+		return nil
+	}
+	if a.writes != nil {
+		a.writes.do(undo)
+	} else {
+		undo()
+	}
+}
+
+// recoverHandler logs a panic of a handler and replaces its error by a GenErr.
+func (a *Agent) recoverHandler(oid asn1.Oid, err *error) {
+	if r := recover(); r != nil {
+		a.logf(LogError, "handler of OID %s panicked: %v\n%s", oid, r,
+			debug.Stack())
+		*err = VarErrorf(GenErr, "handler of OID %s panicked", oid)
+	}
+}
+
+// pduVariables returns the variable bindings of a PDU, including SNMPv1
+// traps.
+func pduVariables(pdu interface{}) []Variable {
+	if trap, ok := pdu.(V1TrapPdu); ok {
+		return trap.Variables
+	}
+	variables, _ := Message{Pdu: pdu}.Variables()
+	return variables
+}
+
+// hasExceptions checks if any of the variables has an exception value.
+func hasExceptions(variables []Variable) bool {
+	for _, v := range variables {
+		switch v.Value.(type) {
+		case NoSuchObject, NoSuchInstance, EndOfMibView:
+			return true
+		}
+	}
+	return false
+}
+
+// settable checks if a value received in a SetRequest can be passed to a
+// Setter. Exceptions and NULL values are never valid in a SetRequest.
+func settable(value interface{}) bool {
+	_, null := value.(asn1.Null)
+	return supportedValue(value) && !null
+}
+
 // VarError is an error type that can be returned by a Getter or a Setter. When
 // VarError is returned, it Status is used in the SNMP response.
 type VarError struct {
@@ -358,6 +1548,23 @@ func (e VarError) Error() string {
 	return fmt.Sprintf("%s (status: %d)", e.Message, e.Status)
 }
 
+// ErrNoSuchInstance can be returned by a Getter when the requested instance
+// of a managed object doesn't exist. It's reported as NoSuchName in SNMPv1
+// and by a NoSuchInstance value in SNMPv2c.
+var ErrNoSuchInstance = errors.New("no such instance")
+
+// errorStatus returns the error status used in a response for an error
+// returned by a Getter or a Setter.
+func errorStatus(err error) int {
+	if e, ok := err.(VarError); ok {
+		return e.Status
+	}
+	if err == ErrNoSuchInstance {
+		return NoSuchName
+	}
+	return GenErr
+}
+
 // VarErrorf creates a new Error with a formatted message.
 func VarErrorf(status int, format string, values ...interface{}) VarError {
 	return VarError{
@@ -365,3 +1572,56 @@ func VarErrorf(status int, format string, values ...interface{}) VarError {
 		Message: fmt.Sprintf(format, values...),
 	}
 }
+
+// ErrorKind classifies the errors that prevent a message from being
+// answered.
+type ErrorKind int
+
+// Kinds of ProcessError.
+const (
+	// Drop is used for messages that should be silently discarded, like
+	// malformed messages or messages with an invalid community.
+	Drop ErrorKind = iota
+	// Unsupported is used for messages of versions or PDU types not handled
+	// by the agent.
+	Unsupported
+	// Internal is used when the agent fails to answer a valid message.
+	Internal
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case Drop:
+		return "Drop"
+	case Unsupported:
+		return "Unsupported"
+	case Internal:
+		return "Internal"
+	}
+	return fmt.Sprintf("ErrorKind(%d)", int(k))
+}
+
+// ProcessError is the error type returned by ProcessMessage and
+// ProcessDatagram. Its Kind can be used to decide how to report the failure.
+type ProcessError struct {
+	Kind    ErrorKind
+	Message string
+}
+
+var _ error = ProcessError{}
+
+func (e ProcessError) Error() string {
+	return e.Message
+}
+
+// ErrDropped is returned when a message is discarded on purpose. No response
+// should be sent and there is no need to log it.
+var ErrDropped = ProcessError{Kind: Drop, Message: "message dropped"}
+
+// processErrorf creates a new ProcessError with a formatted message.
+func processErrorf(kind ErrorKind, format string, values ...interface{}) ProcessError {
+	return ProcessError{
+		Kind:    kind,
+		Message: fmt.Sprintf(format, values...),
+	}
+}
@@ -1,8 +1,16 @@
 package snmp
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/PromonLogicalis/asn1"
 )
@@ -142,6 +150,31 @@ func TestCommunity(t *testing.T) {
 	}
 }
 
+func TestCheckCommunity(t *testing.T) {
+
+	agent := NewAgent()
+	agent.SetCommunities("public", "private")
+	tests := []struct {
+		community string
+		ok        bool
+		rw        bool
+	}{
+		{"public", true, false},
+		{"private", true, true},
+		{"publi", false, false},
+		{"publicc", false, false},
+		{"privatE", false, false},
+		{"", false, false},
+	}
+	for _, test := range tests {
+		views, err := agent.checkCommunity(&Message{Version: Version1,
+			Community: test.community}, &agent.snmp)
+		if rw := views.write != nil; (err == nil) != test.ok || rw != test.rw {
+			t.Fatalf("Community %q: got rw=%v err=%v\n", test.community, rw, err)
+		}
+	}
+}
+
 func TestString(t *testing.T) {
 	objs := []fmt.Stringer{
 		IPAddress{192, 168, 0, 1},
@@ -156,3 +189,1637 @@ func TestString(t *testing.T) {
 	}
 
 }
+
+func TestInvalidOid(t *testing.T) {
+	getter := func(oid asn1.Oid) (interface{}, error) {
+		return 0, nil
+	}
+	oids := []asn1.Oid{
+		{},
+		{1},
+		{3, 6, 1},
+		{1, 40, 1},
+	}
+	agent := NewAgent()
+	for _, oid := range oids {
+		if agent.AddRoManagedObject(oid, getter) == nil {
+			t.Fatalf("Registration of OID %v should fail.\n", oid)
+		}
+	}
+	if err := agent.AddRoManagedObject(asn1.Oid{2, 100, 3}, getter); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNoSuchInstance(t *testing.T) {
+
+	uptimeOid := asn1.Oid{1, 3, 6, 1, 2, 1, 1, 3, 0}
+	data := getResquestForTest()
+
+	agent := NewAgent()
+	agent.SetCommunities("publ", "priv")
+	agent.AddRoManagedObject(uptimeOid,
+		func(oid asn1.Oid) (interface{}, error) {
+			return nil, ErrNoSuchInstance
+		})
+	data, err := agent.ProcessDatagram(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := Message{}
+	_, err = Asn1Context().Decode(data, &message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, ok := message.Pdu.(GetResponsePdu)
+	if !ok {
+		t.Fatalf("Invalid PDU type: %T\n", message.Pdu)
+	}
+	if response.ErrorStatus != NoSuchName || response.ErrorIndex != 1 {
+		t.Fatalf(
+			"Response should contain error %d at index 1. Got %d at %d instead.\n",
+			NoSuchName, response.ErrorStatus, response.ErrorIndex)
+	}
+}
+
+func TestMaxWalkSteps(t *testing.T) {
+	agent := NewAgent()
+	oids := []asn1.Oid{
+		{1, 3, 6, 1, 4, 1, 1, 1, 0},
+		{1, 3, 6, 1, 4, 1, 1, 2, 0},
+		{1, 3, 6, 1, 4, 1, 1, 3, 0},
+	}
+	for _, oid := range oids {
+		agent.AddRoManagedObject(oid,
+			func(oid asn1.Oid) (interface{}, error) {
+				return 0, nil
+			})
+	}
+	agent.SetMaxWalkSteps(2)
+
+	oid := asn1.Oid{1, 3, 6, 1, 4, 1, 1}
+	request := &Message{
+		Community: "public",
+		Pdu: GetNextRequestPdu{
+			Variables: []Variable{
+				{oid, asn1.Null{}}, {oid, asn1.Null{}}, {oid, asn1.Null{}},
+			},
+		},
+	}
+	response, err := agent.ProcessMessage(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdu := response.Pdu.(GetResponsePdu)
+	if pdu.ErrorStatus != NoSuchName || pdu.ErrorIndex != 3 {
+		t.Fatalf(
+			"Response should contain error %d at index 3. Got %d at %d instead.\n",
+			NoSuchName, pdu.ErrorStatus, pdu.ErrorIndex)
+	}
+}
+
+func TestBinaryCommunity(t *testing.T) {
+
+	uptimeOid := asn1.Oid{1, 3, 6, 1, 2, 1, 1, 3, 0}
+	community := []byte{0x70, 0x00, 0xff}
+
+	agent := NewAgent()
+	agent.SetBinaryCommunities(community, []byte("priv"))
+	agent.AddRoManagedObject(uptimeOid,
+		func(oid asn1.Oid) (interface{}, error) {
+			return 0, nil
+		})
+
+	message := Message{
+		Community: string(community),
+		Pdu: GetRequestPdu{
+			Variables: []Variable{{uptimeOid, asn1.Null{}}},
+		},
+	}
+	data, err := Asn1Context().Encode(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = agent.ProcessDatagram(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message.Community = string(community[:2])
+	_, err = agent.ProcessMessage(&message)
+	if err == nil {
+		t.Fatal("Request with wrong Community should fail.")
+	}
+	if expected := "invalid community 0x7000"; err.Error() != expected {
+		t.Fatalf("Wrong error %q, expected %q\n", err, expected)
+	}
+
+	// Binary communities added to the others
+	other := []byte{0xc3, 0x28, 0x00}
+	agent.AddBinaryCommunity(other, "private")
+	for _, c := range [][]byte{community, other} {
+		message.Community = string(c)
+		if _, err = agent.ProcessMessage(&message); err != nil {
+			t.Fatalf("Community % x should be accepted: %s\n", c, err)
+		}
+	}
+}
+
+func TestSupportedVersions(t *testing.T) {
+	agent := NewAgent()
+	if versions := agent.SupportedVersions(); len(versions) != 1 ||
+		versions[0] != Version1 {
+		t.Fatalf("Wrong default versions: %v\n", versions)
+	}
+	if agent.SetSupportedVersions(Version1, 2) == nil {
+		t.Fatal("Unimplemented versions should be refused.")
+	}
+
+	request := &Message{
+		Version:   Version1,
+		Community: "public",
+		Pdu:       GetRequestPdu{},
+	}
+	_, err := agent.ProcessMessage(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = agent.SetSupportedVersions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = agent.ProcessMessage(request)
+	if err == nil {
+		t.Fatal("Request with unsupported version should fail.")
+	}
+}
+
+func TestVersion2c(t *testing.T) {
+
+	nameOid := asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0}
+	name := "name"
+	agent := NewAgent()
+	agent.AddRwManagedObject(nameOid,
+		func(oid asn1.Oid) (interface{}, error) {
+			return name, nil
+		},
+		func(oid asn1.Oid, value interface{}) error {
+			name = value.(string)
+			return nil
+		})
+	descrOid := asn1.Oid{1, 3, 6, 1, 2, 1, 1, 1, 0}
+	agent.AddRoManagedObject(descrOid,
+		func(oid asn1.Oid) (interface{}, error) {
+			return "descr", nil
+		})
+
+	process := func(community string, pdu interface{}) (GetResponsePdu, error) {
+		response, err := agent.ProcessMessage(&Message{
+			Version:   Version2c,
+			Community: community,
+			Pdu:       pdu,
+		})
+		if err != nil {
+			return GetResponsePdu{}, err
+		}
+		if response.Version != Version2c {
+			t.Fatalf("Wrong response version %d\n", response.Version)
+		}
+		return response.Pdu.(GetResponsePdu), nil
+	}
+	get := GetRequestPdu{Variables: []Variable{{nameOid, asn1.Null{}}}}
+	if _, err := process("public", get); err == nil {
+		t.Fatalf("SNMPv2c should not be accepted by default\n")
+	}
+	if err := agent.SetSupportedVersions(Version1, Version2c); err != nil {
+		t.Fatal(err)
+	}
+
+	pdu, err := process("public", get)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pdu.ErrorStatus != NoError || pdu.Variables[0].Value != "name" {
+		t.Fatalf("Wrong response %v\n", pdu)
+	}
+	pdu, err = process("private", SetRequestPdu{
+		Variables: []Variable{{nameOid, "other"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pdu.ErrorStatus != NoError || name != "other" {
+		t.Fatalf("Wrong response %v\n", pdu)
+	}
+
+	// SNMPv2 error statuses
+	tests := []struct {
+		community string
+		oid       asn1.Oid
+		status    int
+	}{
+		{"public", nameOid, NoAccess},
+		{"private", asn1.Oid{1, 3, 6, 1, 2, 1, 1, 9, 0}, NoCreation},
+		{"private", descrOid, NotWritable},
+	}
+	for _, e := range tests {
+		pdu, err = process(e.community, SetRequestPdu{
+			Variables: []Variable{{e.oid, "value"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pdu.ErrorStatus != e.status || pdu.ErrorIndex != 1 {
+			t.Fatalf("Response should contain error %d at index 1. Got %d at %d instead.\n",
+				e.status, pdu.ErrorStatus, pdu.ErrorIndex)
+		}
+	}
+}
+
+func TestVersion2cExceptions(t *testing.T) {
+
+	descrOid := asn1.Oid{1, 3, 6, 1, 2, 1, 1, 1, 0}
+	nameOid := asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0}
+	missingOid := asn1.Oid{1, 3, 6, 1, 2, 1, 1, 6, 0}
+	column := asn1.Oid{1, 3, 6, 1, 2, 1, 2, 2, 1, 2}
+	agent := NewAgent()
+	agent.SetSupportedVersions(Version2c)
+	agent.AddRoManagedObject(descrOid,
+		func(oid asn1.Oid) (interface{}, error) {
+			return "descr", nil
+		})
+	agent.AddRoManagedObject(nameOid,
+		func(oid asn1.Oid) (interface{}, error) {
+			return nil, ErrNoSuchInstance
+		})
+	agent.AddRoTableColumn(column,
+		func(oid asn1.Oid, index []int) (interface{}, error) {
+			return "eth0", nil
+		},
+		func() [][]int {
+			return [][]int{{1}}
+		})
+
+	process := func(pdu interface{}) GetResponsePdu {
+		response, err := agent.ProcessMessage(&Message{
+			Version:   Version2c,
+			Community: "public",
+			Pdu:       pdu,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := response.Pdu.(GetResponsePdu)
+		if res.ErrorStatus != NoError || res.ErrorIndex != 0 {
+			t.Fatalf("Response contains error %d at %d\n", res.ErrorStatus,
+				res.ErrorIndex)
+		}
+		return res
+	}
+
+	// Unknown objects don't stop the processing of the other variables
+	res := process(GetRequestPdu{
+		Variables: []Variable{
+			{descrOid, asn1.Null{}},
+			{missingOid, asn1.Null{}},
+			{descrOid, asn1.Null{}},
+		},
+	})
+	expected := []Variable{
+		{descrOid, "descr"},
+		{missingOid, NoSuchObject{}},
+		{descrOid, "descr"},
+	}
+	if len(res.Variables) != len(expected) {
+		t.Fatalf("Wrong variables %v\n", res.Variables)
+	}
+	for i, e := range expected {
+		v := res.Variables[i]
+		if v.Name.Cmp(e.Name) != 0 || v.Value != e.Value {
+			t.Fatalf("Wrong variable %d: %v instead of %v\n", i, v, e)
+		}
+	}
+
+	// Missing instances
+	for _, oid := range []asn1.Oid{
+		nameOid,
+		{1, 3, 6, 1, 2, 1, 1, 1, 1},
+		append(column, 2),
+	} {
+		res = process(GetRequestPdu{Variables: []Variable{{oid, asn1.Null{}}}})
+		if res.Variables[0].Value != (NoSuchInstance{}) {
+			t.Fatalf("Expected NoSuchInstance for %s, got %v\n", oid,
+				res.Variables[0].Value)
+		}
+	}
+
+	// End of the MIB
+	last := append(column, 1)
+	res = process(GetNextRequestPdu{Variables: []Variable{{last, asn1.Null{}}}})
+	if v := res.Variables[0]; v.Name.Cmp(last) != 0 || v.Value != (EndOfMibView{}) {
+		t.Fatalf("Expected EndOfMibView, got %v\n", v)
+	}
+}
+
+func TestEmptyAgentVersion2c(t *testing.T) {
+
+	oid := asn1.Oid{1, 3, 6, 1}
+	agent := NewAgent()
+	agent.SetSupportedVersions(Version2c)
+	for _, pdu := range []interface{}{
+		GetNextRequestPdu{
+			Variables: []Variable{{oid, asn1.Null{}}},
+		},
+		GetBulkRequestPdu{
+			MaxRepetitions: 5,
+			Variables:      []Variable{{oid, asn1.Null{}}},
+		},
+	} {
+		response, err := agent.ProcessMessage(&Message{
+			Version:   Version2c,
+			Community: "public",
+			Pdu:       pdu,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := response.Pdu.(GetResponsePdu)
+		if res.ErrorStatus != NoError || len(res.Variables) != 1 ||
+			res.Variables[0].Name.Cmp(oid) != 0 ||
+			res.Variables[0].Value != (EndOfMibView{}) {
+			t.Fatalf("%T: expected EndOfMibView, got %v\n", pdu, res)
+		}
+	}
+}
+
+func TestErrorKind(t *testing.T) {
+
+	uptimeOid := asn1.Oid{1, 3, 6, 1, 2, 1, 1, 3, 0}
+	agent := NewAgent()
+	agent.SetCommunities("publ", "priv")
+	agent.AddRoManagedObject(uptimeOid,
+		func(oid asn1.Oid) (interface{}, error) {
+			// Values of this type can't be encoded.
+			return 1.5, nil
+		})
+
+	checkKind := func(err error, kind ErrorKind) {
+		e, ok := err.(ProcessError)
+		if !ok {
+			t.Fatalf("Invalid error type %T: %v\n", err, err)
+		}
+		if e.Kind != kind {
+			t.Fatalf("Wrong error kind %s for %q, expected %s\n",
+				e.Kind, e, kind)
+		}
+	}
+
+	variables := []Variable{{uptimeOid, asn1.Null{}}}
+	_, err := agent.ProcessMessage(&Message{
+		Community: "wrong",
+		Pdu:       GetRequestPdu{Variables: variables},
+	})
+	checkKind(err, Drop)
+	_, err = agent.ProcessMessage(&Message{
+		Version:   Version3,
+		Community: "publ",
+		Pdu:       GetRequestPdu{Variables: variables},
+	})
+	checkKind(err, Unsupported)
+	_, err = agent.ProcessMessage(&Message{
+		Community: "publ",
+		Pdu:       V2TrapPdu{Variables: variables},
+	})
+	checkKind(err, Unsupported)
+	_, err = agent.ProcessDatagram([]byte{0x30, 0x03, 0x02})
+	checkKind(err, Drop)
+	_, err = agent.ProcessDatagram(getResquestForTest())
+	checkKind(err, Internal)
+}
+
+func TestSetException(t *testing.T) {
+
+	nameOid := asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0}
+	called := false
+	agent := NewAgent()
+	agent.SetSupportedVersions(Version1, Version2c)
+	agent.AddRwManagedObject(nameOid,
+		func(oid asn1.Oid) (interface{}, error) {
+			return "name", nil
+		},
+		func(oid asn1.Oid, value interface{}) error {
+			called = true
+			return nil
+		})
+
+	request := &Message{
+		Version:   Version2c,
+		Community: "private",
+		Pdu: SetRequestPdu{
+			Variables: []Variable{{nameOid, NoSuchObject{}}},
+		},
+	}
+	response, err := agent.ProcessMessage(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdu := response.Pdu.(GetResponsePdu)
+	if pdu.ErrorStatus != WrongType || pdu.ErrorIndex != 1 {
+		t.Fatalf(
+			"Response should contain error %d at index 1. Got %d at %d instead.\n",
+			WrongType, pdu.ErrorStatus, pdu.ErrorIndex)
+	}
+	if called {
+		t.Fatal("Setter should not be called with an exception value.")
+	}
+}
+
+func TestSetCounter(t *testing.T) {
+
+	counterOid := asn1.Oid{1, 3, 6, 1, 2, 1, 11, 1, 0}
+	called := false
+	agent := NewAgent()
+	agent.SetSupportedVersions(Version1, Version2c)
+	agent.AddRwManagedObject(counterOid,
+		func(oid asn1.Oid) (interface{}, error) {
+			return Counter32(10), nil
+		},
+		func(oid asn1.Oid, value interface{}) error {
+			called = true
+			return nil
+		})
+	if agent.SetObjectType(asn1.Oid{1, 3, 6, 1}, Counter32(0)) == nil {
+		t.Fatal("Declaring the type of an unknown OID should fail.")
+	}
+	err := agent.SetObjectType(counterOid, Counter32(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := &Message{
+		Version:   Version2c,
+		Community: "private",
+		Pdu: SetRequestPdu{
+			Variables: []Variable{{counterOid, Counter32(0)}},
+		},
+	}
+	response, err := agent.ProcessMessage(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdu := response.Pdu.(GetResponsePdu)
+	if pdu.ErrorStatus != NotWritable || pdu.ErrorIndex != 1 {
+		t.Fatalf(
+			"Response should contain error %d at index 1. Got %d at %d instead.\n",
+			NotWritable, pdu.ErrorStatus, pdu.ErrorIndex)
+	}
+	if called {
+		t.Fatal("Setter should not be called for a counter.")
+	}
+}
+
+func TestUnknownPduPolicy(t *testing.T) {
+	request := &Message{
+		Community: "public",
+		Pdu:       GetBulkRequestPdu{},
+	}
+	agent := NewAgent()
+	_, err := agent.ProcessMessage(request)
+	if e, ok := err.(ProcessError); !ok || e.Kind != Unsupported {
+		t.Fatalf("Unknown PDU should be unsupported: %v\n", err)
+	}
+	agent.SetUnknownPduPolicy(UnknownPduDrop)
+	_, err = agent.ProcessMessage(request)
+	if err != ErrDropped {
+		t.Fatalf("Unknown PDU should be dropped: %v\n", err)
+	}
+}
+
+func TestWriteAuthorizer(t *testing.T) {
+
+	allowed := asn1.Oid{1, 3, 6, 1, 4, 1, 1, 1, 0}
+	denied := asn1.Oid{1, 3, 6, 1, 4, 1, 1, 2, 1, 0}
+	values := map[string]interface{}{}
+	agent := NewAgent()
+	for _, oid := range []asn1.Oid{allowed, denied} {
+		agent.AddRwManagedObject(oid,
+			func(oid asn1.Oid) (interface{}, error) {
+				return values[oid.String()], nil
+			},
+			func(oid asn1.Oid, value interface{}) error {
+				values[oid.String()] = value
+				return nil
+			})
+	}
+	agent.SetWriteAuthorizer(func(community string, oid asn1.Oid) error {
+		prefix := asn1.Oid{1, 3, 6, 1, 4, 1, 1, 2}
+		if community == "private" && oid[:len(prefix)].Cmp(prefix) == 0 {
+			return fmt.Errorf("subtree %s is read-only", prefix)
+		}
+		return nil
+	})
+
+	agent.SetSupportedVersions(Version1, Version2c)
+	set := func(version int, oid asn1.Oid) GetResponsePdu {
+		request := &Message{
+			Version:   version,
+			Community: "private",
+			Pdu:       SetRequestPdu{Variables: []Variable{{oid, 1}}},
+		}
+		response, err := agent.ProcessMessage(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response.Pdu.(GetResponsePdu)
+	}
+	if pdu := set(Version1, allowed); pdu.ErrorStatus != NoError {
+		t.Fatalf("Response contains an error: %d\n", pdu.ErrorStatus)
+	}
+	// SNMPv1 has no noAccess status
+	tests := []struct {
+		version int
+		status  int
+	}{
+		{Version1, NoSuchName},
+		{Version2c, NoAccess},
+	}
+	for _, test := range tests {
+		pdu := set(test.version, denied)
+		if pdu.ErrorStatus != test.status || pdu.ErrorIndex != 1 {
+			t.Fatalf(
+				"Response should contain error %d at index 1. Got %d at %d instead.\n",
+				test.status, pdu.ErrorStatus, pdu.ErrorIndex)
+		}
+	}
+	if len(values) != 1 {
+		t.Fatalf("Denied write should not call the setter: %v\n", values)
+	}
+}
+
+func TestProcessMessageBytes(t *testing.T) {
+
+	uptimeOid := asn1.Oid{1, 3, 6, 1, 2, 1, 1, 3, 0}
+	data := getResquestForTest()
+
+	agent := NewAgent()
+	agent.SetCommunities("publ", "priv")
+	agent.AddRoManagedObject(uptimeOid,
+		func(oid asn1.Oid) (interface{}, error) {
+			return 123, nil
+		})
+	expected, err := agent.ProcessDatagram(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := Message{}
+	_, err = Asn1Context().Decode(data, &request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := agent.ProcessMessageBytes(&request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(response, expected) {
+		t.Fatalf("Wrong response %#v, expected %#v\n", response, expected)
+	}
+}
+
+func TestDeduplication(t *testing.T) {
+
+	uptimeOid := asn1.Oid{1, 3, 6, 1, 2, 1, 1, 3, 0}
+	calls := 0
+	agent := NewAgent()
+	agent.AddRoManagedObject(uptimeOid,
+		func(oid asn1.Oid) (interface{}, error) {
+			calls++
+			return calls, nil
+		})
+	agent.SetDeduplication(true)
+
+	variable := Variable{uptimeOid, asn1.Null{}}
+	request := &Message{
+		Community: "public",
+		Pdu: GetRequestPdu{
+			Variables: []Variable{variable, variable, variable},
+		},
+	}
+	response, err := agent.ProcessMessage(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdu := response.Pdu.(GetResponsePdu)
+	if calls != 1 {
+		t.Fatalf("Getter should be called once. Got %d calls.\n", calls)
+	}
+	if len(pdu.Variables) != 3 {
+		t.Fatalf("Wrong number of variables: %d\n", len(pdu.Variables))
+	}
+	for _, v := range pdu.Variables {
+		if v.Name.Cmp(uptimeOid) != 0 || v.Value != 1 {
+			t.Fatalf("Wrong variable %v\n", v)
+		}
+	}
+}
+
+func TestCommitFunc(t *testing.T) {
+
+	nameOid := asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0}
+	var commitErr, undoErr error
+	commits, undos := 0, 0
+	agent := NewAgent()
+	agent.SetSupportedVersions(Version1, Version2c)
+	agent.AddRwManagedObject(nameOid,
+		func(oid asn1.Oid) (interface{}, error) {
+			return "name", nil
+		},
+		func(oid asn1.Oid, value interface{}) error {
+			if value != "name" {
+				return VarErrorf(WrongValue, "invalid name")
+			}
+			return nil
+		})
+	agent.SetCommitFunc(
+		func(community string) error {
+			commits++
+			return commitErr
+		},
+		func(community string) error {
+			undos++
+			return undoErr
+		})
+
+	set := func(version int, value interface{}) GetResponsePdu {
+		request := &Message{
+			Version:   version,
+			Community: "private",
+			Pdu:       SetRequestPdu{Variables: []Variable{{nameOid, value}}},
+		}
+		response, err := agent.ProcessMessage(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response.Pdu.(GetResponsePdu)
+	}
+
+	tests := []struct {
+		value     string
+		commitErr error
+		undoErr   error
+		status    int
+		commits   int
+		undos     int
+	}{
+		{"name", nil, nil, NoError, 1, 0},
+		{"name", fmt.Errorf("commit"), nil, CommitFailed, 1, 1},
+		{"name", fmt.Errorf("commit"), fmt.Errorf("undo"), UndoFailed, 1, 1},
+		{"other", nil, nil, WrongValue, 0, 1},
+		{"other", nil, fmt.Errorf("undo"), UndoFailed, 0, 1},
+	}
+	for _, test := range tests {
+		commitErr, undoErr = test.commitErr, test.undoErr
+		commits, undos = 0, 0
+		pdu := set(Version2c, test.value)
+		if pdu.ErrorStatus != test.status {
+			t.Fatalf("Wrong status %d for %v, expected %d\n",
+				pdu.ErrorStatus, test, test.status)
+		}
+		if commits != test.commits || undos != test.undos {
+			t.Fatalf("Wrong calls for %v: %d commits and %d undos\n",
+				test, commits, undos)
+		}
+	}
+
+	// SNMPv1 has no commitFailed and undoFailed statuses
+	commitErr, undoErr = fmt.Errorf("commit"), nil
+	if pdu := set(Version1, "name"); pdu.ErrorStatus != GenErr {
+		t.Fatalf("Wrong status %d for a failed commit, expected %d\n",
+			pdu.ErrorStatus, GenErr)
+	}
+	commitErr, undoErr = nil, fmt.Errorf("undo")
+	if pdu := set(Version1, "other"); pdu.ErrorStatus != GenErr {
+		t.Fatalf("Wrong status %d for a failed undo, expected %d\n",
+			pdu.ErrorStatus, GenErr)
+	}
+}
+
+func TestRootOid(t *testing.T) {
+
+	agent := NewAgent()
+	before := asn1.Oid{1, 3, 6, 1, 4, 1, 8888, 1, 0}
+	agent.AddRoManagedObject(before, func(oid asn1.Oid) (interface{}, error) {
+		return "absolute", nil
+	})
+	agent.SetRootOid(asn1.Oid{1, 3, 6, 1, 4, 1, 9999})
+	if !agent.HasManagedObject(before) {
+		t.Fatalf("Object registered before the root OID not found\n")
+	}
+	if err := agent.SetObjectType(before, ""); err != nil {
+		t.Fatal(err)
+	}
+	err := agent.AddRoManagedObject(asn1.Oid{1, 0},
+		func(oid asn1.Oid) (interface{}, error) {
+			return "relative", nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		oid    asn1.Oid
+		status int
+	}{
+		{asn1.Oid{1, 3, 6, 1, 4, 1, 9999, 1, 0}, NoError},
+		{asn1.Oid{1, 0}, NoSuchName},
+		{asn1.Oid{1, 3, 6, 1, 4, 1, 7777, 1, 0}, NoSuchName},
+	}
+	for _, test := range tests {
+		response, err := agent.ProcessMessage(&Message{
+			Community: "public",
+			Pdu: GetRequestPdu{
+				Variables: []Variable{{test.oid, asn1.Null{}}},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pdu := response.Pdu.(GetResponsePdu)
+		if pdu.ErrorStatus != test.status {
+			t.Fatalf("Wrong error status %d for %s instead of %d\n",
+				pdu.ErrorStatus, test.oid, test.status)
+		}
+		if test.status == NoError && pdu.Variables[0].Value != "relative" {
+			t.Fatalf("Wrong value %v\n", pdu.Variables[0].Value)
+		}
+	}
+}
+
+func TestPanickingHandler(t *testing.T) {
+
+	panicOid := asn1.Oid{1, 3, 6, 1, 2, 1, 1, 1, 0}
+	nameOid := asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0}
+	agent := NewAgent()
+	agent.AddRwManagedObject(panicOid,
+		func(oid asn1.Oid) (interface{}, error) {
+			panic("getter")
+		},
+		func(oid asn1.Oid, value interface{}) error {
+			panic("setter")
+		})
+	agent.AddRoManagedObject(nameOid,
+		func(oid asn1.Oid) (interface{}, error) {
+			return "name", nil
+		})
+
+	requests := []*Message{
+		{
+			Community: "public",
+			Pdu: GetRequestPdu{
+				Variables: []Variable{{nameOid, asn1.Null{}}, {panicOid, asn1.Null{}}},
+			},
+		},
+		{
+			Community: "private",
+			Pdu: SetRequestPdu{
+				Variables: []Variable{{panicOid, "value"}},
+			},
+		},
+	}
+	for _, request := range requests {
+		response, err := agent.ProcessMessage(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pdu := response.Pdu.(GetResponsePdu)
+		index := len(pduVariables(request.Pdu))
+		if pdu.ErrorStatus != GenErr || pdu.ErrorIndex != index {
+			t.Fatalf("Response should contain error %d at index %d. Got %d at %d instead.\n",
+				GenErr, index, pdu.ErrorStatus, pdu.ErrorIndex)
+		}
+	}
+
+	// The agent keeps working
+	response, err := agent.ProcessMessage(&Message{
+		Community: "public",
+		Pdu: GetRequestPdu{
+			Variables: []Variable{{nameOid, asn1.Null{}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdu := response.Pdu.(GetResponsePdu)
+	if pdu.ErrorStatus != NoError || pdu.Variables[0].Value != "name" {
+		t.Fatalf("Wrong response %#v\n", pdu)
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+
+	var buf bytes.Buffer
+	agent := NewAgent()
+	agent.SetLogger(log.New(&buf, "", 0))
+	agent.SetLogLevel(LogError)
+	agent.AddRoManagedObject(asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0},
+		func(oid asn1.Oid) (interface{}, error) {
+			return "name", nil
+		})
+
+	request := &Message{
+		Community: "public",
+		Pdu: GetRequestPdu{
+			Variables: []Variable{
+				{asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0}, asn1.Null{}},
+			},
+		},
+	}
+	if _, err := agent.ProcessMessage(request); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() > 0 {
+		t.Fatalf("Successful requests should not be logged: %s\n", buf.String())
+	}
+
+	request.Community = "invalid"
+	if _, err := agent.ProcessMessage(request); err == nil {
+		t.Fatalf("Invalid community should fail\n")
+	}
+	if !strings.Contains(buf.String(), "invalid community") {
+		t.Fatalf("Failure should be logged: %q\n", buf.String())
+	}
+
+	buf.Reset()
+	agent.SetLogLevel(LogOff)
+	agent.ProcessMessage(request)
+	if buf.Len() > 0 {
+		t.Fatalf("Nothing should be logged: %s\n", buf.String())
+	}
+}
+
+func TestProcessDatagramContext(t *testing.T) {
+
+	// The context is done while getting the second variable
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	agent := NewAgent()
+	var variables []Variable
+	for i := 1; i <= 5; i++ {
+		oid := asn1.Oid{1, 3, 6, 1, 4, 1, 9999, uint(i), 0}
+		i := i
+		agent.AddRoManagedObject(oid,
+			func(oid asn1.Oid) (interface{}, error) {
+				if i == 2 {
+					cancel()
+				}
+				return 1, nil
+			})
+		variables = append(variables, Variable{oid, asn1.Null{}})
+	}
+	data, err := EncodeMessage(&Message{
+		Community: "public",
+		Pdu:       GetRequestPdu{Variables: variables},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err = agent.ProcessDatagramContext(ctx, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := DecodeMessage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdu := response.Pdu.(GetResponsePdu)
+	if pdu.ErrorStatus != GenErr || pdu.ErrorIndex != 3 {
+		t.Fatalf("Response should contain a GenErr at the third variable. Got %d at %d instead.\n",
+			pdu.ErrorStatus, pdu.ErrorIndex)
+	}
+}
+
+func TestGetNextWithoutInstance(t *testing.T) {
+
+	nameOid := asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0}
+	agent := NewAgent()
+	agent.AddRoManagedObject(nameOid,
+		func(oid asn1.Oid) (interface{}, error) {
+			return "name", nil
+		})
+
+	response, err := agent.ProcessMessage(&Message{
+		Community: "public",
+		Pdu: GetNextRequestPdu{
+			Variables: []Variable{
+				{asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5}, asn1.Null{}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdu := response.Pdu.(GetResponsePdu)
+	if pdu.ErrorStatus != NoError {
+		t.Fatalf("Response contains an error: %d\n", pdu.ErrorStatus)
+	}
+	if pdu.Variables[0].Name.Cmp(nameOid) != 0 || pdu.Variables[0].Value != "name" {
+		t.Fatalf("Wrong variable %v instead of %s\n", pdu.Variables[0], nameOid)
+	}
+}
+
+func TestEmptyAgent(t *testing.T) {
+
+	agent := NewAgent()
+	for _, pdu := range []interface{}{
+		GetRequestPdu{
+			Variables: []Variable{{asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0}, asn1.Null{}}},
+		},
+		GetNextRequestPdu{
+			Variables: []Variable{{asn1.Oid{0, 0}, asn1.Null{}}},
+		},
+	} {
+		response, err := agent.ProcessMessage(&Message{
+			Community: "public",
+			Pdu:       pdu,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := response.Pdu.(GetResponsePdu)
+		if res.ErrorStatus != NoSuchName || res.ErrorIndex != 1 {
+			t.Fatalf("%T: expected error %d at index 1, got %d at %d\n", pdu,
+				NoSuchName, res.ErrorStatus, res.ErrorIndex)
+		}
+	}
+}
+
+func TestHasManagedObject(t *testing.T) {
+
+	agent := NewAgent()
+	for _, n := range []uint{3, 1, 2} {
+		agent.AddRoManagedObject(asn1.Oid{1, 3, 6, 1, 4, 1, 9999, n, 0},
+			func(oid asn1.Oid) (interface{}, error) {
+				return 1, nil
+			})
+	}
+	for _, n := range []uint{1, 2, 3} {
+		if !agent.HasManagedObject(asn1.Oid{1, 3, 6, 1, 4, 1, 9999, n, 0}) {
+			t.Fatalf("OID %d should be registered\n", n)
+		}
+	}
+	for _, oid := range []asn1.Oid{
+		{1, 3, 6, 1, 4, 1, 9999, 4, 0},
+		{1, 3, 6, 1, 4, 1, 9999, 1},
+		{1, 3, 6, 1, 4, 1, 9999, 1, 0, 1},
+	} {
+		if agent.HasManagedObject(oid) {
+			t.Fatalf("OID %s should not be registered\n", oid)
+		}
+	}
+}
+
+func TestAddManagedObjectsBatch(t *testing.T) {
+
+	getter := func(oid asn1.Oid) (interface{}, error) {
+		return 1, nil
+	}
+	agent := NewAgent()
+	errs := agent.AddManagedObjectsBatch([]ManagedObjectSpec{
+		{Oid: asn1.Oid{1, 3, 6, 1, 4, 1, 9999, 1, 0}, Getter: getter},
+		{Oid: asn1.Oid{1, 3, 6, 1, 4, 1, 9999, 2, 0}, Getter: getter},
+		{Oid: asn1.Oid{1, 3, 6, 1, 4, 1, 9999, 1, 0}, Getter: getter},
+	})
+	if len(errs) != 3 || errs[0] != nil || errs[1] != nil || errs[2] == nil {
+		t.Fatalf("Only the duplicated OID should fail: %v\n", errs)
+	}
+	if len(agent.handlers) > 0 {
+		t.Fatalf("No managed object should be registered\n")
+	}
+
+	errs = agent.AddManagedObjectsBatch([]ManagedObjectSpec{
+		{Oid: asn1.Oid{1, 3, 6, 1, 4, 1, 9999, 1, 0}, Getter: getter},
+		{Oid: asn1.Oid{1, 3, 6, 1, 4, 1, 9999, 2, 0}, Getter: getter},
+	})
+	if errs != nil {
+		t.Fatalf("Batch should succeed: %v\n", errs)
+	}
+	if len(agent.handlers) != 2 {
+		t.Fatalf("Wrong number of managed objects: %d\n", len(agent.handlers))
+	}
+}
+
+func TestPreProcessHook(t *testing.T) {
+
+	agent := NewAgent()
+	agent.SetCommunities("publ", "priv")
+	agent.AddRoManagedObject(asn1.Oid{1, 3, 6, 1, 2, 1, 1, 3, 0},
+		func(oid asn1.Oid) (interface{}, error) {
+			return 123, nil
+		})
+	src := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 161}
+	var seen net.Addr
+	agent.SetPreProcessHook(func(raw []byte, src net.Addr) error {
+		seen = src
+		if bytes.Contains(raw, []byte("publ")) {
+			return fmt.Errorf("community in clear text")
+		}
+		return nil
+	})
+
+	_, err := agent.ProcessDatagramFrom(getResquestForTest(), src)
+	if e, ok := err.(ProcessError); !ok || e.Kind != Drop {
+		t.Fatalf("Datagram should be dropped: %v\n", err)
+	}
+	if seen != src {
+		t.Fatalf("Wrong source %v\n", seen)
+	}
+
+	agent.SetPreProcessHook(func(raw []byte, src net.Addr) error {
+		return nil
+	})
+	if _, err := agent.ProcessDatagramFrom(getResquestForTest(), src); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetUnsignedValue(t *testing.T) {
+
+	counterOid := asn1.Oid{1, 3, 6, 1, 4, 1, 9999, 1, 0}
+	gaugeOid := asn1.Oid{1, 3, 6, 1, 4, 1, 9999, 2, 0}
+	undeclaredOid := asn1.Oid{1, 3, 6, 1, 4, 1, 9999, 3, 0}
+	agent := NewAgent()
+	for _, oid := range []asn1.Oid{counterOid, gaugeOid, undeclaredOid} {
+		agent.AddRoManagedObject(oid,
+			func(oid asn1.Oid) (interface{}, error) {
+				return uint32(42), nil
+			})
+	}
+	agent.SetObjectType(counterOid, Counter32(0))
+	agent.SetObjectType(gaugeOid, Unsigned32(0))
+
+	get := func(oid asn1.Oid) GetResponsePdu {
+		response, err := agent.ProcessMessage(&Message{
+			Community: "public",
+			Pdu: GetRequestPdu{
+				Variables: []Variable{{oid, asn1.Null{}}},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response.Pdu.(GetResponsePdu)
+	}
+	if pdu := get(counterOid); pdu.ErrorStatus != NoError ||
+		pdu.Variables[0].Value != Counter32(42) {
+		t.Fatalf("Wrong response %v\n", pdu)
+	}
+	if pdu := get(gaugeOid); pdu.ErrorStatus != NoError ||
+		pdu.Variables[0].Value != Unsigned32(42) {
+		t.Fatalf("Wrong response %v\n", pdu)
+	}
+	if pdu := get(undeclaredOid); pdu.ErrorStatus != GenErr {
+		t.Fatalf("Response should contain error %d. Got %d instead.\n",
+			GenErr, pdu.ErrorStatus)
+	}
+}
+
+func TestSetDeclaredType(t *testing.T) {
+
+	addrOid := asn1.Oid{1, 3, 6, 1, 4, 1, 9999, 1, 0}
+	var addr interface{}
+	agent := NewAgent()
+	agent.SetSupportedVersions(Version1, Version2c)
+	agent.AddRwManagedObject(addrOid,
+		func(oid asn1.Oid) (interface{}, error) {
+			return addr, nil
+		},
+		func(oid asn1.Oid, value interface{}) error {
+			addr = value
+			return nil
+		})
+	if err := agent.SetObjectType(addrOid, IPAddress{}); err != nil {
+		t.Fatal(err)
+	}
+
+	setVersion := func(version int, value interface{}) GetResponsePdu {
+		response, err := agent.ProcessMessage(&Message{
+			Version:   version,
+			Community: "private",
+			Pdu: SetRequestPdu{
+				Variables: []Variable{{addrOid, value}},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response.Pdu.(GetResponsePdu)
+	}
+	set := func(value interface{}) GetResponsePdu {
+		return setVersion(Version2c, value)
+	}
+	if pdu := set(10); pdu.ErrorStatus != WrongType || pdu.ErrorIndex != 1 {
+		t.Fatalf("Response should contain error %d at index 1. Got %d at %d instead.\n",
+			WrongType, pdu.ErrorStatus, pdu.ErrorIndex)
+	}
+	// SNMPv1 has no wrongType status
+	if pdu := setVersion(Version1, 10); pdu.ErrorStatus != BadValue || pdu.ErrorIndex != 1 {
+		t.Fatalf("Response should contain error %d at index 1. Got %d at %d instead.\n",
+			BadValue, pdu.ErrorStatus, pdu.ErrorIndex)
+	}
+	if addr != nil {
+		t.Fatalf("The setter should not be called\n")
+	}
+	if pdu := set(IPAddress{10, 0, 0, 1}); pdu.ErrorStatus != NoError {
+		t.Fatalf("Response contains an error: %d\n", pdu.ErrorStatus)
+	}
+
+	// IP addresses in dotted notation are converted
+	if pdu := set("192.168.1.2"); pdu.ErrorStatus != NoError {
+		t.Fatalf("Response contains an error: %d\n", pdu.ErrorStatus)
+	}
+	if addr != (IPAddress{192, 168, 1, 2}) {
+		t.Fatalf("Wrong address %#v\n", addr)
+	}
+	if pdu := set("192.168.1"); pdu.ErrorStatus != WrongType {
+		t.Fatalf("Invalid address should fail with %d. Got %d instead.\n",
+			WrongType, pdu.ErrorStatus)
+	}
+}
+
+func TestResponseCommunity(t *testing.T) {
+
+	agent := NewAgent()
+	agent.SetCommunities("front", "private")
+	agent.AddRoManagedObject(asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0},
+		func(oid asn1.Oid) (interface{}, error) {
+			return "name", nil
+		})
+	agent.SetResponseCommunity(func(request string) string {
+		return "back-" + request
+	})
+
+	response, err := agent.ProcessMessage(&Message{
+		Community: "front",
+		Pdu: GetRequestPdu{
+			Variables: []Variable{
+				{asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0}, asn1.Null{}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Community != "back-front" {
+		t.Fatalf("Wrong response community %q\n", response.Community)
+	}
+}
+
+func TestProcessDatagramEncoding(t *testing.T) {
+
+	agent := NewAgent()
+	agent.SetCommunities("publ", "priv")
+	agent.AddRoManagedObject(asn1.Oid{1, 3, 6, 1, 2, 1, 1, 3, 0},
+		func(oid asn1.Oid) (interface{}, error) {
+			return 123, nil
+		})
+	data, err := agent.ProcessDatagram(getResquestForTest())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A new context produces the same response
+	request, err := DecodeMessage(getResquestForTest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := agent.ProcessMessage(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := Asn1Context().Encode(*response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, expected) {
+		t.Fatalf("Wrong encoding %x instead of %x\n", data, expected)
+	}
+}
+
+func BenchmarkAsn1Context(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		Asn1Context()
+	}
+}
+
+func BenchmarkProcessDatagram(b *testing.B) {
+	agent := NewAgent()
+	agent.SetCommunities("publ", "priv")
+	agent.AddRoManagedObject(asn1.Oid{1, 3, 6, 1, 2, 1, 1, 3, 0},
+		func(oid asn1.Oid) (interface{}, error) {
+			return 123, nil
+		})
+	data := getResquestForTest()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := agent.ProcessDatagram(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestAllowTrailingBytes(t *testing.T) {
+
+	agent := NewAgent()
+	agent.SetCommunities("publ", "priv")
+	agent.AddRoManagedObject(asn1.Oid{1, 3, 6, 1, 2, 1, 1, 3, 0},
+		func(oid asn1.Oid) (interface{}, error) {
+			return 123, nil
+		})
+	data := append(getResquestForTest(), 0, 0, 0, 0)
+
+	if _, err := agent.ProcessDatagram(data); err == nil {
+		t.Fatalf("Datagram with trailing bytes should be discarded\n")
+	}
+	agent.SetAllowTrailingBytes(true)
+	if _, err := agent.ProcessDatagram(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecodeErrorLogged(t *testing.T) {
+
+	var buf bytes.Buffer
+	agent := NewAgent()
+	agent.SetLogger(log.New(&buf, "", 0))
+	agent.SetLogLevel(LogError)
+
+	if _, err := agent.ProcessDatagram([]byte{0x30, 0x03, 0x02}); err == nil {
+		t.Fatalf("Invalid datagram should fail\n")
+	}
+	if !strings.Contains(buf.String(), "invalid message") {
+		t.Fatalf("Decode error should be logged: %q\n", buf.String())
+	}
+}
+
+func TestEndOfMibHandler(t *testing.T) {
+
+	nameOid := asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0}
+	extraOid := asn1.Oid{1, 3, 6, 1, 4, 1, 9999, 1, 0}
+	agent := NewAgent()
+	agent.AddRoManagedObject(nameOid,
+		func(oid asn1.Oid) (interface{}, error) {
+			return "name", nil
+		})
+	agent.SetEndOfMibHandler(func(lastOid asn1.Oid) (Variable, bool) {
+		if lastOid.Cmp(extraOid) < 0 {
+			return Variable{extraOid, "extra"}, true
+		}
+		return Variable{}, false
+	})
+
+	next := func(oid asn1.Oid) GetResponsePdu {
+		response, err := agent.ProcessMessage(&Message{
+			Community: "public",
+			Pdu: GetNextRequestPdu{
+				Variables: []Variable{{oid, asn1.Null{}}},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response.Pdu.(GetResponsePdu)
+	}
+	pdu := next(nameOid)
+	if pdu.ErrorStatus != NoError || pdu.Variables[0].Name.Cmp(extraOid) != 0 ||
+		pdu.Variables[0].Value != "extra" {
+		t.Fatalf("Wrong response %v\n", pdu)
+	}
+	if pdu := next(extraOid); pdu.ErrorStatus != NoSuchName {
+		t.Fatalf("Walk should end with NoSuchName. Got %d instead.\n",
+			pdu.ErrorStatus)
+	}
+}
+
+func TestCommunityNotRequired(t *testing.T) {
+
+	nameOid := asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0}
+	name := "name"
+	agent := NewAgent()
+	agent.AddRwManagedObject(nameOid,
+		func(oid asn1.Oid) (interface{}, error) {
+			return name, nil
+		},
+		func(oid asn1.Oid, value interface{}) error {
+			name = value.(string)
+			return nil
+		})
+	agent.SetCommunityRequired(false)
+
+	set := func() GetResponsePdu {
+		response, err := agent.ProcessMessage(&Message{
+			Community: "anything",
+			Pdu: SetRequestPdu{
+				Variables: []Variable{{nameOid, "new"}},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response.Pdu.(GetResponsePdu)
+	}
+	if pdu := set(); pdu.ErrorStatus != NoError || name != "new" {
+		t.Fatalf("SET should be accepted. Got error %d.\n", pdu.ErrorStatus)
+	}
+
+	agent.SetTrustedAccess(AccessReadOnly)
+	if pdu := set(); pdu.ErrorStatus != NoSuchName {
+		t.Fatalf("SET should be rejected with %d. Got %d instead.\n",
+			NoSuchName, pdu.ErrorStatus)
+	}
+
+	agent.SetCommunityRequired(true)
+	if _, err := agent.ProcessMessage(&Message{
+		Community: "anything",
+		Pdu:       GetRequestPdu{Variables: []Variable{{nameOid, asn1.Null{}}}},
+	}); err == nil {
+		t.Fatalf("Invalid community should fail\n")
+	}
+}
+
+func TestGetPreservesRequestOrder(t *testing.T) {
+
+	agent := NewAgent()
+	for i := 1; i <= 4; i++ {
+		value := i
+		agent.AddRoManagedObject(asn1.Oid{1, 3, 6, 1, 4, 1, 9999, uint(i), 0},
+			func(oid asn1.Oid) (interface{}, error) {
+				return value, nil
+			})
+	}
+
+	order := []int{3, 1, 4, 2}
+	var variables []Variable
+	for _, i := range order {
+		variables = append(variables,
+			Variable{asn1.Oid{1, 3, 6, 1, 4, 1, 9999, uint(i), 0}, asn1.Null{}})
+	}
+	response, err := agent.ProcessMessage(&Message{
+		Community: "public",
+		Pdu:       GetRequestPdu{Variables: variables},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdu := response.Pdu.(GetResponsePdu)
+	if pdu.ErrorStatus != NoError || len(pdu.Variables) != len(order) {
+		t.Fatalf("Wrong response %v\n", pdu)
+	}
+	for j, i := range order {
+		v := pdu.Variables[j]
+		if v.Name.Cmp(variables[j].Name) != 0 || v.Value != i {
+			t.Fatalf("Wrong variable %v at position %d\n", v, j)
+		}
+	}
+}
+
+func TestHandleDatagram(t *testing.T) {
+
+	agent := NewAgent()
+	agent.SetCommunities("publ", "priv")
+	agent.AddRoManagedObject(asn1.Oid{1, 3, 6, 1, 2, 1, 1, 3, 0},
+		func(oid asn1.Oid) (interface{}, error) {
+			return 123, nil
+		})
+
+	trap, err := EncodePdu(Version1, "publ", V1TrapPdu{
+		Enterprise: asn1.Oid{1, 3, 6, 1, 4, 1, 9999},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, respond, err := agent.HandleDatagram(trap)
+	if err != nil || respond || data != nil {
+		t.Fatalf("Trap should be handled without response: %v %t\n", err, respond)
+	}
+
+	data, respond, err = agent.HandleDatagram(getResquestForTest())
+	if err != nil || !respond {
+		t.Fatalf("GET should be answered: %v %t\n", err, respond)
+	}
+	if _, err := DecodeMessage(data); err != nil {
+		t.Fatal(err)
+	}
+
+	_, respond, err = agent.HandleDatagram([]byte{0x30, 0x03, 0x02})
+	if err == nil || respond {
+		t.Fatalf("Invalid datagram should fail: %v %t\n", err, respond)
+	}
+}
+
+func TestInteger32Range(t *testing.T) {
+
+	bigOid := asn1.Oid{1, 3, 6, 1, 4, 1, 9999, 1, 0}
+	value := int64(math.MaxInt32)
+	agent := NewAgent()
+	agent.AddRoManagedObject(bigOid,
+		func(oid asn1.Oid) (interface{}, error) {
+			return int(value), nil
+		})
+
+	get := func() GetResponsePdu {
+		response, err := agent.ProcessMessage(&Message{
+			Community: "public",
+			Pdu:       GetRequestPdu{Variables: []Variable{{bigOid, asn1.Null{}}}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response.Pdu.(GetResponsePdu)
+	}
+	if pdu := get(); pdu.ErrorStatus != NoError {
+		t.Fatalf("Response contains an error: %d\n", pdu.ErrorStatus)
+	}
+	if strconv.IntSize == 64 {
+		value++
+		if pdu := get(); pdu.ErrorStatus != GenErr || pdu.ErrorIndex != 1 {
+			t.Fatalf("Response should contain error %d at index 1. Got %d at %d instead.\n",
+				GenErr, pdu.ErrorStatus, pdu.ErrorIndex)
+		}
+	}
+}
+
+func TestRequestGetter(t *testing.T) {
+
+	nameOid := asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0}
+	var seen asn1.Oid
+	agent := NewAgent()
+	agent.AddRoRequestManagedObject(nameOid,
+		func(oid, requested asn1.Oid) (interface{}, error) {
+			seen = requested
+			return oid.String(), nil
+		})
+
+	requested := asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5}
+	response, err := agent.ProcessMessage(&Message{
+		Community: "public",
+		Pdu:       GetNextRequestPdu{Variables: []Variable{{requested, asn1.Null{}}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdu := response.Pdu.(GetResponsePdu)
+	if pdu.ErrorStatus != NoError || pdu.Variables[0].Value != nameOid.String() {
+		t.Fatalf("Wrong response %v\n", pdu)
+	}
+	if seen.Cmp(requested) != 0 {
+		t.Fatalf("Getter received %s instead of %s\n", seen, requested)
+	}
+}
+
+func TestResponseDelay(t *testing.T) {
+
+	agent := NewAgent()
+	agent.SetCommunities("publ", "priv")
+	agent.AddRoManagedObject(asn1.Oid{1, 3, 6, 1, 2, 1, 1, 3, 0},
+		func(oid asn1.Oid) (interface{}, error) {
+			return 123, nil
+		})
+	min, max := 20*time.Millisecond, 40*time.Millisecond
+	agent.SetResponseDelay(min, max)
+
+	var delays []time.Duration
+	wait = func(ctx context.Context, d time.Duration) {
+		delays = append(delays, d)
+	}
+	defer func() { wait = waitContext }()
+	for i := 0; i < 100; i++ {
+		if _, err := agent.ProcessDatagram(getResquestForTest()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(delays) != 100 {
+		t.Fatalf("Expected 100 delays, got %d\n", len(delays))
+	}
+	for _, d := range delays {
+		if d < min || d >= max {
+			t.Fatalf("Delay %s out of bounds\n", d)
+		}
+	}
+
+	// The delay is bounded by the context
+	wait = waitContext
+	agent.SetResponseDelay(time.Hour, time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := agent.ProcessDatagramContext(ctx, getResquestForTest()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetWithValue(t *testing.T) {
+
+	var buf bytes.Buffer
+	nameOid := asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0}
+	agent := NewAgent()
+	agent.SetLogger(log.New(&buf, "", 0))
+	agent.SetLogLevel(LogInfo)
+	agent.AddRoManagedObject(nameOid,
+		func(oid asn1.Oid) (interface{}, error) {
+			return "name", nil
+		})
+
+	response, err := agent.ProcessMessage(&Message{
+		Community: "public",
+		Pdu:       GetRequestPdu{Variables: []Variable{{nameOid, 10}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdu := response.Pdu.(GetResponsePdu)
+	if pdu.ErrorStatus != NoError || pdu.Variables[0].Value != "name" {
+		t.Fatalf("Wrong response %v\n", pdu)
+	}
+	if !strings.Contains(buf.String(), "ignoring int value") {
+		t.Fatalf("Value should be logged: %q\n", buf.String())
+	}
+}
+
+func TestNilValuePolicy(t *testing.T) {
+
+	nilOid := asn1.Oid{1, 3, 6, 1, 4, 1, 9999, 1, 0}
+	agent := NewAgent()
+	agent.AddRoManagedObject(nilOid,
+		func(oid asn1.Oid) (interface{}, error) {
+			return nil, nil
+		})
+
+	get := func() GetResponsePdu {
+		response, err := agent.ProcessMessage(&Message{
+			Community: "public",
+			Pdu:       GetRequestPdu{Variables: []Variable{{nilOid, asn1.Null{}}}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response.Pdu.(GetResponsePdu)
+	}
+	if pdu := get(); pdu.ErrorStatus != NoSuchName || pdu.ErrorIndex != 1 {
+		t.Fatalf("Response should contain error %d at index 1. Got %d at %d instead.\n",
+			NoSuchName, pdu.ErrorStatus, pdu.ErrorIndex)
+	}
+	agent.SetNilValuePolicy(NilValueGenErr)
+	if pdu := get(); pdu.ErrorStatus != GenErr || pdu.ErrorIndex != 1 {
+		t.Fatalf("Response should contain error %d at index 1. Got %d at %d instead.\n",
+			GenErr, pdu.ErrorStatus, pdu.ErrorIndex)
+	}
+}
+
+func TestAddRoManagedObjectAliases(t *testing.T) {
+
+	aliases := []asn1.Oid{
+		{1, 3, 6, 1, 4, 1, 9999, 3, 0},
+		{1, 3, 6, 1, 4, 1, 9999, 1, 0},
+		{1, 3, 6, 1, 4, 1, 9999, 2, 0},
+	}
+	agent := NewAgent()
+	err := agent.AddRoManagedObjectAliases(aliases,
+		func(oid asn1.Oid) (interface{}, error) {
+			return "shared", nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var variables []Variable
+	for _, oid := range aliases {
+		variables = append(variables, Variable{oid, asn1.Null{}})
+	}
+	response, err := agent.ProcessMessage(&Message{
+		Community: "public",
+		Pdu:       GetRequestPdu{Variables: variables},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdu := response.Pdu.(GetResponsePdu)
+	if pdu.ErrorStatus != NoError || len(pdu.Variables) != 3 {
+		t.Fatalf("Wrong response %v\n", pdu)
+	}
+	for i, v := range pdu.Variables {
+		if v.Name.Cmp(aliases[i]) != 0 || v.Value != "shared" {
+			t.Fatalf("Wrong variable %v\n", v)
+		}
+	}
+
+	err = agent.AddRoManagedObjectAliases([]asn1.Oid{
+		{1, 3, 6, 1, 4, 1, 9999, 4, 0},
+		{1, 3, 6, 1, 4, 1, 9999, 1, 0},
+	}, func(oid asn1.Oid) (interface{}, error) {
+		return "other", nil
+	})
+	if err == nil || len(agent.handlers) != 3 {
+		t.Fatalf("Registration with a duplicated OID should fail: %v\n", err)
+	}
+}
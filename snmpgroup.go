@@ -0,0 +1,128 @@
+package snmp
+
+import (
+	"sync/atomic"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+// snmpCounters are the counters of the snmp group, kept for the datagrams
+// processed by the agent, along with the value of snmpEnableAuthenTraps.
+type snmpCounters struct {
+	inPkts              uint32
+	inBadVersions       uint32
+	inBadCommunityNames uint32
+	inBadCommunityUses  uint32
+	inASNParseErrs      uint32
+	silentDrops         uint32
+	proxyDrops          uint32 // Always 0, the agent doesn't proxy
+	unknownContexts     uint32
+	// authenTraps is 1 when authenticationFailure traps are enabled
+	authenTraps uint32
+}
+
+// Values of snmpEnableAuthenTraps (RFC 3418).
+const (
+	authenTrapsEnabled  = 1
+	authenTrapsDisabled = 2
+)
+
+// inc increments one of the counters and returns its new value.
+func (c *snmpCounters) inc(counter *uint32) uint32 {
+	return atomic.AddUint32(counter, 1)
+}
+
+// getter returns a Getter for one of the counters.
+func (c *snmpCounters) getter(counter *uint32) Getter {
+	return func(oid asn1.Oid) (interface{}, error) {
+		return Counter32(atomic.LoadUint32(counter)), nil
+	}
+}
+
+// authenTrapsGetter is the Getter of snmpEnableAuthenTraps.
+func (c *snmpCounters) authenTrapsGetter(oid asn1.Oid) (interface{}, error) {
+	if atomic.LoadUint32(&c.authenTraps) == authenTrapsEnabled {
+		return authenTrapsEnabled, nil
+	}
+	return authenTrapsDisabled, nil
+}
+
+// authenTrapsSetter is the Setter of snmpEnableAuthenTraps.
+func (c *snmpCounters) authenTrapsSetter(oid asn1.Oid, value interface{}) error {
+	v, ok := value.(int)
+	if !ok {
+		return VarErrorf(WrongType, "invalid type %T for %s", value, oid)
+	}
+	if v != authenTrapsEnabled && v != authenTrapsDisabled {
+		return VarErrorf(WrongValue, "invalid value %d for %s", v, oid)
+	}
+	atomic.StoreUint32(&c.authenTraps, uint32(v))
+	return nil
+}
+
+// AuthenTrapsEnabled tells whether authenticationFailure traps were enabled
+// through snmpEnableAuthenTraps, which is disabled until set by a manager.
+// Applications sending these traps should check it first.
+func (a *Agent) AuthenTrapsEnabled() bool {
+	return atomic.LoadUint32(&a.snmp.authenTraps) == authenTrapsEnabled
+}
+
+// RegisterSnmpGroup registers the counters of the snmp group (1.3.6.1.2.1.11)
+// defined by RFC 3418, with its snmpEnableAuthenTraps object, of the usmStats
+// group (1.3.6.1.6.3.15.1.1) defined by RFC 3414 and the snmpUnknownContexts
+// counter of RFC 3413. The counters are always maintained by the agent, and
+// only exposed once registered. Nothing is registered when an error is
+// returned.
+func (a *Agent) RegisterSnmpGroup() (err error) {
+	handlers := make([]managedObject, len(a.handlers))
+	copy(handlers, a.handlers)
+	defer func() {
+		if err != nil {
+			a.handlers = handlers
+		}
+	}()
+
+	snmp := asn1.Oid{1, 3, 6, 1, 2, 1, 11}
+	counters := []struct {
+		id      uint
+		counter *uint32
+	}{
+		{1, &a.snmp.inPkts},
+		{3, &a.snmp.inBadVersions},
+		{4, &a.snmp.inBadCommunityNames},
+		{5, &a.snmp.inBadCommunityUses},
+		{6, &a.snmp.inASNParseErrs},
+		{31, &a.snmp.silentDrops},
+		{32, &a.snmp.proxyDrops},
+	}
+	for _, c := range counters {
+		err = a.addStandardObject(append(append(asn1.Oid{}, snmp...), c.id, 0),
+			a.snmp.getter(c.counter), nil)
+		if err != nil {
+			return err
+		}
+	}
+	err = a.addStandardObject(append(append(asn1.Oid{}, snmp...), 30, 0),
+		a.snmp.authenTrapsGetter, a.snmp.authenTrapsSetter)
+	if err != nil {
+		return err
+	}
+	err = a.addStandardObject(snmpUnknownContextsOid,
+		a.snmp.getter(&a.snmp.unknownContexts), nil)
+	if err != nil {
+		return err
+	}
+	for stat := usmStatsUnsupportedSecLevels; stat <= usmStatsDecryptionErrors; stat++ {
+		stat := stat
+		err = a.addStandardObject(usmError{stat: stat}.variable().Name,
+			func(oid asn1.Oid) (interface{}, error) {
+				a.usm.Lock()
+				defer a.usm.Unlock()
+				return a.usm.stats[stat], nil
+			}, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package snmp
+
+import (
+	"testing"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+func TestSnmpGroup(t *testing.T) {
+
+	agent := NewAgent()
+	if err := agent.RegisterSnmpGroup(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A valid request, an invalid community and an invalid message
+	agent.SetCommunities("publ", "priv")
+	if _, err := agent.ProcessDatagram(getResquestForTest()); err != nil {
+		t.Fatal(err)
+	}
+	agent.SetCommunities("public", "private")
+	agent.ProcessDatagram(getResquestForTest())
+	agent.ProcessDatagram([]byte{0x30, 0x03, 0x02, 0x01})
+
+	expected := []struct {
+		oid   asn1.Oid
+		value Counter32
+	}{
+		{asn1.Oid{1, 3, 6, 1, 2, 1, 11, 1, 0}, 3},
+		{asn1.Oid{1, 3, 6, 1, 2, 1, 11, 3, 0}, 0},
+		{asn1.Oid{1, 3, 6, 1, 2, 1, 11, 4, 0}, 1},
+		{asn1.Oid{1, 3, 6, 1, 2, 1, 11, 6, 0}, 1},
+		{asn1.Oid{1, 3, 6, 1, 2, 1, 11, 31, 0}, 0},
+		{asn1.Oid{1, 3, 6, 1, 2, 1, 11, 32, 0}, 0},
+		{asn1.Oid{1, 3, 6, 1, 6, 3, 15, 1, 1, 3, 0}, 0},
+	}
+	for _, e := range expected {
+		request := &Message{
+			Community: "public",
+			Pdu: GetRequestPdu{
+				Variables: []Variable{{e.oid, asn1.Null{}}},
+			},
+		}
+		response, err := agent.ProcessMessage(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pdu := response.Pdu.(GetResponsePdu)
+		if pdu.ErrorStatus != NoError {
+			t.Fatalf("Response contains an error: %d\n", pdu.ErrorStatus)
+		}
+		if v, ok := pdu.Variables[0].Value.(Counter32); !ok || v != e.value {
+			t.Fatalf("Wrong value %v for %s\n", pdu.Variables[0].Value, e.oid)
+		}
+	}
+}
+
+func TestSnmpGroupUsmStats(t *testing.T) {
+
+	user := newUsmUserForTest("user", AuthSHA, NoPriv)
+	agent := newV3AgentForTest(t, user)
+	if err := agent.RegisterSnmpGroup(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A request of an unknown user is reported
+	unknown := newUsmUserForTest("unknown", AuthSHA, NoPriv)
+	agent.ProcessDatagram(getV3ForTest(t, agent, unknown))
+
+	agent.SetSupportedVersions(Version1, Version3)
+	agent.SetCommunities("public", "private")
+	request := &Message{
+		Community: "public",
+		Pdu: GetRequestPdu{
+			Variables: []Variable{
+				{asn1.Oid{1, 3, 6, 1, 6, 3, 15, 1, 1, 3, 0}, asn1.Null{}},
+			},
+		},
+	}
+	response, err := agent.ProcessMessage(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdu := response.Pdu.(GetResponsePdu)
+	if v, ok := pdu.Variables[0].Value.(Counter32); !ok || v != 1 {
+		t.Fatalf("Wrong usmStatsUnknownUserNames %v\n", pdu.Variables[0].Value)
+	}
+}
+
+// getSnmpObjectForTest returns the value of a scalar with a SNMPv2c request.
+func getSnmpObjectForTest(t *testing.T, agent *Agent, oid asn1.Oid) interface{} {
+	response, err := agent.ProcessMessage(&Message{
+		Version:   Version2c,
+		Community: "public",
+		Pdu: GetRequestPdu{
+			Variables: []Variable{{oid, asn1.Null{}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response.Pdu.(GetResponsePdu).Variables[0].Value
+}
+
+func TestSnmpGroupValidate(t *testing.T) {
+
+	agent := NewAgent()
+	agent.SetSupportedVersions(Version1, Version2c)
+	if err := agent.RegisterSnmpGroup(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Dry runs don't count invalid communities nor messages
+	agent.SetCommunities("publ", "priv")
+	if _, ok := agent.ValidateDatagram(getResquestForTest()).(ValidationError); !ok {
+		t.Fatal("Only the variables of the request should be invalid.")
+	}
+	agent.SetCommunities("public", "private")
+	if err := agent.ValidateDatagram(getResquestForTest()); err == nil {
+		t.Fatal("Invalid communities should be refused.")
+	}
+	if err := agent.ValidateDatagram([]byte{0x30, 0x03, 0x02, 0x01}); err == nil {
+		t.Fatal("Invalid messages should be refused.")
+	}
+	for _, id := range []uint{1, 4, 6} {
+		oid := asn1.Oid{1, 3, 6, 1, 2, 1, 11, id, 0}
+		if v := getSnmpObjectForTest(t, agent, oid); v != Counter32(0) {
+			t.Fatalf("Expected 0 for %s, got %v\n", oid, v)
+		}
+	}
+}
+
+func TestSnmpSilentDrops(t *testing.T) {
+
+	agent := NewAgent()
+	agent.SetSupportedVersions(Version1, Version2c)
+	agent.SetCommunities("public", "private")
+	if err := agent.RegisterSnmpGroup(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Not even the tooBig response fits
+	agent.SetMaxResponseSize(10)
+	_, err := agent.ProcessMessage(&Message{
+		Version:   Version2c,
+		Community: "public",
+		Pdu: GetRequestPdu{
+			Variables: []Variable{{asn1.Oid{1, 3, 6, 1, 2, 1, 11, 1, 0}, asn1.Null{}}},
+		},
+	})
+	if e, ok := err.(ProcessError); !ok || e.Kind != Drop {
+		t.Fatalf("Expected the response to be dropped, got %v\n", err)
+	}
+	agent.SetMaxResponseSize(0)
+	oid := asn1.Oid{1, 3, 6, 1, 2, 1, 11, 31, 0}
+	if v := getSnmpObjectForTest(t, agent, oid); v != Counter32(1) {
+		t.Fatalf("Expected 1 silent drop, got %v\n", v)
+	}
+}
+
+func TestSnmpEnableAuthenTraps(t *testing.T) {
+
+	agent := NewAgent()
+	agent.SetSupportedVersions(Version1, Version2c)
+	agent.SetCommunities("public", "private")
+	if err := agent.RegisterSnmpGroup(); err != nil {
+		t.Fatal(err)
+	}
+	oid := asn1.Oid{1, 3, 6, 1, 2, 1, 11, 30, 0}
+	if v := getSnmpObjectForTest(t, agent, oid); v != 2 || agent.AuthenTrapsEnabled() {
+		t.Fatalf("Expected disabled authentication traps, got %v\n", v)
+	}
+
+	tests := []struct {
+		value  interface{}
+		status int
+	}{
+		{1, NoError},
+		{3, WrongValue},
+		{"enabled", WrongType},
+	}
+	for _, test := range tests {
+		response, err := agent.ProcessMessage(&Message{
+			Version:   Version2c,
+			Community: "private",
+			Pdu: SetRequestPdu{
+				Variables: []Variable{{oid, test.value}},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status := response.Pdu.(GetResponsePdu).ErrorStatus; status != test.status {
+			t.Fatalf("Expected status %d for %v, got %d\n", test.status,
+				test.value, status)
+		}
+	}
+	if v := getSnmpObjectForTest(t, agent, oid); v != 1 || !agent.AuthenTrapsEnabled() {
+		t.Fatalf("Expected enabled authentication traps, got %v\n", v)
+	}
+}
+
+func TestRegisterSnmpGroupAtomic(t *testing.T) {
+
+	agent := NewAgent()
+	agent.AddRoManagedObject(asn1.Oid{1, 3, 6, 1, 2, 1, 11, 30, 0},
+		func(oid asn1.Oid) (interface{}, error) {
+			return 1, nil
+		})
+	if err := agent.RegisterSnmpGroup(); err == nil {
+		t.Fatal("Registered objects should not be replaced.")
+	}
+	if agent.HasManagedObject(asn1.Oid{1, 3, 6, 1, 2, 1, 11, 1, 0}) {
+		t.Fatal("Counters registered before the error should be removed.")
+	}
+}
@@ -0,0 +1,135 @@
+// Package snmptest provides utilities for testing SNMP agents without
+// network sockets.
+//
+// Requests are described by their version, community, PDU type and
+// variables, encoded and handled by an agent as a datagram. Responses are
+// decoded into a Response:
+//
+//	res, err := snmptest.Do(agent, snmptest.Request{
+//		Community: "public",
+//		Type:      snmptest.Get,
+//		Oids:      []string{"1.3.6.1.2.1.1.5.0"},
+//	})
+package snmptest
+
+import (
+	"fmt"
+
+	"github.com/PromonLogicalis/asn1"
+	"github.com/PromonLogicalis/snmp"
+)
+
+// PduType identifies the type of a request PDU.
+type PduType int
+
+// Request PDU types.
+const (
+	Get PduType = iota
+	GetNext
+	Set
+)
+
+// Request describes a SNMP request.
+type Request struct {
+	// Version is the message version, like snmp.Version1.
+	Version   int
+	Community string
+	Type      PduType
+	// Identifier identifies the request. Responses carry the same value.
+	Identifier int
+	// Oids are the OIDs in dotted notation of the requested variables. They
+	// are sent with NULL values and are appended after Variables.
+	Oids []string
+	// Variables are sent as is, mostly useful for Set requests.
+	Variables []snmp.Variable
+}
+
+// Response holds the decoded fields of a GetResponse message.
+type Response struct {
+	Version     int
+	Community   string
+	Identifier  int
+	ErrorStatus int
+	ErrorIndex  int
+	Variables   []snmp.Variable
+}
+
+// Value returns the value of the variable with the given OID in dotted
+// notation, if present.
+func (r *Response) Value(oid string) (interface{}, bool) {
+	parsed, err := snmp.ParseOid(oid)
+	if err != nil {
+		return nil, false
+	}
+	for _, v := range r.Variables {
+		if v.Name.Cmp(parsed) == 0 {
+			return v.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Err returns the error status of the response as an error, or nil if the
+// request succeeded.
+func (r *Response) Err() error {
+	return snmp.StatusError(r.ErrorStatus)
+}
+
+// NewDatagram returns the encoded message of a request.
+func NewDatagram(req Request) ([]byte, error) {
+	variables := append([]snmp.Variable(nil), req.Variables...)
+	for _, s := range req.Oids {
+		oid, err := snmp.ParseOid(s)
+		if err != nil {
+			return nil, err
+		}
+		variables = append(variables, snmp.Variable{Name: oid, Value: asn1.Null{}})
+	}
+	pdu := snmp.Pdu{Identifier: req.Identifier, Variables: variables}
+
+	message := &snmp.Message{Version: req.Version, Community: req.Community}
+	switch req.Type {
+	case Get:
+		message.Pdu = snmp.GetRequestPdu(pdu)
+	case GetNext:
+		message.Pdu = snmp.GetNextRequestPdu(pdu)
+	case Set:
+		message.Pdu = snmp.SetRequestPdu(pdu)
+	default:
+		return nil, fmt.Errorf("invalid PDU type %d", req.Type)
+	}
+	return snmp.EncodeMessage(message)
+}
+
+// ParseResponse decodes a GetResponse message.
+func ParseResponse(data []byte) (*Response, error) {
+	message, err := snmp.DecodeMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	pdu, ok := message.Pdu.(snmp.GetResponsePdu)
+	if !ok {
+		return nil, fmt.Errorf("invalid PDU type %T", message.Pdu)
+	}
+	return &Response{
+		Version:     message.Version,
+		Community:   message.Community,
+		Identifier:  pdu.Identifier,
+		ErrorStatus: pdu.ErrorStatus,
+		ErrorIndex:  pdu.ErrorIndex,
+		Variables:   pdu.Variables,
+	}, nil
+}
+
+// Do encodes a request, handles it with the agent and decodes the response.
+func Do(agent *snmp.Agent, req Request) (*Response, error) {
+	data, err := NewDatagram(req)
+	if err != nil {
+		return nil, err
+	}
+	data, err = agent.ProcessDatagram(data)
+	if err != nil {
+		return nil, err
+	}
+	return ParseResponse(data)
+}
@@ -0,0 +1,59 @@
+package snmptest
+
+import (
+	"testing"
+
+	"github.com/PromonLogicalis/asn1"
+	"github.com/PromonLogicalis/snmp"
+)
+
+func TestDo(t *testing.T) {
+
+	agent := snmp.NewAgent()
+	agent.SetCommunities("publ", "priv")
+	agent.AddRoManagedObject(asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0},
+		func(oid asn1.Oid) (interface{}, error) {
+			return "example", nil
+		})
+
+	res, err := Do(agent, Request{
+		Community:  "publ",
+		Type:       Get,
+		Identifier: 42,
+		Oids:       []string{"1.3.6.1.2.1.1.5.0"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Err() != nil {
+		t.Fatalf("Response contains an error: %s\n", res.Err())
+	}
+	if res.Identifier != 42 || res.Community != "publ" {
+		t.Fatalf("Wrong response header: %#v\n", res)
+	}
+	value, ok := res.Value("1.3.6.1.2.1.1.5.0")
+	if !ok || value != "example" {
+		t.Fatalf("Wrong value %v\n", value)
+	}
+
+	res, err = Do(agent, Request{
+		Community: "publ",
+		Type:      Get,
+		Oids:      []string{"1.3.6.1.2.1.1.6.0"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ErrorStatus != snmp.NoSuchName || res.ErrorIndex != 1 {
+		t.Fatalf("Wrong error %d at %d\n", res.ErrorStatus, res.ErrorIndex)
+	}
+}
+
+func TestNewDatagram(t *testing.T) {
+	if _, err := NewDatagram(Request{Type: PduType(99)}); err == nil {
+		t.Fatalf("Invalid PDU type should fail\n")
+	}
+	if _, err := NewDatagram(Request{Oids: []string{"1.x"}}); err == nil {
+		t.Fatalf("Invalid OID should fail\n")
+	}
+}
@@ -0,0 +1,71 @@
+package snmp
+
+import (
+	"fmt"
+)
+
+var statusNames = []string{
+	NoError:             "noError",
+	TooBig:              "tooBig",
+	NoSuchName:          "noSuchName",
+	BadValue:            "badValue",
+	ReadOnly:            "readOnly",
+	GenErr:              "genErr",
+	NoAccess:            "noAccess",
+	WrongType:           "wrongType",
+	WrongLength:         "wrongLength",
+	WrongEncoding:       "wrongEncoding",
+	WrongValue:          "wrongValue",
+	NoCreation:          "noCreation",
+	InconsistentValue:   "inconsistentValue",
+	ResourceUnavailable: "resourceUnavailable",
+	CommitFailed:        "commitFailed",
+	UndoFailed:          "undoFailed",
+	AuthorizationError:  "authorizationError",
+	NotWritable:         "notWritable",
+	InconsistentName:    "inconsistentName",
+}
+
+// v1ErrorStatus maps an error status to the ones defined by SNMPv1, as
+// required for the responses to SNMPv1 requests (RFC 2576, section 4.3).
+func v1ErrorStatus(status int) int {
+	switch status {
+	case WrongValue, WrongEncoding, WrongType, WrongLength, InconsistentValue:
+		return BadValue
+	case NoAccess, NotWritable, NoCreation, InconsistentName, AuthorizationError:
+		return NoSuchName
+	case ResourceUnavailable, CommitFailed, UndoFailed:
+		return GenErr
+	}
+	return status
+}
+
+// ErrorStatus is an error for the error status of a response PDU.
+type ErrorStatus int
+
+// String returns the name of the error status as defined in RFC 3416,
+// followed by its code. Example: "noSuchName(2)".
+func (e ErrorStatus) String() string {
+	return fmt.Sprintf("%s(%d)", statusName(int(e)), int(e))
+}
+
+// statusName returns the name of an error status, or "unknown".
+func statusName(status int) string {
+	if status >= 0 && status < len(statusNames) {
+		return statusNames[status]
+	}
+	return "unknown"
+}
+
+func (e ErrorStatus) Error() string {
+	return e.String()
+}
+
+// StatusError converts the error status of a response PDU into an error of
+// type ErrorStatus. It returns nil for NoError.
+func StatusError(status int) error {
+	if status == NoError {
+		return nil
+	}
+	return ErrorStatus(status)
+}
@@ -0,0 +1,35 @@
+package snmp
+
+import (
+	"testing"
+)
+
+func TestStatusError(t *testing.T) {
+	if err := StatusError(NoError); err != nil {
+		t.Fatalf("NoError should not be an error: %v\n", err)
+	}
+	tests := []struct {
+		status int
+		str    string
+	}{
+		{TooBig, "tooBig(1)"},
+		{NoSuchName, "noSuchName(2)"},
+		{GenErr, "genErr(5)"},
+		{NotWritable, "notWritable(17)"},
+		{InconsistentName, "inconsistentName(18)"},
+		{99, "unknown(99)"},
+	}
+	for _, test := range tests {
+		err := StatusError(test.status)
+		status, ok := err.(ErrorStatus)
+		if !ok {
+			t.Fatalf("Wrong error type %T\n", err)
+		}
+		if int(status) != test.status {
+			t.Fatalf("Wrong status %d instead of %d\n", status, test.status)
+		}
+		if err.Error() != test.str {
+			t.Fatalf("Wrong string %q instead of %q\n", err.Error(), test.str)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package snmp
+
+import (
+	"sync"
+	"time"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+// sysServicesDefault is the value of sysServices for a host offering
+// application services (layers 4 and 7).
+const sysServicesDefault = 72
+
+// systemGroup holds the writable values of the system group.
+type systemGroup struct {
+	sync.Mutex
+	contact  string
+	name     string
+	location string
+}
+
+// RegisterSystemGroup registers the managed objects of the system group
+// (1.3.6.1.2.1.1) defined by RFC 3418. sysContact, sysName and sysLocation
+// are writable and their current values are kept by the agent. sysUpTime is
+// counted from since. The OIDs of the group ignore the root OID of the
+// agent. Nothing is registered when an error is returned.
+func (a *Agent) RegisterSystemGroup(descr string, objectID asn1.Oid,
+	contact, name, location string, since time.Time) (err error) {
+
+	handlers := make([]managedObject, len(a.handlers))
+	copy(handlers, a.handlers)
+	defer func() {
+		if err != nil {
+			a.handlers = handlers
+		}
+	}()
+
+	group := &systemGroup{contact: contact, name: name, location: location}
+	err = a.addStandardObject(asn1.Oid{1, 3, 6, 1, 2, 1, 1, 1, 0},
+		func(oid asn1.Oid) (interface{}, error) {
+			return descr, nil
+		}, nil)
+	if err != nil {
+		return err
+	}
+	err = a.addStandardObject(asn1.Oid{1, 3, 6, 1, 2, 1, 1, 2, 0},
+		func(oid asn1.Oid) (interface{}, error) {
+			return objectID, nil
+		}, nil)
+	if err != nil {
+		return err
+	}
+	err = a.addStandardObject(asn1.Oid{1, 3, 6, 1, 2, 1, 1, 3, 0},
+		func(oid asn1.Oid) (interface{}, error) {
+			return TimeTicks(timeNow().Sub(since) / (10 * time.Millisecond)), nil
+		}, nil)
+	if err != nil {
+		return err
+	}
+	err = a.addStandardObject(asn1.Oid{1, 3, 6, 1, 2, 1, 1, 4, 0},
+		group.getter(&group.contact), group.setter(&group.contact))
+	if err != nil {
+		return err
+	}
+	err = a.addStandardObject(asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0},
+		group.getter(&group.name), group.setter(&group.name))
+	if err != nil {
+		return err
+	}
+	err = a.addStandardObject(asn1.Oid{1, 3, 6, 1, 2, 1, 1, 6, 0},
+		group.getter(&group.location), group.setter(&group.location))
+	if err != nil {
+		return err
+	}
+	return a.addStandardObject(asn1.Oid{1, 3, 6, 1, 2, 1, 1, 7, 0},
+		func(oid asn1.Oid) (interface{}, error) {
+			return sysServicesDefault, nil
+		}, nil)
+}
+
+// getter returns a Getter for one of the group values.
+func (g *systemGroup) getter(value *string) Getter {
+	return func(oid asn1.Oid) (interface{}, error) {
+		g.Lock()
+		defer g.Unlock()
+		return *value, nil
+	}
+}
+
+// setter returns a Setter for one of the group values. Values are
+// DisplayStrings limited to 255 characters.
+func (g *systemGroup) setter(value *string) Setter {
+	return func(oid asn1.Oid, v interface{}) error {
+		str, ok := v.(string)
+		if !ok {
+			return VarErrorf(WrongType, "invalid type %T for %s", v, oid)
+		}
+		if len(str) > 255 {
+			return VarErrorf(WrongLength, "value for %s is too long", oid)
+		}
+		g.Lock()
+		defer g.Unlock()
+		*value = str
+		return nil
+	}
+}
@@ -0,0 +1,102 @@
+package snmp
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+func TestSystemGroup(t *testing.T) {
+
+	clock := time.Now()
+	timeNow = func() time.Time { return clock }
+	defer func() { timeNow = time.Now }()
+
+	objectID := asn1.Oid{1, 3, 6, 1, 4, 1, 12345}
+	agent := NewAgent()
+	err := agent.RegisterSystemGroup("descr", objectID, "contact", "name",
+		"location", clock.Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Walk the whole group
+	expected := []Variable{
+		{asn1.Oid{1, 3, 6, 1, 2, 1, 1, 1, 0}, "descr"},
+		{asn1.Oid{1, 3, 6, 1, 2, 1, 1, 2, 0}, objectID},
+		{asn1.Oid{1, 3, 6, 1, 2, 1, 1, 3, 0}, TimeTicks(100)},
+		{asn1.Oid{1, 3, 6, 1, 2, 1, 1, 4, 0}, "contact"},
+		{asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0}, "name"},
+		{asn1.Oid{1, 3, 6, 1, 2, 1, 1, 6, 0}, "location"},
+		{asn1.Oid{1, 3, 6, 1, 2, 1, 1, 7, 0}, 72},
+	}
+	oid := asn1.Oid{1, 3, 6, 1, 2, 1, 1}
+	for _, e := range expected {
+		request := &Message{
+			Community: "public",
+			Pdu: GetNextRequestPdu{
+				Variables: []Variable{{oid, asn1.Null{}}},
+			},
+		}
+		response, err := agent.ProcessMessage(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pdu := response.Pdu.(GetResponsePdu)
+		if pdu.ErrorStatus != NoError {
+			t.Fatalf("Response contains an error: %d\n", pdu.ErrorStatus)
+		}
+		v := pdu.Variables[0]
+		if v.Name.Cmp(e.Name) != 0 {
+			t.Fatalf("Unexpected OID %s\n", v.Name)
+		}
+		if reflect.TypeOf(v.Value) != reflect.TypeOf(e.Value) {
+			t.Fatalf("Wrong type %T for %s\n", v.Value, v.Name)
+		}
+		if !reflect.DeepEqual(v.Value, e.Value) {
+			t.Fatalf("Wrong value %v for %s\n", v.Value, v.Name)
+		}
+		oid = v.Name
+	}
+
+	// Update sysName
+	sysName := asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0}
+	request := &Message{
+		Community: "private",
+		Pdu: SetRequestPdu{
+			Variables: []Variable{{sysName, "other"}},
+		},
+	}
+	_, err = agent.ProcessMessage(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Community = "public"
+	request.Pdu = GetRequestPdu{Variables: []Variable{{sysName, asn1.Null{}}}}
+	response, err := agent.ProcessMessage(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := response.Pdu.(GetResponsePdu).Variables[0].Value; value != "other" {
+		t.Fatalf("Wrong sysName %v\n", value)
+	}
+}
+
+func TestRegisterSystemGroupAtomic(t *testing.T) {
+
+	agent := NewAgent()
+	agent.AddRoManagedObject(asn1.Oid{1, 3, 6, 1, 2, 1, 1, 6, 0},
+		func(oid asn1.Oid) (interface{}, error) {
+			return "location", nil
+		})
+	err := agent.RegisterSystemGroup("descr", asn1.Oid{1, 3, 6, 1, 4, 1, 12345},
+		"contact", "name", "location", time.Now())
+	if err == nil {
+		t.Fatal("Registered objects should not be replaced.")
+	}
+	if agent.HasManagedObject(asn1.Oid{1, 3, 6, 1, 2, 1, 1, 1, 0}) {
+		t.Fatal("Objects registered before the error should be removed.")
+	}
+}
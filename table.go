@@ -0,0 +1,334 @@
+package snmp
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+// ColumnGetter is a function called to return the value of a table column
+// instance. The index contains the sub-identifiers that follow the column OID.
+type ColumnGetter func(oid asn1.Oid, index []int) (interface{}, error)
+
+// Indexer is a function called to enumerate the current indexes of a table
+// column. Indexes must be returned in ascending order.
+type Indexer func() [][]int
+
+// AddRoTableColumn registers a read-only table column. Its instances are the
+// column OID followed by each index returned by indexer. Requests for any
+// instance are handled by getter.
+func (a *Agent) AddRoTableColumn(columnOid asn1.Oid, getter ColumnGetter,
+	indexer Indexer) error {
+
+	if getter == nil || indexer == nil {
+		return fmt.Errorf("a table column should have a getter and an indexer")
+	}
+	return a.addManagedObject(managedObject{
+		oid:      append(asn1.Oid{}, columnOid...),
+		get:      a.columnGetter(columnOid, getter),
+		set:      notWritable,
+		readOnly: true,
+		indexer:  indexer,
+	})
+}
+
+// columnGetter adapts a ColumnGetter to the Getter of a column registered
+// below the root OID of the agent.
+func (a *Agent) columnGetter(columnOid asn1.Oid, getter ColumnGetter) Getter {
+	length := len(a.root) + len(columnOid)
+	return func(oid asn1.Oid) (interface{}, error) {
+		return getter(oid, oidToIndex(oid[length:]))
+	}
+}
+
+// RowStatus values, as defined by the RowStatus textual convention of
+// RFC 2579.
+const (
+	RowActive        = 1
+	RowNotInService  = 2
+	RowNotReady      = 3
+	RowCreateAndGo   = 4
+	RowCreateAndWait = 5
+	RowDestroy       = 6
+)
+
+// RowCreator is a function called to create a table row. The status is either
+// RowCreateAndGo or RowCreateAndWait.
+type RowCreator func(index []int, status int) error
+
+// RowActivator is a function called to change the status of an existing
+// table row to RowActive or RowNotInService. It should return an
+// InconsistentValue VarError when the row can't make the transition, for
+// example to activate a row whose columns are not all set.
+type RowActivator func(index []int, status int) error
+
+// RowDestroyer is a function called to remove a table row.
+type RowDestroyer func(index []int) error
+
+// AddRowStatusColumn registers the RowStatus column of a table. Its value is
+// returned by getter for the rows enumerated by indexer. Unlike other
+// columns, SETs are accepted for instances of rows that don't exist: a SET of
+// createAndGo or createAndWait calls create and a SET of destroy calls
+// destroy. After that the indexers of the table should reflect the change.
+// SETs of active or notInService to existing rows in another status call
+// activate, which may be nil for tables whose rows can't change their status.
+// Other transitions fail with InconsistentValue.
+func (a *Agent) AddRowStatusColumn(columnOid asn1.Oid, getter ColumnGetter,
+	indexer Indexer, create RowCreator, activate RowActivator,
+	destroy RowDestroyer) error {
+
+	if getter == nil || indexer == nil {
+		return fmt.Errorf("a table column should have a getter and an indexer")
+	}
+	if create == nil || destroy == nil {
+		return fmt.Errorf("a RowStatus column should have create and destroy functions")
+	}
+	length := len(a.root) + len(columnOid)
+	return a.addManagedObject(managedObject{
+		oid: append(asn1.Oid{}, columnOid...),
+		get: a.columnGetter(columnOid, getter),
+		set: func(oid asn1.Oid, value interface{}) error {
+			index := oidToIndex(oid[length:])
+			status, ok := value.(int)
+			if !ok {
+				return VarErrorf(WrongType, "invalid RowStatus type %T", value)
+			}
+			exists := hasIndex(indexer, index)
+			switch status {
+			case RowCreateAndGo, RowCreateAndWait:
+				if exists {
+					return VarErrorf(InconsistentValue, "row %v already exists", index)
+				}
+				return create(index, status)
+			case RowDestroy:
+				// Destroying a missing row is not an error
+				if !exists {
+					return nil
+				}
+				return destroy(index)
+			case RowActive, RowNotInService:
+				if !exists {
+					return VarErrorf(InconsistentValue, "row %v doesn't exist", index)
+				}
+				current, err := getter(oid, index)
+				if err != nil {
+					return err
+				}
+				if current == status {
+					return nil
+				}
+				if activate == nil {
+					return VarErrorf(InconsistentValue,
+						"row %v can't change its status to %d", index, status)
+				}
+				return activate(index, status)
+			}
+			return VarErrorf(WrongValue, "unsupported RowStatus %d", status)
+		},
+		indexer:   indexer,
+		rowStatus: true,
+		rowKey:    a.columnRowKey(columnOid),
+	})
+}
+
+// ColumnSetter is a function called to set the value of a table column
+// instance. The index contains the sub-identifiers that follow the column OID.
+type ColumnSetter func(oid asn1.Oid, index []int, value interface{}) error
+
+// AddRcTableColumn registers a read-create table column. Its instances can
+// only be SET in the same request that creates their row through the
+// RowStatus column of the table or while the row is notInService or
+// notReady, otherwise NotWritable is returned. The RowStatus column should be
+// registered with AddRowStatusColumn, with an OID sharing the table entry
+// prefix.
+func (a *Agent) AddRcTableColumn(columnOid asn1.Oid, getter ColumnGetter,
+	setter ColumnSetter, indexer Indexer) error {
+
+	if getter == nil || setter == nil || indexer == nil {
+		return fmt.Errorf("a read-create column should have a getter, a setter and an indexer")
+	}
+	length := len(a.root) + len(columnOid)
+	return a.addManagedObject(managedObject{
+		oid: append(asn1.Oid{}, columnOid...),
+		get: a.columnGetter(columnOid, getter),
+		set: func(oid asn1.Oid, value interface{}) error {
+			return setter(oid, oidToIndex(oid[length:]), value)
+		},
+		indexer:    indexer,
+		readCreate: true,
+		rowKey:     a.columnRowKey(columnOid),
+	})
+}
+
+// columnRowKey returns a function identifying the row of a column instance,
+// made of the OID of the table entry followed by the index.
+func (a *Agent) columnRowKey(columnOid asn1.Oid) func(oid asn1.Oid) string {
+	entry := len(a.root) + len(columnOid) - 1
+	return func(oid asn1.Oid) string {
+		key := append(asn1.Oid{}, oid[:entry]...)
+		return append(key, oid[entry+1:]...).String()
+	}
+}
+
+// creatingRows returns the rows created by a SET of the given variables.
+func (a *Agent) creatingRows(variables []Variable) map[string]bool {
+	rows := make(map[string]bool)
+	for _, v := range variables {
+		h := a.getManagedObject(v.Name, false)
+		if h == nil {
+			h = a.newInstance(v.Name)
+		}
+		if h == nil || !h.rowStatus {
+			continue
+		}
+		if status, ok := v.Value.(int); ok &&
+			(status == RowCreateAndGo || status == RowCreateAndWait) {
+			rows[h.rowKey(v.Name)] = true
+		}
+	}
+	return rows
+}
+
+// rowWritable checks if the read-create column instance of h can be SET: its
+// row must be created by the same request, or be notInService or notReady
+// (RFC 2579).
+func (a *Agent) rowWritable(h *managedObject, creating map[string]bool) bool {
+	key := h.rowKey(h.oid)
+	if creating[key] {
+		return true
+	}
+	for i := range a.handlers {
+		r := &a.handlers[i]
+		if !r.rowStatus || len(h.oid) <= len(r.oid) ||
+			!hasPrefix(h.oid, r.oid[:len(r.oid)-1]) {
+			continue
+		}
+		index := h.oid[len(r.oid):]
+		instance := *r
+		instance.oid = append(append(asn1.Oid{}, r.oid...), index...)
+		if r.rowKey(instance.oid) != key || !hasIndex(r.indexer, oidToIndex(index)) {
+			continue
+		}
+		value, err := a.getValue(&instance, instance.oid)
+		return err == nil && (value == RowNotInService || value == RowNotReady)
+	}
+	return false
+}
+
+// hasIndex checks if an index is enumerated by indexer.
+func hasIndex(indexer Indexer, index []int) bool {
+	for _, i := range indexer() {
+		if len(i) != len(index) {
+			continue
+		}
+		equal := true
+		for j := range i {
+			if i[j] != index[j] {
+				equal = false
+				break
+			}
+		}
+		if equal {
+			return true
+		}
+	}
+	return false
+}
+
+// newInstance returns the managed object of an instance of a column that
+// can be SET during row creation, in case oid is under one.
+func (a *Agent) newInstance(oid asn1.Oid) *managedObject {
+	for _, h := range a.handlers {
+		if (h.rowStatus || h.readCreate) && len(oid) > len(h.oid) &&
+			hasPrefix(oid, h.oid) {
+			i := h
+			i.oid = oid
+			return &i
+		}
+	}
+	return nil
+}
+
+// instance returns the managed object of a column instance. With next=false
+// oid must be one of the instances, otherwise the first instance after oid is
+// returned. As indexes are sorted, the instance is found by binary search.
+func (h *managedObject) instance(oid asn1.Oid, next bool) *managedObject {
+	indexes := h.indexer()
+	i := 0
+	if hasPrefix(oid, h.oid) {
+		suffix := oid[len(h.oid):]
+		i = sort.Search(len(indexes), func(i int) bool {
+			cmp := compareIndex(suffix, indexes[i])
+			return cmp < 0 || (!next && cmp == 0)
+		})
+		if !next && i < len(indexes) && compareIndex(suffix, indexes[i]) != 0 {
+			return nil
+		}
+	} else if !next || oid.Cmp(h.oid) > 0 {
+		return nil
+	}
+	if i == len(indexes) {
+		return nil
+	}
+	instance := *h
+	instance.oid = appendIndex(h.oid, indexes[i])
+	return &instance
+}
+
+// compareIndex compares the sub-identifiers of an instance suffix with an
+// index in the same order as asn1.Oid.Cmp.
+func compareIndex(suffix asn1.Oid, index []int) int {
+	for i := 0; i < len(suffix) && i < len(index); i++ {
+		if suffix[i] != uint(index[i]) {
+			if suffix[i] < uint(index[i]) {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(suffix) < len(index):
+		return -1
+	case len(suffix) > len(index):
+		return 1
+	}
+	return 0
+}
+
+// hasPrefix checks if oid is prefix or under prefix.
+func hasPrefix(oid, prefix asn1.Oid) bool {
+	if len(oid) < len(prefix) {
+		return false
+	}
+	return oid[:len(prefix)].Cmp(prefix) == 0
+}
+
+// appendIndex returns a new OID made of base followed by index.
+func appendIndex(base asn1.Oid, index []int) asn1.Oid {
+	oid := make(asn1.Oid, 0, len(base)+len(index))
+	oid = append(oid, base...)
+	for _, n := range index {
+		oid = append(oid, uint(n))
+	}
+	return oid
+}
+
+// oidToIndex converts sub-identifiers to an index.
+func oidToIndex(oid asn1.Oid) []int {
+	index := make([]int, len(oid))
+	for i, n := range oid {
+		index[i] = int(n)
+	}
+	return index
+}
+
+// OidIndex returns the sub-identifiers of requested that follow column, the
+// index of a table column instance. It returns false if requested is not
+// column nor under it.
+func OidIndex(requested, column asn1.Oid) ([]int, bool) {
+	if !hasPrefix(requested, column) {
+		return nil, false
+	}
+	return oidToIndex(requested[len(column):]), true
+}
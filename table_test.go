@@ -0,0 +1,568 @@
+package snmp
+
+import (
+	"testing"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+func TestTableColumn(t *testing.T) {
+
+	column := asn1.Oid{1, 3, 6, 1, 4, 1, 1, 2, 1, 2}
+	indexes := [][]int{{1}, {5}, {9}}
+
+	agent := NewAgent()
+	err := agent.AddRoTableColumn(column,
+		func(oid asn1.Oid, index []int) (interface{}, error) {
+			return index[0] * 10, nil
+		},
+		func() [][]int {
+			return indexes
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = agent.AddRoManagedObject(append(column, 3),
+		func(oid asn1.Oid) (interface{}, error) {
+			return 0, nil
+		})
+	if err == nil {
+		t.Fatal("Registration under a table column should fail.")
+	}
+
+	get := func(pdu interface{}, oid asn1.Oid) GetResponsePdu {
+		request := &Message{Community: "public"}
+		variables := []Variable{{oid, asn1.Null{}}}
+		if _, ok := pdu.(GetNextRequestPdu); ok {
+			request.Pdu = GetNextRequestPdu{Variables: variables}
+		} else {
+			request.Pdu = GetRequestPdu{Variables: variables}
+		}
+		response, err := agent.ProcessMessage(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response.Pdu.(GetResponsePdu)
+	}
+
+	// Get a single instance
+	res := get(GetRequestPdu{}, append(column, 5))
+	if res.ErrorStatus != NoError || res.Variables[0].Value != 50 {
+		t.Fatalf("Wrong response %v\n", res)
+	}
+	res = get(GetRequestPdu{}, append(column, 2))
+	if res.ErrorStatus != NoSuchName {
+		t.Fatalf("Get of a missing instance should fail: %v\n", res)
+	}
+	res = get(GetRequestPdu{}, column)
+	if res.ErrorStatus != NoSuchName {
+		t.Fatalf("Get of the column should fail: %v\n", res)
+	}
+
+	// Walk the column
+	walk := func() []int {
+		var values []int
+		oid := asn1.Oid{1, 3, 6, 1, 4, 1, 1}
+		for {
+			res := get(GetNextRequestPdu{}, oid)
+			if res.ErrorStatus != NoError {
+				return values
+			}
+			values = append(values, res.Variables[0].Value.(int))
+			oid = res.Variables[0].Name
+		}
+	}
+	values := walk()
+	if len(values) != 3 || values[0] != 10 || values[1] != 50 ||
+		values[2] != 90 {
+		t.Fatalf("Wrong walk %v\n", values)
+	}
+	indexes = [][]int{{2}, {5}}
+	values = walk()
+	if len(values) != 2 || values[0] != 20 || values[1] != 50 {
+		t.Fatalf("Wrong walk after changing the indexes %v\n", values)
+	}
+}
+
+func TestWalkSkipsMissingInstances(t *testing.T) {
+
+	column := asn1.Oid{1, 3, 6, 1, 4, 1, 1, 2, 1, 2}
+	agent := NewAgent()
+	agent.AddRoTableColumn(column,
+		func(oid asn1.Oid, index []int) (interface{}, error) {
+			if index[0] == 2 {
+				return nil, ErrNoSuchInstance
+			}
+			return index[0], nil
+		},
+		func() [][]int {
+			return [][]int{{1}, {2}, {3}}
+		})
+
+	request := &Message{
+		Community: "public",
+		Pdu: GetNextRequestPdu{
+			Variables: []Variable{{append(column, 1), asn1.Null{}}},
+		},
+	}
+	response, err := agent.ProcessMessage(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdu := response.Pdu.(GetResponsePdu)
+	if pdu.ErrorStatus != NoError {
+		t.Fatalf("Response contains an error: %d\n", pdu.ErrorStatus)
+	}
+	v := pdu.Variables[0]
+	if v.Name.Cmp(append(column, 3)) != 0 || v.Value != 3 {
+		t.Fatalf("Walk should skip the missing instance. Got %s = %v\n",
+			v.Name, v.Value)
+	}
+
+	// A missing instance is still reported by a Get
+	request.Pdu = GetRequestPdu{
+		Variables: []Variable{{append(column, 2), asn1.Null{}}},
+	}
+	response, err = agent.ProcessMessage(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pdu := response.Pdu.(GetResponsePdu); pdu.ErrorStatus != NoSuchName {
+		t.Fatalf("Get of a missing instance should fail: %v\n", pdu)
+	}
+}
+
+func TestRowStatusColumn(t *testing.T) {
+
+	column := asn1.Oid{1, 3, 6, 1, 4, 1, 1, 2, 1, 3}
+	rows := map[int]int{}
+	indexer := func() [][]int {
+		var indexes [][]int
+		for i := 1; i <= 10; i++ {
+			if _, ok := rows[i]; ok {
+				indexes = append(indexes, []int{i})
+			}
+		}
+		return indexes
+	}
+
+	agent := NewAgent()
+	agent.SetSupportedVersions(Version1, Version2c)
+	err := agent.AddRowStatusColumn(column,
+		func(oid asn1.Oid, index []int) (interface{}, error) {
+			return rows[index[0]], nil
+		},
+		indexer,
+		func(index []int, status int) error {
+			if status == RowCreateAndGo {
+				rows[index[0]] = RowActive
+			} else {
+				rows[index[0]] = RowNotInService
+			}
+			return nil
+		},
+		nil,
+		func(index []int) error {
+			delete(rows, index[0])
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set := func(status int) GetResponsePdu {
+		response, err := agent.ProcessMessage(&Message{
+			Version:   Version2c,
+			Community: "private",
+			Pdu: SetRequestPdu{
+				Variables: []Variable{{append(column, 5), status}},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response.Pdu.(GetResponsePdu)
+	}
+	next := func() GetResponsePdu {
+		response, err := agent.ProcessMessage(&Message{
+			Community: "public",
+			Pdu: GetNextRequestPdu{
+				Variables: []Variable{{column, asn1.Null{}}},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response.Pdu.(GetResponsePdu)
+	}
+
+	if pdu := set(RowCreateAndGo); pdu.ErrorStatus != NoError {
+		t.Fatalf("Row creation failed with error %d\n", pdu.ErrorStatus)
+	}
+	pdu := next()
+	if pdu.ErrorStatus != NoError ||
+		pdu.Variables[0].Name.Cmp(append(column, 5)) != 0 ||
+		pdu.Variables[0].Value != RowActive {
+		t.Fatalf("Created row not found: %v\n", pdu)
+	}
+	if pdu := set(RowCreateAndGo); pdu.ErrorStatus != InconsistentValue {
+		t.Fatalf("Creating an existing row should fail. Got %d instead.\n",
+			pdu.ErrorStatus)
+	}
+
+	if pdu := set(RowDestroy); pdu.ErrorStatus != NoError {
+		t.Fatalf("Row destruction failed with error %d\n", pdu.ErrorStatus)
+	}
+	if pdu := next(); pdu.ErrorStatus != NoSuchName {
+		t.Fatalf("Destroyed row should not be found: %v\n", pdu)
+	}
+	if pdu := set(RowActive); pdu.ErrorStatus != InconsistentValue {
+		t.Fatalf("Activating a missing row should fail. Got %d instead.\n",
+			pdu.ErrorStatus)
+	}
+	if pdu := set(RowNotReady); pdu.ErrorStatus != WrongValue {
+		t.Fatalf("Unsupported status should fail. Got %d instead.\n",
+			pdu.ErrorStatus)
+	}
+}
+
+func TestTableColumnUnderRoot(t *testing.T) {
+
+	agent := NewAgent()
+	agent.SetRootOid(asn1.Oid{1, 3, 6, 1, 4, 1, 1})
+	err := agent.AddRoTableColumn(asn1.Oid{2, 1, 2},
+		func(oid asn1.Oid, index []int) (interface{}, error) {
+			return index[0], nil
+		},
+		func() [][]int {
+			return [][]int{{7}}
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := agent.ProcessMessage(&Message{
+		Community: "public",
+		Pdu: GetRequestPdu{
+			Variables: []Variable{
+				{asn1.Oid{1, 3, 6, 1, 4, 1, 1, 2, 1, 2, 7}, asn1.Null{}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdu := response.Pdu.(GetResponsePdu)
+	if pdu.ErrorStatus != NoError || pdu.Variables[0].Value != 7 {
+		t.Fatalf("Wrong response %v\n", pdu)
+	}
+}
+
+func TestReadCreateColumn(t *testing.T) {
+
+	entry := asn1.Oid{1, 3, 6, 1, 4, 1, 1, 3, 1}
+	status := append(append(asn1.Oid{}, entry...), 2)
+	name := append(append(asn1.Oid{}, entry...), 3)
+	rows := map[int]string{}
+	indexer := func() [][]int {
+		var indexes [][]int
+		for i := 1; i <= 10; i++ {
+			if _, ok := rows[i]; ok {
+				indexes = append(indexes, []int{i})
+			}
+		}
+		return indexes
+	}
+	names := map[int]string{}
+	statuses := map[int]int{}
+
+	agent := NewAgent()
+	agent.SetSupportedVersions(Version1, Version2c)
+	err := agent.AddRowStatusColumn(status,
+		func(oid asn1.Oid, index []int) (interface{}, error) {
+			return statuses[index[0]], nil
+		},
+		indexer,
+		func(index []int, status int) error {
+			rows[index[0]] = names[index[0]]
+			statuses[index[0]] = RowActive
+			return nil
+		},
+		nil,
+		func(index []int) error {
+			delete(rows, index[0])
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = agent.AddRcTableColumn(name,
+		func(oid asn1.Oid, index []int) (interface{}, error) {
+			return rows[index[0]], nil
+		},
+		func(oid asn1.Oid, index []int, value interface{}) error {
+			names[index[0]] = value.(string)
+			return nil
+		},
+		indexer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	setVersion := func(version int, variables ...Variable) GetResponsePdu {
+		response, err := agent.ProcessMessage(&Message{
+			Version:   version,
+			Community: "private",
+			Pdu:       SetRequestPdu{Variables: variables},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response.Pdu.(GetResponsePdu)
+	}
+	set := func(variables ...Variable) GetResponsePdu {
+		return setVersion(Version2c, variables...)
+	}
+
+	pdu := set(Variable{append(name, 2), "alone"})
+	if pdu.ErrorStatus != NotWritable || pdu.ErrorIndex != 1 {
+		t.Fatalf("Response should contain error %d at index 1. Got %d at %d instead.\n",
+			NotWritable, pdu.ErrorStatus, pdu.ErrorIndex)
+	}
+	if _, ok := rows[2]; ok {
+		t.Fatalf("Row should not be created\n")
+	}
+
+	pdu = set(Variable{append(name, 2), "created"},
+		Variable{append(status, 2), RowCreateAndGo})
+	if pdu.ErrorStatus != NoError {
+		t.Fatalf("Response contains an error: %d\n", pdu.ErrorStatus)
+	}
+	if rows[2] != "created" {
+		t.Fatalf("Wrong row %q\n", rows[2])
+	}
+
+	pdu = set(Variable{append(name, 2), "changed"})
+	if pdu.ErrorStatus != NotWritable {
+		t.Fatalf("Existing rows should not be writable. Got %d instead.\n",
+			pdu.ErrorStatus)
+	}
+
+	// SNMPv1 has no notWritable status
+	pdu = setVersion(Version1, Variable{append(name, 2), "changed"})
+	if pdu.ErrorStatus != NoSuchName {
+		t.Fatalf("Response should contain error %d. Got %d instead.\n",
+			NoSuchName, pdu.ErrorStatus)
+	}
+
+	// Rows that are not in service can be changed
+	statuses[2] = RowNotInService
+	if pdu := set(Variable{append(name, 2), "changed"}); pdu.ErrorStatus != NoError {
+		t.Fatalf("Response contains an error: %d\n", pdu.ErrorStatus)
+	}
+	if names[2] != "changed" {
+		t.Fatalf("Wrong name %q\n", names[2])
+	}
+}
+
+func TestRowStatusCreateAndWait(t *testing.T) {
+
+	entry := asn1.Oid{1, 3, 6, 1, 4, 1, 1, 4, 1}
+	status := append(append(asn1.Oid{}, entry...), 2)
+	name := append(append(asn1.Oid{}, entry...), 3)
+	statuses := map[int]int{}
+	names := map[int]string{}
+	indexer := func() [][]int {
+		var indexes [][]int
+		for i := 1; i <= 10; i++ {
+			if _, ok := statuses[i]; ok {
+				indexes = append(indexes, []int{i})
+			}
+		}
+		return indexes
+	}
+
+	agent := NewAgent()
+	agent.SetSupportedVersions(Version2c)
+	err := agent.AddRowStatusColumn(status,
+		func(oid asn1.Oid, index []int) (interface{}, error) {
+			return statuses[index[0]], nil
+		},
+		indexer,
+		func(index []int, status int) error {
+			statuses[index[0]] = RowNotReady
+			return nil
+		},
+		func(index []int, status int) error {
+			if names[index[0]] == "" {
+				return VarErrorf(InconsistentValue, "row %v has no name", index)
+			}
+			statuses[index[0]] = status
+			return nil
+		},
+		func(index []int) error {
+			delete(statuses, index[0])
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = agent.AddRcTableColumn(name,
+		func(oid asn1.Oid, index []int) (interface{}, error) {
+			return names[index[0]], nil
+		},
+		func(oid asn1.Oid, index []int, value interface{}) error {
+			names[index[0]] = value.(string)
+			return nil
+		},
+		indexer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set := func(v Variable) GetResponsePdu {
+		response, err := agent.ProcessMessage(&Message{
+			Version:   Version2c,
+			Community: "private",
+			Pdu:       SetRequestPdu{Variables: []Variable{v}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response.Pdu.(GetResponsePdu)
+	}
+
+	steps := []struct {
+		v       Variable
+		status  int
+		row     int
+		comment string
+	}{
+		{Variable{append(status, 3), RowCreateAndWait}, NoError, RowNotReady,
+			"create the row"},
+		{Variable{append(status, 3), RowActive}, InconsistentValue, RowNotReady,
+			"activate an incomplete row"},
+		{Variable{append(name, 3), "name"}, NoError, RowNotReady,
+			"set a column of the row"},
+		{Variable{append(status, 3), RowActive}, NoError, RowActive,
+			"activate the row"},
+		{Variable{append(name, 3), "other"}, NotWritable, RowActive,
+			"set a column of an active row"},
+		{Variable{append(status, 3), RowNotInService}, NoError, RowNotInService,
+			"take the row out of service"},
+		{Variable{append(name, 3), "other"}, NoError, RowNotInService,
+			"set a column of a row not in service"},
+		{Variable{append(status, 3), RowActive}, NoError, RowActive,
+			"activate the row again"},
+	}
+	for _, step := range steps {
+		if pdu := set(step.v); pdu.ErrorStatus != step.status {
+			t.Fatalf("Expected status %d to %s, got %d\n", step.status,
+				step.comment, pdu.ErrorStatus)
+		}
+		if statuses[3] != step.row {
+			t.Fatalf("Expected row status %d after trying to %s, got %d\n",
+				step.row, step.comment, statuses[3])
+		}
+	}
+	if names[3] != "other" {
+		t.Fatalf("Expected name %q, got %q\n", "other", names[3])
+	}
+}
+
+func TestOidIndex(t *testing.T) {
+	column := asn1.Oid{1, 3, 6, 1, 2, 1, 2, 2, 1, 2}
+
+	index, ok := OidIndex(asn1.Oid{1, 3, 6, 1, 2, 1, 2, 2, 1, 2, 4, 7}, column)
+	if !ok || len(index) != 2 || index[0] != 4 || index[1] != 7 {
+		t.Fatalf("Wrong index %v\n", index)
+	}
+	index, ok = OidIndex(column, column)
+	if !ok || len(index) != 0 {
+		t.Fatalf("Wrong index %v for the column OID\n", index)
+	}
+	if _, ok := OidIndex(asn1.Oid{1, 3, 6, 1, 2, 1, 2, 2, 1, 3, 4}, column); ok {
+		t.Fatalf("OID of another column should not match\n")
+	}
+	if _, ok := OidIndex(asn1.Oid{1, 3, 6}, column); ok {
+		t.Fatalf("Shorter OID should not match\n")
+	}
+}
+
+func TestTableColumnNext(t *testing.T) {
+
+	column := asn1.Oid{1, 3, 6, 1, 4, 1, 1, 2, 1, 2}
+	agent := NewAgent()
+	agent.AddRoTableColumn(column,
+		func(oid asn1.Oid, index []int) (interface{}, error) {
+			return index[0], nil
+		},
+		func() [][]int {
+			return [][]int{{1}, {5}, {5, 2}, {9}}
+		})
+
+	cases := []struct {
+		from     asn1.Oid
+		expected asn1.Oid
+	}{
+		{asn1.Oid{1, 3, 6, 1, 4, 1, 1, 2, 1}, append(column, 1)},
+		{column, append(column, 1)},
+		{append(column, 3), append(column, 5)},
+		{append(column, 5), append(column, 5, 2)},
+		{append(column, 5, 1), append(column, 5, 2)},
+		{append(column, 5, 2), append(column, 9)},
+	}
+	for _, c := range cases {
+		h := agent.getManagedObject(c.from, true)
+		if h == nil || h.oid.Cmp(c.expected) != 0 {
+			t.Fatalf("GetNext of %s should return %s, got %v\n", c.from,
+				c.expected, h)
+		}
+	}
+	if h := agent.getManagedObject(append(column, 9), true); h != nil {
+		t.Fatalf("GetNext of the last instance returned %s\n", h.oid)
+	}
+	if h := agent.getManagedObject(append(column, 3), false); h != nil {
+		t.Fatalf("Get of a missing instance returned %s\n", h.oid)
+	}
+	if h := agent.getManagedObject(append(column, 5, 2), false); h == nil {
+		t.Fatalf("Get of an existing instance failed\n")
+	}
+}
+
+// benchmarkTableResume measures a GetNext resuming a walk of a 50k-row table
+// at the given row.
+func benchmarkTableResume(b *testing.B, row int) {
+	column := asn1.Oid{1, 3, 6, 1, 4, 1, 1, 2, 1, 2}
+	indexes := make([][]int, 50000)
+	for i := range indexes {
+		indexes[i] = []int{i + 1}
+	}
+	agent := NewAgent()
+	agent.AddRoTableColumn(column,
+		func(oid asn1.Oid, index []int) (interface{}, error) {
+			return index[0], nil
+		},
+		func() [][]int {
+			return indexes
+		})
+	request := &Message{
+		Community: "public",
+		Pdu: GetNextRequestPdu{
+			Variables: []Variable{{append(column, uint(row)), asn1.Null{}}},
+		},
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := agent.ProcessMessage(request); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTableResumeFirstRow(b *testing.B) {
+	benchmarkTableResume(b, 1)
+}
+
+func BenchmarkTableResumeLastRow(b *testing.B) {
+	benchmarkTableResume(b, 49999)
+}
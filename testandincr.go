@@ -0,0 +1,65 @@
+package snmp
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+// AddTestAndIncr registers a read-write object of the TestAndIncr textual
+// convention (RFC 2579), like snmpSetSerialNo (1.3.6.1.6.3.1.1.6.1.0).
+// Managers coordinate their SETs by reading the object and including the
+// value in a SET: it succeeds only if the value still matches, and then the
+// object is incremented. The increment is reverted when another variable of
+// the SET fails. Mismatching values fail with InconsistentValue. The
+// initial value is random. The OID is absolute, it ignores the root OID of
+// the agent.
+func (a *Agent) AddTestAndIncr(oid asn1.Oid) error {
+	var mu sync.Mutex
+	current := int(rand.Int31())
+	return a.register(managedObject{
+		oid: oid,
+		get: func(oid asn1.Oid) (interface{}, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			return current, nil
+		},
+		set: func(oid asn1.Oid, value interface{}) error {
+			n, ok := value.(int)
+			if !ok {
+				return VarErrorf(WrongType, "invalid type %T for %s", value, oid)
+			}
+			if n < 0 || n > math.MaxInt32 {
+				return VarErrorf(WrongValue, "invalid value %d for %s", n, oid)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if n != current {
+				return VarErrorf(InconsistentValue, "value %d of %s is not %d",
+					n, oid, current)
+			}
+			current = nextTestAndIncr(current)
+			return nil
+		},
+		// A failed SET restores the value it matched, unless another SET
+		// already used the incremented one
+		undoSet: func(oid asn1.Oid, value interface{}) {
+			mu.Lock()
+			defer mu.Unlock()
+			if n := value.(int); current == nextTestAndIncr(n) {
+				current = n
+			}
+		},
+	})
+}
+
+// nextTestAndIncr returns the value of a TestAndIncr object after n, which
+// wraps to 0 after the maximum value.
+func nextTestAndIncr(n int) int {
+	if n == math.MaxInt32 {
+		return 0
+	}
+	return n + 1
+}
@@ -0,0 +1,85 @@
+package snmp
+
+import (
+	"testing"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+func TestTestAndIncr(t *testing.T) {
+
+	oid := asn1.Oid{1, 3, 6, 1, 6, 3, 1, 1, 6, 1, 0}
+	agent := NewAgent()
+	agent.SetSupportedVersions(Version1, Version2c)
+	if err := agent.AddTestAndIncr(oid); err != nil {
+		t.Fatal(err)
+	}
+
+	process := func(community string, pdu interface{}) GetResponsePdu {
+		response, err := agent.ProcessMessage(&Message{
+			Version:   Version2c,
+			Community: community,
+			Pdu:       pdu,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response.Pdu.(GetResponsePdu)
+	}
+	get := func() int {
+		pdu := process("public", GetRequestPdu{
+			Variables: []Variable{{oid, asn1.Null{}}},
+		})
+		return pdu.Variables[0].Value.(int)
+	}
+	set := func(value int) GetResponsePdu {
+		return process("private", SetRequestPdu{
+			Variables: []Variable{{oid, value}},
+		})
+	}
+
+	current := get()
+	if pdu := set(current); pdu.ErrorStatus != NoError {
+		t.Fatalf("Response contains an error: %d\n", pdu.ErrorStatus)
+	}
+	if value := get(); value != current+1 && !(value == 0 && current == 2147483647) {
+		t.Fatalf("Value should be incremented from %d, got %d\n", current, value)
+	}
+	if pdu := set(current); pdu.ErrorStatus != InconsistentValue || pdu.ErrorIndex != 1 {
+		t.Fatalf("Response should contain error %d at index 1. Got %d at %d instead.\n",
+			InconsistentValue, pdu.ErrorStatus, pdu.ErrorIndex)
+	}
+
+	// SNMPv1 has no inconsistentValue status
+	response, err := agent.ProcessMessage(&Message{
+		Community: "private",
+		Pdu:       SetRequestPdu{Variables: []Variable{{oid, current}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pdu := response.Pdu.(GetResponsePdu); pdu.ErrorStatus != BadValue {
+		t.Fatalf("Response should contain error %d. Got %d instead.\n",
+			BadValue, pdu.ErrorStatus)
+	}
+
+	// The increment is reverted when another variable fails
+	current = get()
+	pdu := process("private", SetRequestPdu{
+		Variables: []Variable{
+			{oid, current},
+			{asn1.Oid{1, 3, 6, 1, 4, 1, 1, 1, 0}, 1},
+		},
+	})
+	if pdu.ErrorStatus != NoCreation || pdu.ErrorIndex != 2 {
+		t.Fatalf("Response should contain error %d at index 2. Got %d at %d instead.\n",
+			NoCreation, pdu.ErrorStatus, pdu.ErrorIndex)
+	}
+	if value := get(); value != current {
+		t.Fatalf("Value should still be %d after a failed SET, got %d\n",
+			current, value)
+	}
+	if pdu := set(current); pdu.ErrorStatus != NoError {
+		t.Fatalf("Response contains an error: %d\n", pdu.ErrorStatus)
+	}
+}
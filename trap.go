@@ -0,0 +1,213 @@
+package snmp
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+// Generic trap types defined by RFC 1157.
+const (
+	ColdStart             = 0
+	WarmStart             = 1
+	LinkDown              = 2
+	LinkUp                = 3
+	AuthenticationFailure = 4
+	EgpNeighborLoss       = 5
+	EnterpriseSpecific    = 6
+)
+
+var (
+	sysObjectIDOid = asn1.Oid{1, 3, 6, 1, 2, 1, 1, 2, 0}
+	sysUpTimeOid   = asn1.Oid{1, 3, 6, 1, 2, 1, 1, 3, 0}
+)
+
+// ColdStartTrap returns an encoded SNMPv1 coldStart trap, which an agent
+// sends when it is reinitializing with a possibly changed configuration.
+// The enterprise is the value of sysObjectID and the timestamp the value of
+// sysUpTime, so the system group must be registered (see
+// RegisterSystemGroup). The trap has no variables and uses the public
+// community.
+func (a *Agent) ColdStartTrap(agentAddr IPAddress) ([]byte, error) {
+	return a.genericTrap(agentAddr, ColdStart)
+}
+
+// WarmStartTrap returns an encoded SNMPv1 warmStart trap, which an agent
+// sends when it is reinitializing without changing its configuration. See
+// ColdStartTrap.
+func (a *Agent) WarmStartTrap(agentAddr IPAddress) ([]byte, error) {
+	return a.genericTrap(agentAddr, WarmStart)
+}
+
+// genericTrap encodes a generic trap without variables.
+func (a *Agent) genericTrap(agentAddr IPAddress, generic int) ([]byte, error) {
+	pdu, err := a.v1Trap(nil, agentAddr, generic, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	return EncodePdu(Version1, a.public, pdu)
+}
+
+// v1Trap builds a SNMPv1 trap PDU. The enterprise defaults to the value of
+// sysObjectID and the timestamp is always the value of sysUpTime.
+func (a *Agent) v1Trap(enterprise asn1.Oid, agentAddr IPAddress, generic,
+	specific int, variables []Variable) (pdu V1TrapPdu, err error) {
+
+	if generic < ColdStart || generic > EnterpriseSpecific {
+		return pdu, fmt.Errorf("invalid generic trap %d", generic)
+	}
+	if generic != EnterpriseSpecific && specific != 0 {
+		return pdu, fmt.Errorf("specific trap %d of generic trap %d",
+			specific, generic)
+	}
+	if len(enterprise) == 0 {
+		value, err := a.systemValue(sysObjectIDOid)
+		if err != nil {
+			return pdu, err
+		}
+		var ok bool
+		enterprise, ok = value.(asn1.Oid)
+		if !ok {
+			return pdu, fmt.Errorf("invalid type %T for sysObjectID", value)
+		}
+	}
+	value, err := a.systemValue(sysUpTimeOid)
+	if err != nil {
+		return pdu, err
+	}
+	timestamp, ok := value.(TimeTicks)
+	if !ok {
+		return pdu, fmt.Errorf("invalid type %T for sysUpTime", value)
+	}
+	if variables == nil {
+		variables = []Variable{}
+	}
+	return V1TrapPdu{
+		Enterprise:   enterprise,
+		AgentAddr:    agentAddr,
+		GenericTrap:  generic,
+		SpecificTrap: specific,
+		Timestamp:    timestamp,
+		Variables:    variables,
+	}, nil
+}
+
+// TrapDestination is a manager receiving the traps sent by an agent.
+type TrapDestination struct {
+	// Writer sends the encoded traps, usually a connected UDP socket:
+	//
+	//	conn, err := net.Dial("udp", "manager:162")
+	Writer io.Writer
+	// Version of the traps sent to the destination.
+	Version int
+	// Community of the traps sent to the destination.
+	Community string
+}
+
+// AddTrapDestination adds a destination for the traps sent by the agent.
+func (a *Agent) AddTrapDestination(d TrapDestination) error {
+	if d.Writer == nil {
+		return fmt.Errorf("trap destination without writer")
+	}
+	if d.Version != Version1 && d.Version != Version2c {
+		return fmt.Errorf("SNMP version %d is not supported for traps",
+			d.Version)
+	}
+	a.trapDestinations = append(a.trapDestinations, d)
+	return nil
+}
+
+// SendV1Trap sends a SNMPv1 trap to the Version1 destinations. An empty
+// enterprise stands for the value of sysObjectID, and the timestamp is the
+// value of sysUpTime, so the system group must be registered (see
+// RegisterSystemGroup). The specific trap must be 0 unless generic is
+// EnterpriseSpecific. Every destination is tried; the first error is
+// returned.
+func (a *Agent) SendV1Trap(enterprise asn1.Oid, agentAddr IPAddress, generic,
+	specific int, variables ...Variable) error {
+
+	pdu, err := a.v1Trap(enterprise, agentAddr, generic, specific, variables)
+	if err != nil {
+		return err
+	}
+	return a.sendTrap(Version1, pdu)
+}
+
+// SendV2Trap sends a SNMPv2 trap to the Version2c destinations. The
+// variables are preceded by sysUpTime.0 and by snmpTrapOID.0 with the value
+// trapOID, as required by RFC 3416, so the system group must be registered
+// (see RegisterSystemGroup). Every destination is tried; the first error is
+// returned.
+func (a *Agent) SendV2Trap(trapOID asn1.Oid, variables ...Variable) error {
+	if len(trapOID) == 0 {
+		return fmt.Errorf("empty snmpTrapOID")
+	}
+	return a.sendV2Trap(append([]Variable{{snmpTrapOIDOid, trapOID}},
+		variables...))
+}
+
+// SendNotification sends the SNMPv2 trap of a notification type, with the
+// variables built from values by NotificationType.Build. See SendV2Trap.
+func (a *Agent) SendNotification(n NotificationType,
+	values map[string]interface{}) error {
+
+	variables, err := n.Build(values)
+	if err != nil {
+		return err
+	}
+	return a.sendV2Trap(variables)
+}
+
+// sendV2Trap sends a SNMPv2 trap with the given variables, which start with
+// snmpTrapOID.0, after sysUpTime.0.
+func (a *Agent) sendV2Trap(variables []Variable) error {
+	value, err := a.systemValue(sysUpTimeOid)
+	if err != nil {
+		return err
+	}
+	timestamp, ok := value.(TimeTicks)
+	if !ok {
+		return fmt.Errorf("invalid type %T for sysUpTime", value)
+	}
+	for _, v := range variables[1:] {
+		if v.Name.Cmp(sysUpTimeOid) == 0 || v.Name.Cmp(snmpTrapOIDOid) == 0 {
+			return fmt.Errorf("variable %s is set by the agent", v.Name)
+		}
+	}
+	return a.sendTrap(Version2c, V2TrapPdu{
+		Identifier: int(atomic.AddUint32(&a.trapID, 1) & 0x7fffffff),
+		Variables:  append([]Variable{{sysUpTimeOid, timestamp}}, variables...),
+	})
+}
+
+// sendTrap encodes a trap for each destination of a version and writes it.
+func (a *Agent) sendTrap(version int, pdu interface{}) error {
+	var first error
+	for _, d := range a.trapDestinations {
+		if d.Version != version {
+			continue
+		}
+		data, err := EncodePdu(version, d.Community, pdu)
+		if err == nil {
+			_, err = d.Writer.Write(data)
+		}
+		if err != nil {
+			a.logf(LogError, "failed to send trap: %s\n", err)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// systemValue returns the current value of a registered scalar.
+func (a *Agent) systemValue(oid asn1.Oid) (interface{}, error) {
+	h := a.registered(oid)
+	if h == nil {
+		return nil, fmt.Errorf("OID %s is not registered", oid)
+	}
+	return a.getValue(h, h.oid)
+}
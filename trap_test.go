@@ -0,0 +1,249 @@
+package snmp
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+func TestStartTraps(t *testing.T) {
+
+	agent := NewAgent()
+	if _, err := agent.ColdStartTrap(IPAddress{10, 0, 0, 1}); err == nil {
+		t.Fatal("Traps can't be sent without the system group.")
+	}
+
+	objectID := asn1.Oid{1, 3, 6, 1, 4, 1, 12345}
+	err := agent.RegisterSystemGroup("descr", objectID, "contact", "name",
+		"location", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	traps := []struct {
+		generic int
+		trap    func(IPAddress) ([]byte, error)
+	}{
+		{ColdStart, agent.ColdStartTrap},
+		{WarmStart, agent.WarmStartTrap},
+	}
+	for _, e := range traps {
+		data, err := e.trap(IPAddress{10, 0, 0, 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		message, err := DecodeMessage(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pdu, ok := message.Pdu.(V1TrapPdu)
+		if !ok {
+			t.Fatalf("Wrong PDU type %T\n", message.Pdu)
+		}
+		if pdu.GenericTrap != e.generic {
+			t.Fatalf("Expected generic trap %d, got %d\n", e.generic,
+				pdu.GenericTrap)
+		}
+		if pdu.Enterprise.Cmp(objectID) != 0 {
+			t.Fatalf("Expected enterprise %s, got %s\n", objectID, pdu.Enterprise)
+		}
+		if pdu.AgentAddr != (IPAddress{10, 0, 0, 1}) {
+			t.Fatalf("Wrong agent address %v\n", pdu.AgentAddr)
+		}
+		if len(pdu.Variables) != 0 {
+			t.Fatalf("Wrong variables %v\n", pdu.Variables)
+		}
+	}
+}
+
+type failingWriterForTest struct{}
+
+func (failingWriterForTest) Write(data []byte) (int, error) {
+	return 0, fmt.Errorf("unreachable")
+}
+
+func TestSendV1Trap(t *testing.T) {
+
+	agent := NewAgent()
+	objectID := asn1.Oid{1, 3, 6, 1, 4, 1, 12345}
+	err := agent.RegisterSystemGroup("descr", objectID, "contact", "name",
+		"location", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := agent.AddTrapDestination(TrapDestination{}); err == nil {
+		t.Fatal("Destinations without writer should be refused.")
+	}
+	var v1, v2 bytes.Buffer
+	agent.AddTrapDestination(TrapDestination{Writer: &v1, Community: "traps"})
+	agent.AddTrapDestination(TrapDestination{Writer: &v2, Version: Version2c,
+		Community: "traps"})
+
+	if err := agent.SendV1Trap(nil, IPAddress{10, 0, 0, 1}, LinkUp, 1); err == nil {
+		t.Fatal("Specific traps need the EnterpriseSpecific generic trap.")
+	}
+	enterprise := asn1.Oid{1, 3, 6, 1, 4, 1, 54321}
+	variable := Variable{asn1.Oid{1, 3, 6, 1, 2, 1, 2, 2, 1, 1, 1}, 1}
+	err = agent.SendV1Trap(enterprise, IPAddress{10, 0, 0, 1},
+		EnterpriseSpecific, 7, variable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v2.Len() != 0 {
+		t.Fatal("SNMPv1 traps should not be sent to SNMPv2c destinations.")
+	}
+	message, err := DecodeMessage(v1.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Version != Version1 || message.Community != "traps" {
+		t.Fatalf("Wrong version %d or community %q\n", message.Version,
+			message.Community)
+	}
+	pdu, ok := message.Pdu.(V1TrapPdu)
+	if !ok {
+		t.Fatalf("Wrong PDU type %T\n", message.Pdu)
+	}
+	if pdu.Enterprise.Cmp(enterprise) != 0 || pdu.GenericTrap != EnterpriseSpecific ||
+		pdu.SpecificTrap != 7 {
+		t.Fatalf("Wrong trap %s %d %d\n", pdu.Enterprise, pdu.GenericTrap,
+			pdu.SpecificTrap)
+	}
+	if len(pdu.Variables) != 1 || pdu.Variables[0].Name.Cmp(variable.Name) != 0 {
+		t.Fatalf("Wrong variables %v\n", pdu.Variables)
+	}
+
+	// Without enterprise, sysObjectID is used
+	v1.Reset()
+	if err := agent.SendV1Trap(nil, IPAddress{10, 0, 0, 1}, LinkDown, 0); err != nil {
+		t.Fatal(err)
+	}
+	message, err = DecodeMessage(v1.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pdu := message.Pdu.(V1TrapPdu); pdu.Enterprise.Cmp(objectID) != 0 {
+		t.Fatalf("Expected enterprise %s, got %s\n", objectID, pdu.Enterprise)
+	}
+
+	// Failing destinations don't prevent sending to the others
+	v1.Reset()
+	agent.trapDestinations = append([]TrapDestination{{Writer: failingWriterForTest{}}},
+		agent.trapDestinations...)
+	if err := agent.SendV1Trap(nil, IPAddress{10, 0, 0, 1}, ColdStart, 0); err == nil {
+		t.Fatal("Write errors should be returned.")
+	}
+	if v1.Len() == 0 {
+		t.Fatal("Traps should still be sent after a failing destination.")
+	}
+}
+
+func TestSendV2Trap(t *testing.T) {
+
+	agent := NewAgent()
+	var v2 bytes.Buffer
+	agent.AddTrapDestination(TrapDestination{Writer: &v2, Version: Version2c,
+		Community: "traps"})
+	linkUp := asn1.Oid{1, 3, 6, 1, 6, 3, 1, 1, 5, 4}
+	if err := agent.SendV2Trap(linkUp); err == nil {
+		t.Fatal("Traps can't be sent without the system group.")
+	}
+	err := agent.RegisterSystemGroup("descr", asn1.Oid{1, 3, 6, 1, 4, 1, 12345},
+		"contact", "name", "location", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ifIndex := Variable{asn1.Oid{1, 3, 6, 1, 2, 1, 2, 2, 1, 1, 1}, 1}
+	if err := agent.SendV2Trap(linkUp, Variable{sysUpTimeOid, TimeTicks(0)}); err == nil {
+		t.Fatal("sysUpTime.0 should be refused in the variables.")
+	}
+	if err := agent.SendV2Trap(linkUp, ifIndex); err != nil {
+		t.Fatal(err)
+	}
+	message, err := DecodeMessage(v2.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Version != Version2c || message.Community != "traps" {
+		t.Fatalf("Wrong version %d or community %q\n", message.Version,
+			message.Community)
+	}
+	pdu, ok := message.Pdu.(V2TrapPdu)
+	if !ok {
+		t.Fatalf("Wrong PDU type %T\n", message.Pdu)
+	}
+	expected := []asn1.Oid{sysUpTimeOid, snmpTrapOIDOid, ifIndex.Name}
+	if len(pdu.Variables) != len(expected) {
+		t.Fatalf("Wrong variables %v\n", pdu.Variables)
+	}
+	for i, oid := range expected {
+		if pdu.Variables[i].Name.Cmp(oid) != 0 {
+			t.Fatalf("Expected %s at %d, got %s\n", oid, i, pdu.Variables[i].Name)
+		}
+	}
+	if _, ok := pdu.Variables[0].Value.(TimeTicks); !ok {
+		t.Fatalf("Wrong type %T for sysUpTime\n", pdu.Variables[0].Value)
+	}
+	if oid, ok := pdu.Variables[1].Value.(asn1.Oid); !ok || oid.Cmp(linkUp) != 0 {
+		t.Fatalf("Expected snmpTrapOID %s, got %v\n", linkUp, pdu.Variables[1].Value)
+	}
+
+	// Notification types build the variables
+	v2.Reset()
+	n := NotificationType{Oid: linkUp, Objects: []NotificationObject{
+		{Oid: asn1.Oid{1, 3, 6, 1, 2, 1, 2, 2, 1, 1}},
+	}}
+	err = agent.SendNotification(n, map[string]interface{}{"1.3.6.1.2.1.2.2.1.1.1": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err = DecodeMessage(v2.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pdu := message.Pdu.(V2TrapPdu); len(pdu.Variables) != 3 ||
+		pdu.Variables[0].Name.Cmp(sysUpTimeOid) != 0 {
+		t.Fatalf("Wrong variables %v\n", pdu.Variables)
+	}
+}
+
+func TestTrapsWithRootOid(t *testing.T) {
+
+	agent := NewAgent()
+	agent.SetRootOid(asn1.Oid{1, 3, 6, 1, 4, 1, 9999})
+	objectID := asn1.Oid{1, 3, 6, 1, 4, 1, 9999, 1}
+	err := agent.RegisterSystemGroup("descr", objectID, "contact", "name",
+		"location", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !agent.HasManagedObject(sysUpTimeOid) {
+		t.Fatal("sysUpTime.0 should be at its standard OID.")
+	}
+	var v1, v2 bytes.Buffer
+	agent.AddTrapDestination(TrapDestination{Writer: &v1, Community: "traps"})
+	agent.AddTrapDestination(TrapDestination{Writer: &v2, Version: Version2c,
+		Community: "traps"})
+
+	if _, err := agent.ColdStartTrap(IPAddress{10, 0, 0, 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := agent.SendV1Trap(nil, IPAddress{10, 0, 0, 1}, WarmStart, 0); err != nil {
+		t.Fatal(err)
+	}
+	message, err := DecodeMessage(v1.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pdu := message.Pdu.(V1TrapPdu); pdu.Enterprise.Cmp(objectID) != 0 {
+		t.Fatalf("Expected enterprise %s, got %s\n", objectID, pdu.Enterprise)
+	}
+	if err := agent.SendV2Trap(asn1.Oid{1, 3, 6, 1, 6, 3, 1, 1, 5, 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecodeMessage(v2.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+}
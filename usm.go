@@ -0,0 +1,680 @@
+package snmp
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"sync"
+	"time"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+// AuthProtocol is an authentication protocol of the User-based Security
+// Model.
+type AuthProtocol int
+
+// Authentication protocols.
+const (
+	NoAuth AuthProtocol = iota
+	// AuthMD5 is usmHMACMD5AuthProtocol (HMAC-MD5-96).
+	AuthMD5
+	// AuthSHA is usmHMACSHAAuthProtocol (HMAC-SHA-96).
+	AuthSHA
+	// AuthSHA224 is usmHMAC128SHA224AuthProtocol (RFC 7860).
+	AuthSHA224
+	// AuthSHA256 is usmHMAC192SHA256AuthProtocol (RFC 7860).
+	AuthSHA256
+	// AuthSHA384 is usmHMAC256SHA384AuthProtocol (RFC 7860).
+	AuthSHA384
+	// AuthSHA512 is usmHMAC384SHA512AuthProtocol (RFC 7860).
+	AuthSHA512
+)
+
+// hash returns the hash function of the protocol.
+func (p AuthProtocol) hash() func() hash.Hash {
+	switch p {
+	case AuthMD5:
+		return md5.New
+	case AuthSHA:
+		return sha1.New
+	case AuthSHA224:
+		return sha256.New224
+	case AuthSHA256:
+		return sha256.New
+	case AuthSHA384:
+		return sha512.New384
+	case AuthSHA512:
+		return sha512.New
+	}
+	return nil
+}
+
+// digestLength returns the length of the authentication parameters, the
+// truncated HMAC.
+func (p AuthProtocol) digestLength() int {
+	switch p {
+	case AuthMD5, AuthSHA:
+		return 12
+	case AuthSHA224:
+		return 16
+	case AuthSHA256:
+		return 24
+	case AuthSHA384:
+		return 32
+	case AuthSHA512:
+		return 48
+	}
+	return 0
+}
+
+// keyLength returns the length of the localized key, the size of the hash.
+func (p AuthProtocol) keyLength() int {
+	if h := p.hash(); h != nil {
+		return h().Size()
+	}
+	return 0
+}
+
+// authenticate writes the digest of message at offset, where the
+// authentication parameters are.
+func (p AuthProtocol) authenticate(key, message []byte, offset int) {
+	digest := p.digest(key, message, offset)
+	copy(message[offset:], digest)
+}
+
+// verify checks the digest of a message whose authentication parameters are
+// at offset.
+func (p AuthProtocol) verify(key, message []byte, offset int) bool {
+	n := p.digestLength()
+	if offset+n > len(message) {
+		return false
+	}
+	received := message[offset : offset+n]
+	return hmac.Equal(received, p.digest(key, message, offset))
+}
+
+// digest computes the digest of a message with the authentication parameters
+// at offset set to zeros, as done when it's generated.
+func (p AuthProtocol) digest(key, message []byte, offset int) []byte {
+	zeroed := append([]byte{}, message...)
+	for i := 0; i < p.digestLength(); i++ {
+		zeroed[offset+i] = 0
+	}
+	mac := hmac.New(p.hash(), key)
+	mac.Write(zeroed)
+	return mac.Sum(nil)[:p.digestLength()]
+}
+
+// PrivProtocol is a privacy protocol of the User-based Security Model.
+type PrivProtocol int
+
+// Privacy protocols.
+const (
+	NoPriv PrivProtocol = iota
+	// PrivDES is usmDESPrivProtocol (CBC-DES).
+	PrivDES
+	// PrivAES128 is usmAesCfb128Protocol (RFC 3826).
+	PrivAES128
+	// PrivAES192 and PrivAES256 are CFB-AES with the key extension of the
+	// Blumenthal draft, as done by Net-SNMP.
+	PrivAES192
+	PrivAES256
+	// PrivAES192Reeder and PrivAES256Reeder are CFB-AES with the key
+	// extension of the Reeder draft, as done by Cisco devices.
+	PrivAES192Reeder
+	PrivAES256Reeder
+)
+
+// keyLength returns the minimum length of the localized key.
+func (p PrivProtocol) keyLength() int {
+	switch p {
+	case PrivDES, PrivAES128:
+		return 16
+	case PrivAES192, PrivAES192Reeder:
+		return 24
+	case PrivAES256, PrivAES256Reeder:
+		return 32
+	}
+	return 0
+}
+
+// isAES checks if the protocol is one of the CFB-AES variants.
+func (p PrivProtocol) isAES() bool {
+	return p >= PrivAES128 && p <= PrivAES256Reeder
+}
+
+// extendKey extends a localized key shorter than needed by the protocol,
+// using the hash of the authentication protocol. Other keys are returned
+// unchanged.
+func (p PrivProtocol) extendKey(key []byte, auth AuthProtocol,
+	engineID []byte) []byte {
+
+	h := auth.hash()
+	if len(key) == 0 || len(key) >= p.keyLength() || h == nil {
+		return key
+	}
+	key = append([]byte{}, key...)
+	for len(key) < p.keyLength() {
+		switch p {
+		case PrivAES192, PrivAES256:
+			// Kul' = Kul || H(Kul)
+			digest := h()
+			digest.Write(key)
+			key = digest.Sum(key)
+		case PrivAES192Reeder, PrivAES256Reeder:
+			// The key is used as a password localized again
+			key = append(key, localizeKey(h, passwordToKey(h, key), engineID)...)
+		default:
+			return key
+		}
+	}
+	return key[:p.keyLength()]
+}
+
+// paramsLength returns the length of the privacy parameters, the salt.
+func (p PrivProtocol) paramsLength() int {
+	if p == PrivDES || p.isAES() {
+		return 8
+	}
+	return 0
+}
+
+// expansion returns how many bytes encryption may add to an encoded scoped
+// PDU: the padding up to a block and a longer length in the OCTET STRING.
+func (p PrivProtocol) expansion() int {
+	if p == PrivDES {
+		return des.BlockSize
+	}
+	// CFB-AES doesn't need padding
+	return 0
+}
+
+// encrypt encrypts a scoped PDU returning the privacy parameters. The salt
+// must be different for each message.
+func (p PrivProtocol) encrypt(key []byte, boots, engineTime int, salt uint64,
+	data []byte) (encrypted, params []byte, err error) {
+
+	switch {
+	case p == PrivDES:
+		params = make([]byte, p.paramsLength())
+		binary.BigEndian.PutUint32(params, uint32(boots))
+		binary.BigEndian.PutUint32(params[4:], uint32(salt))
+		block, err := des.NewCipher(key[:8])
+		if err != nil {
+			return nil, nil, err
+		}
+		// Padding bytes are ignored by the receiver
+		padded := append([]byte{}, data...)
+		if n := len(padded) % des.BlockSize; n != 0 {
+			padded = append(padded, make([]byte, des.BlockSize-n)...)
+		}
+		encrypted = make([]byte, len(padded))
+		cipher.NewCBCEncrypter(block, desIV(key, params)).CryptBlocks(encrypted,
+			padded)
+		return encrypted, params, nil
+	case p.isAES():
+		params = make([]byte, p.paramsLength())
+		binary.BigEndian.PutUint64(params, salt)
+		block, err := aes.NewCipher(key[:p.keyLength()])
+		if err != nil {
+			return nil, nil, err
+		}
+		encrypted = make([]byte, len(data))
+		cipher.NewCFBEncrypter(block, aesIV(boots, engineTime,
+			params)).XORKeyStream(encrypted, data)
+		return encrypted, params, nil
+	}
+	return nil, nil, fmt.Errorf("invalid privacy protocol %d", p)
+}
+
+// decrypt decrypts a scoped PDU using the privacy parameters of its message.
+func (p PrivProtocol) decrypt(key []byte, boots, engineTime int, params,
+	data []byte) ([]byte, error) {
+
+	if p != PrivDES && !p.isAES() {
+		return nil, fmt.Errorf("invalid privacy protocol %d", p)
+	}
+	if len(params) != p.paramsLength() {
+		return nil, fmt.Errorf("invalid privacy parameters length %d",
+			len(params))
+	}
+	if p.isAES() {
+		block, err := aes.NewCipher(key[:p.keyLength()])
+		if err != nil {
+			return nil, err
+		}
+		decrypted := make([]byte, len(data))
+		cipher.NewCFBDecrypter(block, aesIV(boots, engineTime,
+			params)).XORKeyStream(decrypted, data)
+		return decrypted, nil
+	}
+
+	if len(data)%des.BlockSize != 0 {
+		return nil, fmt.Errorf("invalid encrypted PDU length %d", len(data))
+	}
+	block, err := des.NewCipher(key[:8])
+	if err != nil {
+		return nil, err
+	}
+	decrypted := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, desIV(key, params)).CryptBlocks(decrypted,
+		data)
+	return decrypted, nil
+}
+
+// desIV returns the initialization vector of CBC-DES: the last 8 bytes of the
+// key (the pre-IV) XOR the salt.
+func desIV(key, salt []byte) []byte {
+	iv := make([]byte, des.BlockSize)
+	for i := range iv {
+		iv[i] = key[8+i] ^ salt[i]
+	}
+	return iv
+}
+
+// aesIV returns the initialization vector of CFB-AES: the engine boots and
+// time of the authoritative engine followed by the salt.
+func aesIV(boots, engineTime int, salt []byte) []byte {
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint32(iv, uint32(boots))
+	binary.BigEndian.PutUint32(iv[4:], uint32(engineTime))
+	copy(iv[8:], salt)
+	return iv
+}
+
+// passwordToKey derives a key from a password hashing it repeated up to 1MB
+// (RFC 3414, section A.2).
+func passwordToKey(h func() hash.Hash, password []byte) []byte {
+	digest := h()
+	if len(password) == 0 {
+		return digest.Sum(nil)
+	}
+	const total = 1048576
+	buffer := make([]byte, 64)
+	for written, i := 0, 0; written < total; written += len(buffer) {
+		for j := range buffer {
+			buffer[j] = password[i%len(password)]
+			i++
+		}
+		digest.Write(buffer)
+	}
+	return digest.Sum(nil)
+}
+
+// localizeKey localizes a key to an engine ID: H(key || engineID || key).
+func localizeKey(h func() hash.Hash, key, engineID []byte) []byte {
+	digest := h()
+	digest.Write(key)
+	digest.Write(engineID)
+	digest.Write(key)
+	return digest.Sum(nil)
+}
+
+// UsmUser is a user of the User-based Security Model. Keys are localized to
+// the engine ID of the agent (RFC 3414, section 2.6).
+type UsmUser struct {
+	Name         string
+	AuthProtocol AuthProtocol
+	AuthKey      []byte
+	PrivProtocol PrivProtocol
+	PrivKey      []byte
+	// Access defines whether the user can write managed objects, when it
+	// has no VACM group.
+	Access Access
+}
+
+// securityLevel returns the message flags matching the keys of the user.
+func (u UsmUser) securityLevel() int {
+	level := 0
+	if u.AuthProtocol != NoAuth {
+		level |= FlagAuth
+	}
+	if u.PrivProtocol != NoPriv {
+		level |= FlagPriv
+	}
+	return level
+}
+
+// check verifies the protocols and keys of a user.
+func (u UsmUser) check() error {
+	if len(u.Name) == 0 || len(u.Name) > 32 {
+		return fmt.Errorf("invalid user name length %d", len(u.Name))
+	}
+	switch {
+	case u.AuthProtocol == NoAuth:
+		if u.PrivProtocol != NoPriv {
+			return fmt.Errorf("privacy requires authentication")
+		}
+	case u.AuthProtocol.hash() == nil:
+		return fmt.Errorf("invalid authentication protocol %d", u.AuthProtocol)
+	case len(u.AuthKey) != u.AuthProtocol.keyLength():
+		return fmt.Errorf("invalid authentication key length %d", len(u.AuthKey))
+	}
+	switch {
+	case u.PrivProtocol == NoPriv:
+	case u.PrivProtocol.keyLength() == 0:
+		return fmt.Errorf("invalid privacy protocol %d", u.PrivProtocol)
+	case len(u.PrivKey) < u.PrivProtocol.keyLength():
+		return fmt.Errorf("invalid privacy key length %d", len(u.PrivKey))
+	}
+	if u.Access != AccessReadOnly && u.Access != AccessReadWrite {
+		return fmt.Errorf("invalid access %d", u.Access)
+	}
+	return nil
+}
+
+// timeWindow is the number of seconds a message is accepted after it was
+// generated (RFC 3414, section 2.2.3).
+const timeWindow = 150
+
+// usmEngine keeps the SNMPv3 engine data and users of an agent.
+type usmEngine struct {
+	sync.Mutex
+	engineID EngineID
+	boots    int
+	start    time.Time
+	store    EngineStore
+	users    map[string]UsmUser
+	salt     uint64
+	stats    [usmStatsDecryptionErrors + 1]Counter32
+}
+
+// init sets a random engine ID and starts the engine time.
+func (e *usmEngine) init() {
+	e.engineID = NewRandomEngineID(0)
+	e.boots = 1
+	e.start = time.Now()
+	var salt [8]byte
+	rand.Read(salt[:])
+	e.salt = binary.BigEndian.Uint64(salt[:])
+}
+
+// clock returns the current engine boots and time.
+func (e *usmEngine) clock() (boots, engineTime int) {
+	e.Lock()
+	defer e.Unlock()
+	return e.boots, int(time.Since(e.start) / time.Second)
+}
+
+// nextSalt returns the salt of the next encrypted message.
+func (e *usmEngine) nextSalt() uint64 {
+	e.Lock()
+	defer e.Unlock()
+	e.salt++
+	return e.salt
+}
+
+// id returns the engine ID.
+func (e *usmEngine) id() EngineID {
+	e.Lock()
+	defer e.Unlock()
+	return e.engineID
+}
+
+// user returns a registered user.
+func (e *usmEngine) user(name string) (UsmUser, bool) {
+	e.Lock()
+	defer e.Unlock()
+	u, ok := e.users[name]
+	return u, ok
+}
+
+// authenticate checks the security parameters of a message, whose
+// authentication parameters are at authOffset, and returns its user.
+// Failures are returned as usmError values, along with the user for messages
+// not in the time window.
+func (e *usmEngine) authenticate(data []byte, m *V3Message,
+	params UsmSecurityParameters, authOffset int) (UsmUser, error) {
+
+	if !bytes.Equal(params.AuthoritativeEngineID, e.id()) {
+		return UsmUser{}, e.failure(usmStatsUnknownEngineIDs,
+			"unknown engine ID %x", params.AuthoritativeEngineID)
+	}
+	user, ok := e.user(params.UserName)
+	if !ok {
+		return UsmUser{}, e.failure(usmStatsUnknownUserNames,
+			"unknown user name %s", printableCommunity(params.UserName))
+	}
+	// Users can't go above the level of their keys; lower levels are
+	// accepted and left to the access control of the request
+	if level := m.Flags & (FlagAuth | FlagPriv); level&^user.securityLevel() != 0 {
+		return UsmUser{}, e.failure(usmStatsUnsupportedSecLevels,
+			"unsupported security level %d for user %s", level, user.Name)
+	}
+	if m.Flags&FlagAuth == 0 {
+		return user, nil
+	}
+	if len(params.AuthenticationParameters) != user.AuthProtocol.digestLength() ||
+		!user.AuthProtocol.verify(user.AuthKey, data, authOffset) {
+		return UsmUser{}, e.failure(usmStatsWrongDigests,
+			"wrong digest for user %s", user.Name)
+	}
+	boots, engineTime := e.clock()
+	if boots == maxEngineBoots || params.AuthoritativeEngineBoots != boots ||
+		params.AuthoritativeEngineTime < engineTime-timeWindow ||
+		params.AuthoritativeEngineTime > engineTime+timeWindow {
+		// The user is returned to authenticate the report
+		return user, e.failure(usmStatsNotInTimeWindows,
+			"message of user %s not in time window", user.Name)
+	}
+	return user, nil
+}
+
+// Last sub-identifiers of the usmStats counters (RFC 3414), incremented for
+// each kind of message failing the security checks.
+const (
+	usmStatsUnsupportedSecLevels = 1
+	usmStatsNotInTimeWindows     = 2
+	usmStatsUnknownUserNames     = 3
+	usmStatsUnknownEngineIDs     = 4
+	usmStatsWrongDigests         = 5
+	usmStatsDecryptionErrors     = 6
+)
+
+// usmStatsOid is the OID of the usmStats group.
+var usmStatsOid = asn1.Oid{1, 3, 6, 1, 6, 3, 15, 1, 1}
+
+// usmError is a message that failed the security checks, reported to the
+// manager with the value of the matching usmStats counter.
+type usmError struct {
+	stat    int
+	counter Counter32
+	message string
+}
+
+func (e usmError) Error() string {
+	return e.message
+}
+
+// variable returns the variable of the counter, as carried by reports.
+func (e usmError) variable() Variable {
+	oid := append(append(asn1.Oid{}, usmStatsOid...), uint(e.stat), 0)
+	return Variable{oid, e.counter}
+}
+
+// failure increments a usmStats counter and returns the error of the
+// failure.
+func (e *usmEngine) failure(stat int, format string,
+	values ...interface{}) usmError {
+
+	e.Lock()
+	defer e.Unlock()
+	e.stats[stat]++
+	return usmError{stat, e.stats[stat], fmt.Sprintf(format, values...)}
+}
+
+// maxEngineBoots is the value of snmpEngineBoots after which the engine
+// doesn't accept authenticated messages.
+const maxEngineBoots = 2147483647
+
+// AddUsmUser registers a SNMPv3 user, replacing any user with the same name.
+// Requests of the user can use any security level its protocols support; VACM
+// groups decide what each level gives access to. Without a group, only
+// requests at the level of all its keys get the access given by Access.
+// Privacy keys shorter than needed by PrivAES192, PrivAES256 and their Reeder
+// variants are extended with the hash of the authentication protocol.
+func (a *Agent) AddUsmUser(user UsmUser) error {
+	user.AuthKey = append([]byte{}, user.AuthKey...)
+	user.PrivKey = append([]byte{}, user.PrivKey...)
+	user.PrivKey = user.PrivProtocol.extendKey(user.PrivKey, user.AuthProtocol,
+		a.usm.id())
+	if err := user.check(); err != nil {
+		return err
+	}
+	a.usm.Lock()
+	defer a.usm.Unlock()
+	if a.usm.users == nil {
+		a.usm.users = make(map[string]UsmUser)
+	}
+	a.usm.users[user.Name] = user
+	return nil
+}
+
+// RemoveUsmUser unregisters a SNMPv3 user.
+func (a *Agent) RemoveUsmUser(name string) error {
+	a.usm.Lock()
+	defer a.usm.Unlock()
+	if _, ok := a.usm.users[name]; !ok {
+		return fmt.Errorf("unknown user %s", name)
+	}
+	delete(a.usm.users, name)
+	return nil
+}
+
+// AddUsmUserPasswords works like AddUsmUser, with the keys of user derived
+// from passwords and localized to the engine ID of the agent. The privacy
+// password is ignored for users without privacy.
+func (a *Agent) AddUsmUserPasswords(user UsmUser, authPassword,
+	privPassword string) (err error) {
+
+	engineID := a.usm.id()
+	if user.AuthKey, err = LocalizeKey(user.AuthProtocol, authPassword,
+		engineID); err != nil {
+		return err
+	}
+	user.PrivKey = nil
+	if user.PrivProtocol != NoPriv {
+		// Privacy keys use the hash of the authentication protocol
+		if user.PrivKey, err = LocalizeKey(user.AuthProtocol, privPassword,
+			engineID); err != nil {
+			return err
+		}
+	}
+	return a.AddUsmUser(user)
+}
+
+// CloneUsmUser registers a user with the protocols, keys and access of the
+// user from, like usmUserCloneFrom. Its keys are then usually changed with
+// ChangeUsmUserKeys.
+func (a *Agent) CloneUsmUser(name, from string) error {
+	user, ok := a.usm.user(from)
+	if !ok {
+		return fmt.Errorf("unknown user %s", from)
+	}
+	user.Name = name
+	return a.AddUsmUser(user)
+}
+
+// ChangeUsmUserKeys changes the keys of a user with values of the KeyChange
+// type (RFC 3414, section 5), as generated by NewKeyChange with the current
+// keys of the user. A nil value keeps the key.
+func (a *Agent) ChangeUsmUserKeys(name string, authKeyChange,
+	privKeyChange []byte) (err error) {
+
+	a.usm.Lock()
+	defer a.usm.Unlock()
+	user, ok := a.usm.users[name]
+	if !ok {
+		return fmt.Errorf("unknown user %s", name)
+	}
+	h := user.AuthProtocol.hash()
+	if h == nil {
+		return fmt.Errorf("user %s has no keys", name)
+	}
+	if authKeyChange != nil {
+		if user.AuthKey, err = applyKeyChange(h, user.AuthKey,
+			authKeyChange); err != nil {
+			return err
+		}
+	}
+	if privKeyChange != nil && user.PrivProtocol != NoPriv {
+		if user.PrivKey, err = applyKeyChange(h, user.PrivKey,
+			privKeyChange); err != nil {
+			return err
+		}
+	}
+	a.usm.users[name] = user
+	return nil
+}
+
+// LocalizeKey derives a key from a password with the hash of an
+// authentication protocol and localizes it to an engine ID (RFC 3414,
+// section A.2). Passwords must have at least 8 characters.
+func LocalizeKey(auth AuthProtocol, password string,
+	engineID EngineID) ([]byte, error) {
+
+	h := auth.hash()
+	if h == nil {
+		return nil, fmt.Errorf("invalid authentication protocol %d", auth)
+	}
+	if len(password) < 8 {
+		return nil, fmt.Errorf("password shorter than 8 characters")
+	}
+	return localizeKey(h, passwordToKey(h, []byte(password)), engineID), nil
+}
+
+// NewKeyChange returns the KeyChange value that changes oldKey to newKey,
+// both of the same length, with the hash of an authentication protocol. The
+// random bytes, as many as the key, must be different for each change.
+func NewKeyChange(auth AuthProtocol, oldKey, newKey,
+	random []byte) ([]byte, error) {
+
+	h := auth.hash()
+	if h == nil {
+		return nil, fmt.Errorf("invalid authentication protocol %d", auth)
+	}
+	if len(newKey) != len(oldKey) || len(random) != len(oldKey) {
+		return nil, fmt.Errorf("keys and random bytes must have the same length")
+	}
+	delta := keyChangeDelta(h, oldKey, random, newKey)
+	return append(append([]byte{}, random...), delta...), nil
+}
+
+// applyKeyChange returns the key changed by a KeyChange value.
+func applyKeyChange(h func() hash.Hash, oldKey, value []byte) ([]byte, error) {
+	if len(value) != 2*len(oldKey) {
+		return nil, fmt.Errorf("invalid key change length %d", len(value))
+	}
+	random, delta := value[:len(oldKey)], value[len(oldKey):]
+	return keyChangeDelta(h, oldKey, random, delta), nil
+}
+
+// keyChangeDelta XORs data with the digests chained from the old key and the
+// random bytes. Applied to the new key it gives the delta of a KeyChange
+// value, and applied to the delta it gives the new key back.
+func keyChangeDelta(h func() hash.Hash, oldKey, random, data []byte) []byte {
+	result := make([]byte, len(data))
+	temp := oldKey
+	for i := 0; i < len(data); {
+		digest := h()
+		digest.Write(temp)
+		digest.Write(random)
+		temp = digest.Sum(nil)
+		for j := 0; j < len(temp) && i < len(data); j++ {
+			result[i] = temp[j] ^ data[i]
+			i++
+		}
+	}
+	return result
+}
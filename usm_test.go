@@ -0,0 +1,285 @@
+package snmp
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"hash"
+	"testing"
+)
+
+// newUsmUserForTest returns a user with keys of the given protocols.
+func newUsmUserForTest(name string, auth AuthProtocol, priv PrivProtocol) UsmUser {
+	user := UsmUser{Name: name, AuthProtocol: auth, PrivProtocol: priv,
+		Access: AccessReadOnly}
+	user.AuthKey = bytes.Repeat([]byte{0x11}, auth.keyLength())
+	if priv != NoPriv {
+		user.PrivKey = bytes.Repeat([]byte{0x22}, priv.keyLength())
+	}
+	return user
+}
+
+func TestAddUsmUser(t *testing.T) {
+	agent := NewAgent()
+	valid := []UsmUser{
+		newUsmUserForTest("none", NoAuth, NoPriv),
+		newUsmUserForTest("md5", AuthMD5, NoPriv),
+		newUsmUserForTest("sha", AuthSHA, PrivDES),
+		newUsmUserForTest("sha512", AuthSHA512, PrivDES),
+		newUsmUserForTest("aes", AuthMD5, PrivAES256),
+	}
+	for _, user := range valid {
+		if err := agent.AddUsmUser(user); err != nil {
+			t.Fatalf("User %s refused: %s\n", user.Name, err)
+		}
+	}
+
+	shortKey := newUsmUserForTest("short", AuthSHA256, NoPriv)
+	shortKey.AuthKey = shortKey.AuthKey[:20]
+	shortPrivKey := newUsmUserForTest("short", AuthMD5, PrivDES)
+	shortPrivKey.PrivKey = shortPrivKey.PrivKey[:8]
+	invalid := []UsmUser{
+		newUsmUserForTest("", AuthMD5, NoPriv),
+		newUsmUserForTest("priv", AuthMD5, PrivProtocol(10)),
+		newUsmUserForTest("priv", NoAuth, PrivDES),
+		newUsmUserForTest("unknown", AuthProtocol(10), NoPriv),
+		shortKey,
+		shortPrivKey,
+	}
+	for _, user := range invalid {
+		if agent.AddUsmUser(user) == nil {
+			t.Fatalf("Invalid user accepted: %#v\n", user)
+		}
+	}
+}
+
+func TestAuthProtocols(t *testing.T) {
+	protocols := []AuthProtocol{AuthMD5, AuthSHA, AuthSHA224, AuthSHA256,
+		AuthSHA384, AuthSHA512}
+	for _, p := range protocols {
+		key := newUsmUserForTest("user", p, NoPriv).AuthKey
+		message := bytes.Repeat([]byte("message "), 10)
+		offset := 8
+		p.authenticate(key, message, offset)
+		if !p.verify(key, message, offset) {
+			t.Fatalf("Digest of protocol %d not verified\n", p)
+		}
+		message[0]++
+		if p.verify(key, message, offset) {
+			t.Fatalf("Wrong digest of protocol %d verified\n", p)
+		}
+	}
+}
+
+func TestPrivDES(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	plaintext := []byte("scoped PDU")
+	encrypted, params, err := PrivDES.encrypt(key, 1, 10, 7, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(encrypted)%8 != 0 || len(params) != 8 {
+		t.Fatalf("Wrong lengths: %d encrypted bytes, %d bytes salt\n",
+			len(encrypted), len(params))
+	}
+	decrypted, err := PrivDES.decrypt(key, 1, 10, params, encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(decrypted, plaintext) {
+		t.Fatalf("Wrong decrypted data %q\n", decrypted)
+	}
+
+	// Salts must differ for each message
+	other, _, err := PrivDES.encrypt(key, 1, 10, 8, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(other, encrypted) {
+		t.Fatal("Different salts should give different encrypted data.")
+	}
+}
+
+func TestPrivAES(t *testing.T) {
+	protocols := []PrivProtocol{PrivAES128, PrivAES192, PrivAES256,
+		PrivAES192Reeder, PrivAES256Reeder}
+	plaintext := []byte("scoped PDU")
+	for _, p := range protocols {
+		key := p.extendKey([]byte("0123456789abcdef"), AuthSHA,
+			[]byte{0x80, 0, 0, 0, 4, 1})
+		if len(key) != p.keyLength() {
+			t.Fatalf("Wrong key length %d for protocol %d\n", len(key), p)
+		}
+		encrypted, params, err := p.encrypt(key, 1, 10, 7, plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(encrypted) != len(plaintext) || len(params) != 8 {
+			t.Fatalf("Wrong lengths: %d encrypted bytes, %d bytes salt\n",
+				len(encrypted), len(params))
+		}
+		decrypted, err := p.decrypt(key, 1, 10, params, encrypted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Fatalf("Wrong decrypted data %q\n", decrypted)
+		}
+		// The engine time is part of the IV
+		if decrypted, _ = p.decrypt(key, 1, 11, params, encrypted); bytes.Equal(
+			decrypted, plaintext) {
+			t.Fatal("Wrong engine time should not decrypt the PDU.")
+		}
+	}
+}
+
+func TestExtendKey(t *testing.T) {
+	key := []byte("0123456789abcdefghij")
+	engineID := []byte{0x80, 0, 0, 0, 4, 1}
+
+	// Blumenthal: Kul || H(Kul)
+	h := sha1.New()
+	h.Write(key)
+	expected := h.Sum(append([]byte{}, key...))[:32]
+	if extended := PrivAES256.extendKey(key, AuthSHA, engineID); !bytes.Equal(
+		extended, expected) {
+		t.Fatalf("Wrong extended key %x\n", extended)
+	}
+
+	// Reeder: Kul || localized key of Kul used as password
+	expected = append(append([]byte{}, key...), localizeKey(sha1.New,
+		passwordToKey(sha1.New, key), engineID)...)[:32]
+	if extended := PrivAES256Reeder.extendKey(key, AuthSHA, engineID); !bytes.Equal(
+		extended, expected) {
+		t.Fatalf("Wrong extended key %x\n", extended)
+	}
+
+	// Long enough keys are kept
+	if extended := PrivAES128.extendKey(key, AuthSHA, engineID); !bytes.Equal(
+		extended, key) {
+		t.Fatalf("Key should not be extended: %x\n", extended)
+	}
+}
+
+func TestPasswordToKey(t *testing.T) {
+	// Examples of RFC 3414, section A.3
+	engineID := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2}
+	tests := []struct {
+		h         func() hash.Hash
+		key       string
+		localized string
+	}{
+		{md5.New, "9faf3283884e92834ebc9847d8edd963",
+			"526f5eed9fcce26f8964c2930787d82b"},
+		{sha1.New, "9fb5cc0381497b3793528939ff788d5d79145211",
+			"6695febc9288e36282235fc7151f128497b38f3f"},
+	}
+	for _, test := range tests {
+		key := passwordToKey(test.h, []byte("maplesyrup"))
+		if hex.EncodeToString(key) != test.key {
+			t.Fatalf("Wrong key %x, expected %s\n", key, test.key)
+		}
+		localized := localizeKey(test.h, key, engineID)
+		if hex.EncodeToString(localized) != test.localized {
+			t.Fatalf("Wrong localized key %x, expected %s\n", localized,
+				test.localized)
+		}
+	}
+}
+
+func TestLocalizeKey(t *testing.T) {
+	engineID := EngineID{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2}
+	key, err := LocalizeKey(AuthMD5, "maplesyrup", engineID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "526f5eed9fcce26f8964c2930787d82b"; hex.EncodeToString(
+		key) != expected {
+		t.Fatalf("Wrong localized key %x, expected %s\n", key, expected)
+	}
+	if _, err := LocalizeKey(AuthMD5, "short", engineID); err == nil {
+		t.Fatal("Short passwords should be refused.")
+	}
+	if _, err := LocalizeKey(NoAuth, "maplesyrup", engineID); err == nil {
+		t.Fatal("Keys need an authentication protocol.")
+	}
+}
+
+func TestAddUsmUserPasswords(t *testing.T) {
+	agent := NewAgent()
+	err := agent.AddUsmUserPasswords(UsmUser{Name: "user",
+		AuthProtocol: AuthSHA256, PrivProtocol: PrivAES256,
+		Access: AccessReadOnly}, "authpassword", "privpassword")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, _ := agent.usm.user("user")
+	if len(user.AuthKey) != 32 || len(user.PrivKey) != 32 ||
+		bytes.Equal(user.AuthKey, user.PrivKey) {
+		t.Fatalf("Wrong keys: %x, %x\n", user.AuthKey, user.PrivKey)
+	}
+	expected, _ := LocalizeKey(AuthSHA256, "authpassword", agent.EngineID())
+	if !bytes.Equal(user.AuthKey, expected) {
+		t.Fatalf("Wrong authentication key %x\n", user.AuthKey)
+	}
+}
+
+func TestCloneUsmUser(t *testing.T) {
+	agent := NewAgent()
+	agent.AddUsmUser(newUsmUserForTest("template", AuthSHA, PrivDES))
+	if err := agent.CloneUsmUser("user", "template"); err != nil {
+		t.Fatal(err)
+	}
+	user, ok := agent.usm.user("user")
+	if !ok || user.Name != "user" || user.AuthProtocol != AuthSHA ||
+		user.PrivProtocol != PrivDES {
+		t.Fatalf("Wrong clone: %#v\n", user)
+	}
+	if err := agent.CloneUsmUser("other", "unknown"); err == nil {
+		t.Fatal("Unknown users can't be cloned.")
+	}
+
+	if err := agent.RemoveUsmUser("user"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := agent.usm.user("user"); ok {
+		t.Fatal("User not removed.")
+	}
+	if err := agent.RemoveUsmUser("user"); err == nil {
+		t.Fatal("Unknown users can't be removed.")
+	}
+}
+
+func TestKeyChange(t *testing.T) {
+	agent := NewAgent()
+	old := newUsmUserForTest("user", AuthMD5, PrivDES)
+	agent.AddUsmUser(old)
+
+	newAuthKey := bytes.Repeat([]byte{0x44}, 16)
+	newPrivKey := bytes.Repeat([]byte{0x55}, 16)
+	random := bytes.Repeat([]byte{0x66}, 16)
+	authChange, err := NewKeyChange(AuthMD5, old.AuthKey, newAuthKey, random)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privChange, err := NewKeyChange(AuthMD5, old.PrivKey, newPrivKey, random)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(authChange) != 32 || bytes.Contains(authChange, newAuthKey) {
+		t.Fatalf("Wrong key change %x\n", authChange)
+	}
+	if err := agent.ChangeUsmUserKeys("user", authChange, privChange); err != nil {
+		t.Fatal(err)
+	}
+	user, _ := agent.usm.user("user")
+	if !bytes.Equal(user.AuthKey, newAuthKey) ||
+		!bytes.Equal(user.PrivKey, newPrivKey) {
+		t.Fatalf("Wrong changed keys: %x, %x\n", user.AuthKey, user.PrivKey)
+	}
+
+	if err := agent.ChangeUsmUserKeys("user", authChange[:20], nil); err == nil {
+		t.Fatal("Invalid key changes should be refused.")
+	}
+}
@@ -0,0 +1,594 @@
+package snmp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+// Bits of the msgFlags field of SNMPv3 messages.
+const (
+	FlagAuth       = 0x01
+	FlagPriv       = 0x02
+	FlagReportable = 0x04
+)
+
+// SecurityModelUsm identifies the User-based Security Model (RFC 3414).
+const SecurityModelUsm = 3
+
+// V3Message is a SNMPv3 message (RFC 3412). The scoped PDU is either in
+// plaintext in ScopedPdu or, for messages with FlagPriv, encrypted in
+// EncryptedPdu.
+type V3Message struct {
+	MessageID          int
+	MaxSize            int
+	Flags              int
+	SecurityModel      int
+	SecurityParameters []byte
+	ScopedPdu          ScopedPdu
+	EncryptedPdu       []byte
+}
+
+// ScopedPdu is a PDU with the context in which it's processed.
+type ScopedPdu struct {
+	ContextEngineID []byte
+	ContextName     string
+	Pdu             interface{}
+}
+
+// UsmSecurityParameters are the security parameters of the User-based
+// Security Model, carried by SNMPv3 messages in encoded form.
+type UsmSecurityParameters struct {
+	AuthoritativeEngineID    []byte
+	AuthoritativeEngineBoots int
+	AuthoritativeEngineTime  int
+	UserName                 string
+	AuthenticationParameters []byte
+	PrivacyParameters        []byte
+}
+
+// EncodeV3Message encodes a SNMPv3 message.
+func EncodeV3Message(m *V3Message) ([]byte, error) {
+	data, _, err := encodeV3Message(Asn1Context(), m)
+	return data, err
+}
+
+// encodeV3Message encodes a SNMPv3 message using ctx for its PDU and returns
+// the position of the security parameters in the encoded message.
+func encodeV3Message(ctx *asn1.Context, m *V3Message) (data []byte,
+	paramsOffset int, err error) {
+
+	var pdu []byte
+	if m.Flags&FlagPriv != 0 {
+		pdu = encodeOctetString(m.EncryptedPdu)
+	} else if pdu, err = encodeScopedPdu(ctx, m.ScopedPdu); err != nil {
+		return nil, 0, err
+	}
+	version := encodeInteger(Version3)
+	header := encodeSequence(
+		encodeInteger(int64(m.MessageID)),
+		encodeInteger(int64(m.MaxSize)),
+		encodeOctetString([]byte{byte(m.Flags)}),
+		encodeInteger(int64(m.SecurityModel)),
+	)
+	params := encodeOctetString(m.SecurityParameters)
+	data = encodeSequence(version, header, params, pdu)
+
+	// The parameters are at the end of their OCTET STRING
+	content := len(version) + len(header) + len(params) + len(pdu)
+	paramsOffset = len(data) - content + len(version) + len(header) +
+		len(params) - len(m.SecurityParameters)
+	return data, paramsOffset, nil
+}
+
+// DecodeV3Message decodes a SNMPv3 message. Trailing bytes after the message
+// are considered an error.
+func DecodeV3Message(data []byte) (*V3Message, error) {
+	m, rest, err := decodeV3Message(Asn1Context(), data)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("%d remaining bytes", len(rest))
+	}
+	return m, nil
+}
+
+// decodeV3Message decodes a SNMPv3 message returning the bytes after it.
+func decodeV3Message(ctx *asn1.Context, data []byte) (m *V3Message,
+	rest []byte, err error) {
+
+	message, rest, err := readUniversal(data, tagSequence, "message")
+	if err != nil {
+		return nil, nil, err
+	}
+	version, content, err := readInteger(message.content, "version")
+	if err != nil {
+		return nil, nil, err
+	}
+	if version != Version3 {
+		return nil, nil, fmt.Errorf("invalid SNMPv3 version %d", version)
+	}
+
+	m = &V3Message{}
+	global, content, err := readUniversal(content, tagSequence, "header")
+	if err != nil {
+		return nil, nil, err
+	}
+	fields := global.content
+	var n int64
+	if n, fields, err = readInteger(fields, "message ID"); err != nil {
+		return nil, nil, err
+	}
+	m.MessageID = int(n)
+	if n, fields, err = readInteger(fields, "maximum size"); err != nil {
+		return nil, nil, err
+	}
+	m.MaxSize = int(n)
+	flags, fields, err := readOctetString(fields, "flags")
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(flags) != 1 {
+		return nil, nil, fmt.Errorf("invalid flags length %d", len(flags))
+	}
+	m.Flags = int(flags[0])
+	if n, _, err = readInteger(fields, "security model"); err != nil {
+		return nil, nil, err
+	}
+	m.SecurityModel = int(n)
+
+	if m.SecurityParameters, content, err = readOctetString(content,
+		"security parameters"); err != nil {
+		return nil, nil, err
+	}
+	if m.Flags&FlagPriv != 0 {
+		m.EncryptedPdu, _, err = readOctetString(content, "encrypted PDU")
+	} else {
+		m.ScopedPdu, _, err = decodeScopedPdu(ctx, content)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return m, rest, nil
+}
+
+// EncodeScopedPdu encodes a scoped PDU.
+func EncodeScopedPdu(scoped ScopedPdu) ([]byte, error) {
+	return encodeScopedPdu(Asn1Context(), scoped)
+}
+
+// encodeScopedPdu encodes a scoped PDU using ctx for its PDU.
+func encodeScopedPdu(ctx *asn1.Context, scoped ScopedPdu) ([]byte, error) {
+	pdu, err := ctx.EncodeWithOptions(scoped.Pdu, "choice:pdu")
+	if err != nil {
+		return nil, err
+	}
+	return encodeSequence(
+		encodeOctetString(scoped.ContextEngineID),
+		encodeOctetString([]byte(scoped.ContextName)),
+		pdu,
+	), nil
+}
+
+// DecodeScopedPdu decodes a scoped PDU, like the decrypted PDU of a message.
+// Trailing bytes, like the padding of encryption, are ignored.
+func DecodeScopedPdu(data []byte) (ScopedPdu, error) {
+	scoped, _, err := decodeScopedPdu(Asn1Context(), data)
+	return scoped, err
+}
+
+// decodeScopedPdu decodes a scoped PDU using ctx for its PDU and returns the
+// bytes after it, like the padding of a decrypted PDU.
+func decodeScopedPdu(ctx *asn1.Context, data []byte) (scoped ScopedPdu,
+	rest []byte, err error) {
+
+	sequence, rest, err := readUniversal(data, tagSequence, "scoped PDU")
+	if err != nil {
+		return
+	}
+	content := sequence.content
+	if scoped.ContextEngineID, content, err = readOctetString(content,
+		"context engine ID"); err != nil {
+		return
+	}
+	name, content, err := readOctetString(content, "context name")
+	if err != nil {
+		return
+	}
+	scoped.ContextName = string(name)
+	remaining, err := ctx.DecodeWithOptions(content, &scoped.Pdu,
+		"choice:pdu")
+	if err != nil {
+		return
+	}
+	if len(remaining) > 0 {
+		err = fmt.Errorf("%d remaining bytes in scoped PDU", len(remaining))
+	}
+	return
+}
+
+// Encode encodes the security parameters as carried by messages.
+func (p UsmSecurityParameters) Encode() []byte {
+	return encodeSequence(
+		encodeOctetString(p.AuthoritativeEngineID),
+		encodeInteger(int64(p.AuthoritativeEngineBoots)),
+		encodeInteger(int64(p.AuthoritativeEngineTime)),
+		encodeOctetString([]byte(p.UserName)),
+		encodeOctetString(p.AuthenticationParameters),
+		encodeOctetString(p.PrivacyParameters),
+	)
+}
+
+// DecodeUsmSecurityParameters decodes the security parameters of a message
+// using the User-based Security Model.
+func DecodeUsmSecurityParameters(data []byte) (UsmSecurityParameters, error) {
+	p, _, err := decodeUsmSecurityParameters(data)
+	return p, err
+}
+
+// decodeUsmSecurityParameters decodes the security parameters and returns
+// the position of the authentication parameters in data.
+func decodeUsmSecurityParameters(data []byte) (p UsmSecurityParameters,
+	authOffset int, err error) {
+
+	sequence, _, err := readUniversal(data, tagSequence, "security parameters")
+	if err != nil {
+		return
+	}
+	content := sequence.content
+	if p.AuthoritativeEngineID, content, err = readOctetString(content,
+		"engine ID"); err != nil {
+		return
+	}
+	var n int64
+	if n, content, err = readInteger(content, "engine boots"); err != nil {
+		return
+	}
+	p.AuthoritativeEngineBoots = int(n)
+	if n, content, err = readInteger(content, "engine time"); err != nil {
+		return
+	}
+	p.AuthoritativeEngineTime = int(n)
+	user, content, err := readOctetString(content, "user name")
+	if err != nil {
+		return
+	}
+	p.UserName = string(user)
+	if p.AuthenticationParameters, content, err = readOctetString(content,
+		"authentication parameters"); err != nil {
+		return
+	}
+	authOffset = offsetIn(data, p.AuthenticationParameters)
+	p.PrivacyParameters, _, err = readOctetString(content,
+		"privacy parameters")
+	return
+}
+
+// maxV3MessageSize is the msgMaxSize of the messages sent by the agent, the
+// largest UDP payload.
+const maxV3MessageSize = 65507
+
+// minV3MessageSize is the smallest msgMaxSize accepted (RFC 3412).
+const minV3MessageSize = 484
+
+// isV3Message checks if a datagram is a SNMPv3 message, by its version.
+func isV3Message(data []byte) bool {
+	message, _, err := readUniversal(data, tagSequence, "message")
+	if err != nil {
+		return false
+	}
+	version, _, err := readInteger(message.content, "version")
+	return err == nil && version == Version3
+}
+
+// v3Request is a SNMPv3 request that passed the security checks, or the
+// report of a request that failed them.
+type v3Request struct {
+	message *V3Message
+	user    UsmUser
+	scoped  ScopedPdu
+	report  []byte
+}
+
+// decodeV3Datagram decodes and authenticates a SNMPv3 message, decrypting its
+// scoped PDU if needed. Invalid messages are reported with Drop errors, or
+// answered with a report if they failed the security checks.
+func (a *Agent) decodeV3Datagram(data []byte) (r *v3Request, err error) {
+	defer func() {
+		if err != nil {
+			a.logf(LogError, "%s\n", err)
+		}
+	}()
+	if !a.supportsVersion(Version3) {
+		a.snmp.inc(&a.snmp.inBadVersions)
+		return nil, processErrorf(Unsupported, "invalid SNMP version %d",
+			Version3)
+	}
+
+	m, rest, err := decodeV3Message(a.ctx, data)
+	if err != nil {
+		a.snmp.inc(&a.snmp.inASNParseErrs)
+		return nil, processErrorf(Drop, "invalid message: %s", err)
+	}
+	if len(rest) > 0 && a.trailingBytes {
+		a.logf(LogDebug, "ignoring %d trailing bytes\n", len(rest))
+	} else if len(rest) > 0 {
+		return nil, processErrorf(Drop, "invalid message: %d remaining bytes",
+			len(rest))
+	}
+	if m.MaxSize < minV3MessageSize {
+		return nil, processErrorf(Drop, "invalid message: maximum size %d",
+			m.MaxSize)
+	}
+	if m.SecurityModel != SecurityModelUsm {
+		return nil, processErrorf(Drop, "unsupported security model %d",
+			m.SecurityModel)
+	}
+	if m.Flags&(FlagAuth|FlagPriv) == FlagPriv {
+		return nil, processErrorf(Drop,
+			"invalid message: privacy without authentication")
+	}
+
+	params, authOffset, err := decodeUsmSecurityParameters(m.SecurityParameters)
+	if err != nil {
+		a.snmp.inc(&a.snmp.inASNParseErrs)
+		return nil, processErrorf(Drop, "invalid message: %s", err)
+	}
+	authOffset += offsetIn(data, m.SecurityParameters)
+	user, err := a.usm.authenticate(data, m, params, authOffset)
+	if e, ok := err.(usmError); ok {
+		return a.usmReport(m, params.UserName, user, e)
+	}
+
+	scoped := m.ScopedPdu
+	if m.Flags&FlagPriv != 0 {
+		plaintext, err := user.PrivProtocol.decrypt(user.PrivKey,
+			params.AuthoritativeEngineBoots, params.AuthoritativeEngineTime,
+			params.PrivacyParameters, m.EncryptedPdu)
+		if err == nil {
+			scoped, _, err = decodeScopedPdu(a.ctx, plaintext)
+		}
+		if err != nil {
+			return a.usmReport(m, params.UserName, UsmUser{},
+				a.usm.failure(usmStatsDecryptionErrors, "decryption failed: %s",
+					err))
+		}
+	}
+	if a.context(scoped.ContextName) == nil {
+		return a.contextReport(m, user, scoped)
+	}
+	if len(scoped.ContextEngineID) > 0 &&
+		!bytes.Equal(scoped.ContextEngineID, a.usm.id()) {
+		return nil, processErrorf(Drop, "unknown context engine ID %x",
+			scoped.ContextEngineID)
+	}
+	return &v3Request{message: m, user: user, scoped: scoped}, nil
+}
+
+// usmReport handles a message that failed the security checks of the
+// User-based Security Model. Reportable messages get a Report PDU with the
+// counter of the failure, so that managers can discover the engine ID and
+// synchronize their clock with the agent. Reports are authenticated only for
+// messages not in the time window, whose user is given; others are sent
+// without authentication to userName. Other messages are dropped.
+func (a *Agent) usmReport(m *V3Message, userName string, user UsmUser,
+	failure usmError) (*v3Request, error) {
+
+	if m.Flags&FlagReportable == 0 {
+		return nil, processErrorf(Drop, "authentication failed: %s", failure)
+	}
+	a.logf(LogInfo, "authentication failed, sending report: %s\n", failure)
+
+	flags := 0
+	if failure.stat == usmStatsNotInTimeWindows {
+		flags = FlagAuth
+	} else {
+		user = UsmUser{Name: userName}
+	}
+	// The request ID is unknown for encrypted PDUs
+	return a.report(m, flags, user, m.ScopedPdu, failure.variable())
+}
+
+// snmpUnknownContextsOid is the OID of the snmpUnknownContexts counter
+// (RFC 3413).
+var snmpUnknownContextsOid = asn1.Oid{1, 3, 6, 1, 6, 3, 12, 1, 5, 0}
+
+// contextReport handles an authenticated message for an unknown context,
+// counted in snmpUnknownContexts. Reportable messages get a Report PDU with
+// the counter, at the security level of the message; others are dropped.
+func (a *Agent) contextReport(m *V3Message, user UsmUser,
+	scoped ScopedPdu) (*v3Request, error) {
+
+	counter := Counter32(a.snmp.inc(&a.snmp.unknownContexts))
+	if m.Flags&FlagReportable == 0 {
+		return nil, processErrorf(Drop, "unknown context %s",
+			printableCommunity(scoped.ContextName))
+	}
+	a.logf(LogInfo, "unknown context %s, sending report\n",
+		printableCommunity(scoped.ContextName))
+	return a.report(m, m.Flags&(FlagAuth|FlagPriv), user, scoped,
+		Variable{snmpUnknownContextsOid, counter})
+}
+
+// report encodes the Report PDU answering the scoped PDU of a message with
+// variable, as given by flags.
+func (a *Agent) report(m *V3Message, flags int, user UsmUser,
+	scoped ScopedPdu, variable Variable) (*v3Request, error) {
+
+	report := ReportPdu{
+		Identifier: pduIdentifier(scoped.Pdu),
+		Variables:  []Variable{variable},
+	}
+	data, err := a.encodeV3(m.MessageID, flags, user, ScopedPdu{
+		ContextEngineID: a.usm.id(),
+		ContextName:     scoped.ContextName,
+		Pdu:             report,
+	})
+	if err != nil {
+		return nil, processErrorf(Internal, "failed to encode report: %s", err)
+	}
+	return &v3Request{message: m, report: data}, nil
+}
+
+// pduIdentifier returns the request ID of a PDU, 0 if it has none.
+func pduIdentifier(pdu interface{}) int {
+	switch pdu := pdu.(type) {
+	case GetRequestPdu:
+		return pdu.Identifier
+	case GetNextRequestPdu:
+		return pdu.Identifier
+	case SetRequestPdu:
+		return pdu.Identifier
+	case GetBulkRequestPdu:
+		return pdu.Identifier
+	case InformRequestPdu:
+		return pdu.Identifier
+	}
+	return 0
+}
+
+// processV3 handles an authenticated SNMPv3 request and encodes its response.
+// Requests are processed like SNMPv2c ones, with the user name in place of
+// the community, by the agent of their context.
+func (a *Agent) processV3(ctx context.Context, r *v3Request) ([]byte, error) {
+	if r.report != nil {
+		a.delay(ctx)
+		return r.report, nil
+	}
+	request := &Message{Version: Version3, Community: r.user.Name,
+		Pdu: r.scoped.Pdu}
+	maxSize := r.message.MaxSize
+	if a.maxSize > 0 && a.maxSize < maxSize {
+		maxSize = a.maxSize
+	}
+	maxSize -= a.v3Overhead(r, request)
+
+	// The managed objects of named contexts are in their own agent
+	level := r.message.Flags & (FlagAuth | FlagPriv)
+	target := a.context(r.scoped.ContextName)
+	response, err := target.process(ctx, request, maxSize,
+		func(*Message) (requestViews, error) {
+			return a.userViews(r.user, r.scoped.ContextName, level), nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	scoped := ScopedPdu{ContextEngineID: a.usm.id(),
+		ContextName: r.scoped.ContextName, Pdu: response.Pdu}
+	data, err := a.encodeV3(r.message.MessageID,
+		r.message.Flags&^FlagReportable, r.user, scoped)
+	if err != nil {
+		err = processErrorf(Internal, "failed to encode response: %s", err)
+		a.logf(LogError, "%s\n", err)
+		return nil, err
+	}
+	a.delay(ctx)
+	return data, nil
+}
+
+// userViews returns the views of a user for requests to a context with a
+// security level. Users without a VACM group access the whole MIB as given by
+// their Access, only at the level of their keys; lower levels get no access.
+func (a *Agent) userViews(user UsmUser, context string, level int) requestViews {
+	views, ok := a.vacm.lookup(SecurityModelUsm, user.Name, context, level)
+	if !ok {
+		if level != user.securityLevel() {
+			return requestViews{}
+		}
+		views = requestViews{read: fullView, notify: fullView}
+		if user.Access == AccessReadWrite {
+			views.write = fullView
+		}
+	}
+	return views
+}
+
+// v3Overhead returns how many bytes the SNMPv3 encoding of a response adds to
+// the size estimated for request, a community based message with the user
+// name as community.
+func (a *Agent) v3Overhead(r *v3Request, request *Message) int {
+	empty := GetResponsePdu{Variables: []Variable{}}
+	boots, engineTime := a.usm.clock()
+	params := UsmSecurityParameters{
+		AuthoritativeEngineID:    a.usm.id(),
+		AuthoritativeEngineBoots: boots,
+		AuthoritativeEngineTime:  engineTime,
+		UserName:                 r.user.Name,
+		AuthenticationParameters: make([]byte, r.user.AuthProtocol.digestLength()),
+	}
+	if r.user.PrivProtocol != NoPriv {
+		params.PrivacyParameters = make([]byte, r.user.PrivProtocol.paramsLength())
+	}
+	data, _, err := encodeV3Message(a.ctx, &V3Message{
+		MessageID:          r.message.MessageID,
+		MaxSize:            maxV3MessageSize,
+		Flags:              r.message.Flags &^ FlagPriv,
+		SecurityModel:      SecurityModelUsm,
+		SecurityParameters: params.Encode(),
+		ScopedPdu: ScopedPdu{ContextEngineID: params.AuthoritativeEngineID,
+			ContextName: r.scoped.ContextName, Pdu: empty},
+	})
+	if err != nil {
+		return 0
+	}
+	estimated := estimateMessageSize(&Message{Version: request.Version,
+		Community: request.Community, Pdu: empty})
+	return len(data) - estimated + r.user.PrivProtocol.expansion()
+}
+
+// encodeV3 encodes a message from the agent to user, encrypting and
+// authenticating it as given by flags.
+func (a *Agent) encodeV3(messageID, flags int, user UsmUser,
+	scoped ScopedPdu) ([]byte, error) {
+
+	boots, engineTime := a.usm.clock()
+	params := UsmSecurityParameters{
+		AuthoritativeEngineID:    a.usm.id(),
+		AuthoritativeEngineBoots: boots,
+		AuthoritativeEngineTime:  engineTime,
+		UserName:                 user.Name,
+	}
+	m := &V3Message{
+		MessageID:     messageID,
+		MaxSize:       maxV3MessageSize,
+		Flags:         flags,
+		SecurityModel: SecurityModelUsm,
+	}
+	if flags&FlagPriv != 0 {
+		plaintext, err := encodeScopedPdu(a.ctx, scoped)
+		if err != nil {
+			return nil, err
+		}
+		m.EncryptedPdu, params.PrivacyParameters, err = user.PrivProtocol.encrypt(
+			user.PrivKey, boots, engineTime, a.usm.nextSalt(), plaintext)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		m.ScopedPdu = scoped
+	}
+	if flags&FlagAuth != 0 {
+		// Zeros are replaced by the digest of the whole message
+		params.AuthenticationParameters = make([]byte,
+			user.AuthProtocol.digestLength())
+	}
+	m.SecurityParameters = params.Encode()
+
+	data, paramsOffset, err := encodeV3Message(a.ctx, m)
+	if err != nil {
+		return nil, err
+	}
+	if flags&FlagAuth != 0 {
+		_, authOffset, err := decodeUsmSecurityParameters(m.SecurityParameters)
+		if err != nil {
+			return nil, err
+		}
+		user.AuthProtocol.authenticate(user.AuthKey, data,
+			paramsOffset+authOffset)
+	}
+	return data, nil
+}
@@ -0,0 +1,425 @@
+package snmp
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+func TestEncodeInteger(t *testing.T) {
+	tests := []struct {
+		n        int64
+		expected []byte
+	}{
+		{0, []byte{2, 1, 0}},
+		{127, []byte{2, 1, 0x7f}},
+		{128, []byte{2, 2, 0, 0x80}},
+		{-1, []byte{2, 1, 0xff}},
+		{-129, []byte{2, 2, 0xff, 0x7f}},
+		{2147483647, []byte{2, 4, 0x7f, 0xff, 0xff, 0xff}},
+	}
+	for _, test := range tests {
+		data := encodeInteger(test.n)
+		if !bytes.Equal(data, test.expected) {
+			t.Fatalf("Wrong encoding of %d: %x\n", test.n, data)
+		}
+		n, _, err := readInteger(data, "integer")
+		if err != nil || n != test.n {
+			t.Fatalf("Wrong decoding of %d: %d, %v\n", test.n, n, err)
+		}
+	}
+}
+
+func TestV3Message(t *testing.T) {
+	params := UsmSecurityParameters{
+		AuthoritativeEngineID:    []byte{0x80, 0, 0, 0, 4, 1},
+		AuthoritativeEngineBoots: 2,
+		AuthoritativeEngineTime:  300,
+		UserName:                 "user",
+		AuthenticationParameters: make([]byte, 12),
+	}
+	m := &V3Message{
+		MessageID:          1000,
+		MaxSize:            1500,
+		Flags:              FlagAuth | FlagReportable,
+		SecurityModel:      SecurityModelUsm,
+		SecurityParameters: params.Encode(),
+		ScopedPdu: ScopedPdu{
+			ContextEngineID: params.AuthoritativeEngineID,
+			ContextName:     "",
+			Pdu: GetRequestPdu{Identifier: 3, Variables: []Variable{
+				{asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0}, asn1.Null{}}}},
+		},
+	}
+	data, err := EncodeV3Message(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeV3Message(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.MessageID != m.MessageID || decoded.MaxSize != m.MaxSize ||
+		decoded.Flags != m.Flags || decoded.SecurityModel != m.SecurityModel {
+		t.Fatalf("Wrong header: %#v\n", decoded)
+	}
+	pdu, ok := decoded.ScopedPdu.Pdu.(GetRequestPdu)
+	if !ok || pdu.Identifier != 3 || len(pdu.Variables) != 1 {
+		t.Fatalf("Wrong PDU: %#v\n", decoded.ScopedPdu.Pdu)
+	}
+	p, authOffset, err := decodeUsmSecurityParameters(decoded.SecurityParameters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.UserName != "user" || p.AuthoritativeEngineBoots != 2 ||
+		p.AuthoritativeEngineTime != 300 || len(p.AuthenticationParameters) != 12 {
+		t.Fatalf("Wrong security parameters: %#v\n", p)
+	}
+
+	// Offsets of the encoder and the decoder must agree
+	_, paramsOffset, _ := encodeV3Message(Asn1Context(), m)
+	if offset := offsetIn(data, decoded.SecurityParameters); offset != paramsOffset {
+		t.Fatalf("Wrong parameters offset %d, expected %d\n", paramsOffset,
+			offset)
+	}
+	if data[paramsOffset+authOffset-2] != tagOctetString {
+		t.Fatalf("Wrong authentication parameters offset %d\n", authOffset)
+	}
+
+	if _, err := DecodeV3Message(append(data, 0)); err == nil {
+		t.Fatal("Trailing bytes should be refused.")
+	}
+}
+
+// newV3AgentForTest creates an agent accepting SNMPv3 requests of user.
+func newV3AgentForTest(t *testing.T, user UsmUser) *Agent {
+	agent := NewAgent()
+	agent.SetSupportedVersions(Version3)
+	if err := agent.AddUsmUser(user); err != nil {
+		t.Fatal(err)
+	}
+	agent.AddRoManagedObject(asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0},
+		func(oid asn1.Oid) (interface{}, error) {
+			return "name", nil
+		})
+	return agent
+}
+
+// getV3ForTest builds a reportable SNMPv3 Get request for sysName.0.
+func getV3ForTest(t *testing.T, agent *Agent, user UsmUser) []byte {
+	return v3RequestForTest(t, agent, user, user.securityLevel()|FlagReportable)
+}
+
+// v3RequestForTest builds a SNMPv3 Get request for sysName.0 with the given
+// flags. The agent engine is the authoritative one, so its own encoder is
+// used.
+func v3RequestForTest(t *testing.T, agent *Agent, user UsmUser, flags int) []byte {
+	data, err := agent.encodeV3(42, flags, user,
+		ScopedPdu{Pdu: GetRequestPdu{Identifier: 7, Variables: []Variable{
+			{asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0}, asn1.Null{}}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// decodeV3ResponseForTest verifies and decodes a SNMPv3 response to user.
+func decodeV3ResponseForTest(t *testing.T, data []byte, user UsmUser) GetResponsePdu {
+	m, err := DecodeV3Message(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.MessageID != 42 || m.Flags != user.securityLevel() {
+		t.Fatalf("Wrong header: %#v\n", m)
+	}
+	params, authOffset, err := decodeUsmSecurityParameters(m.SecurityParameters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authOffset += offsetIn(data, m.SecurityParameters)
+	if user.AuthProtocol != NoAuth &&
+		!user.AuthProtocol.verify(user.AuthKey, data, authOffset) {
+		t.Fatal("Wrong digest in response.")
+	}
+	scoped := m.ScopedPdu
+	if user.PrivProtocol != NoPriv {
+		plaintext, err := user.PrivProtocol.decrypt(user.PrivKey,
+			params.AuthoritativeEngineBoots, params.AuthoritativeEngineTime,
+			params.PrivacyParameters, m.EncryptedPdu)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if scoped, err = DecodeScopedPdu(plaintext); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pdu, ok := scoped.Pdu.(GetResponsePdu)
+	if !ok {
+		t.Fatalf("Wrong response %T\n", scoped.Pdu)
+	}
+	return pdu
+}
+
+func TestV3Get(t *testing.T) {
+	users := []UsmUser{
+		newUsmUserForTest("none", NoAuth, NoPriv),
+		newUsmUserForTest("md5", AuthMD5, NoPriv),
+		newUsmUserForTest("sha", AuthSHA, NoPriv),
+		newUsmUserForTest("sha224", AuthSHA224, NoPriv),
+		newUsmUserForTest("sha256", AuthSHA256, NoPriv),
+		newUsmUserForTest("sha384", AuthSHA384, PrivDES),
+		newUsmUserForTest("sha512", AuthSHA512, NoPriv),
+		newUsmUserForTest("des", AuthSHA, PrivDES),
+		newUsmUserForTest("aes128", AuthSHA, PrivAES128),
+		newUsmUserForTest("aes192", AuthMD5, PrivAES192),
+		newUsmUserForTest("aes256", AuthSHA256, PrivAES256Reeder),
+	}
+	for _, user := range users {
+		agent := newV3AgentForTest(t, user)
+		data, err := agent.ProcessDatagram(getV3ForTest(t, agent, user))
+		if err != nil {
+			t.Fatalf("Request of user %s failed: %s\n", user.Name, err)
+		}
+		pdu := decodeV3ResponseForTest(t, data, user)
+		if pdu.Identifier != 7 || pdu.ErrorStatus != NoError ||
+			len(pdu.Variables) != 1 || pdu.Variables[0].Value != "name" {
+			t.Fatalf("Wrong response to user %s: %#v\n", user.Name, pdu)
+		}
+	}
+}
+
+func TestV3Exceptions(t *testing.T) {
+	user := newUsmUserForTest("md5", AuthMD5, NoPriv)
+	agent := newV3AgentForTest(t, user)
+	data, err := agent.encodeV3(42, FlagAuth, user,
+		ScopedPdu{Pdu: GetRequestPdu{Identifier: 7, Variables: []Variable{
+			{asn1.Oid{1, 3, 6, 1, 2, 1, 1, 6, 0}, asn1.Null{}}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = agent.ProcessDatagram(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdu := decodeV3ResponseForTest(t, data, user)
+	if pdu.ErrorStatus != NoError || pdu.Variables[0].Value != (NoSuchObject{}) {
+		t.Fatalf("Missing objects should be reported as in SNMPv2c: %#v\n", pdu)
+	}
+}
+
+func TestV3Dropped(t *testing.T) {
+	user := newUsmUserForTest("md5", AuthMD5, NoPriv)
+	agent := newV3AgentForTest(t, user)
+	request := v3RequestForTest(t, agent, user, FlagAuth)
+
+	unknown := newUsmUserForTest("unknown", AuthMD5, NoPriv)
+	wrongKey := user
+	wrongKey.AuthKey = bytes.Repeat([]byte{0x33}, 16)
+	// Users without keys can't authenticate their messages
+	if err := agent.AddUsmUser(newUsmUserForTest("none", NoAuth,
+		NoPriv)); err != nil {
+		t.Fatal(err)
+	}
+	none := newUsmUserForTest("none", AuthMD5, NoPriv)
+	other := NewAgent()
+	// Messages that are not reportable get no report
+	tests := map[string][]byte{
+		"wrong digest":   append([]byte{}, request...),
+		"unknown user":   v3RequestForTest(t, agent, unknown, FlagAuth),
+		"wrong key":      v3RequestForTest(t, agent, wrongKey, FlagAuth),
+		"security level": v3RequestForTest(t, agent, none, FlagAuth),
+		"engine ID":      v3RequestForTest(t, other, user, FlagAuth),
+	}
+	tests["wrong digest"][len(request)-1]++
+	for name, data := range tests {
+		_, err := agent.ProcessDatagram(data)
+		if e, ok := err.(ProcessError); !ok || e.Kind != Drop {
+			t.Fatalf("Request with %s should be dropped: %v\n", name, err)
+		}
+	}
+
+	// Messages out of the time window
+	agent.usm.boots = 2
+	_, err := agent.ProcessDatagram(request)
+	if e, ok := err.(ProcessError); !ok || e.Kind != Drop {
+		t.Fatalf("Request of other boots should be dropped: %v\n", err)
+	}
+}
+
+func TestV3LowerSecurityLevel(t *testing.T) {
+	user := newUsmUserForTest("sha", AuthSHA, PrivDES)
+	agent := newV3AgentForTest(t, user)
+	noPriv := newUsmUserForTest("sha", AuthSHA, NoPriv)
+	noAuth := newUsmUserForTest("sha", NoAuth, NoPriv)
+	noPriv.AuthKey = user.AuthKey
+
+	// Without a VACM group, lower levels are accepted but get no access
+	for _, u := range []UsmUser{noPriv, noAuth} {
+		data, err := agent.ProcessDatagram(v3RequestForTest(t, agent, u,
+			u.securityLevel()|FlagReportable))
+		if err != nil {
+			t.Fatalf("Request at level %d failed: %s\n", u.securityLevel(), err)
+		}
+		pdu := decodeV3ResponseForTest(t, data, u)
+		if pdu.ErrorStatus != NoError || pdu.Variables[0].Value != (NoSuchObject{}) {
+			t.Fatalf("Expected NoSuchObject at level %d, got %#v\n",
+				u.securityLevel(), pdu)
+		}
+	}
+	writer := user
+	writer.Access = AccessReadWrite
+	if views := agent.userViews(writer, "", 0); views.write != nil || views.read != nil {
+		t.Fatalf("Unauthenticated requests should get no views: %v\n", views)
+	}
+	data, err := agent.ProcessDatagram(getV3ForTest(t, agent, user))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pdu := decodeV3ResponseForTest(t, data, user); pdu.Variables[0].Value != "name" {
+		t.Fatalf("Wrong response at the level of the keys: %#v\n", pdu)
+	}
+
+	// VACM decides what each level gives access to
+	agent.AddViewSubtree("all", asn1.Oid{1}, nil, true)
+	agent.SetGroup(SecurityModelUsm, "sha", "group")
+	agent.SetGroupAccess("group", GroupAccess{SecurityModel: SecurityModelUsm,
+		SecurityLevel: FlagAuth, ReadView: "all"})
+	data, err = agent.ProcessDatagram(v3RequestForTest(t, agent, noAuth,
+		FlagReportable))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pdu := decodeV3ResponseForTest(t, data, noAuth); pdu.Variables[0].Value != (NoSuchObject{}) {
+		t.Fatalf("Expected NoSuchObject without authentication, got %v\n",
+			pdu.Variables[0].Value)
+	}
+	data, err = agent.ProcessDatagram(v3RequestForTest(t, agent, noPriv,
+		FlagAuth|FlagReportable))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pdu := decodeV3ResponseForTest(t, data, noPriv); pdu.Variables[0].Value != "name" {
+		t.Fatalf("Wrong response with authentication: %#v\n", pdu)
+	}
+}
+
+func TestV3Unsupported(t *testing.T) {
+	user := newUsmUserForTest("md5", AuthMD5, NoPriv)
+	agent := newV3AgentForTest(t, user)
+	request := getV3ForTest(t, agent, user)
+	agent.SetSupportedVersions(Version1)
+	_, err := agent.ProcessDatagram(request)
+	if e, ok := err.(ProcessError); !ok || e.Kind != Unsupported {
+		t.Fatalf("SNMPv3 should not be supported: %v\n", err)
+	}
+
+	// SNMPv3 messages can't be authenticated without their parameters
+	agent.SetSupportedVersions(Version3)
+	_, err = agent.ProcessMessage(&Message{Version: Version3,
+		Community: user.Name, Pdu: GetRequestPdu{}})
+	if e, ok := err.(ProcessError); !ok || e.Kind != Unsupported {
+		t.Fatalf("SNMPv3 messages should be refused: %v\n", err)
+	}
+}
+
+func TestV3HandleDatagram(t *testing.T) {
+	user := newUsmUserForTest("sha", AuthSHA, PrivDES)
+	agent := newV3AgentForTest(t, user)
+	data, respond, err := agent.HandleDatagram(getV3ForTest(t, agent, user))
+	if err != nil || !respond {
+		t.Fatalf("Request should be answered: %v\n", err)
+	}
+	decodeV3ResponseForTest(t, data, user)
+
+	report, err := agent.encodeV3(42, FlagAuth|FlagPriv, user,
+		ScopedPdu{Pdu: ReportPdu{Variables: []Variable{}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, respond, err = agent.HandleDatagram(report); err != nil || respond {
+		t.Fatalf("Reports should not be answered: %v\n", err)
+	}
+}
+
+// decodeReportForTest decodes a report and checks its variable.
+func decodeReportForTest(t *testing.T, data []byte, stat uint,
+	counter Counter32) (*V3Message, UsmSecurityParameters) {
+
+	m, err := DecodeV3Message(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, ok := m.ScopedPdu.Pdu.(ReportPdu)
+	if !ok {
+		t.Fatalf("Wrong PDU %T instead of a report\n", m.ScopedPdu.Pdu)
+	}
+	expected := Variable{asn1.Oid{1, 3, 6, 1, 6, 3, 15, 1, 1, stat, 0}, counter}
+	checkVariables(t, report.Variables, []Variable{expected})
+	params, err := DecodeUsmSecurityParameters(m.SecurityParameters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, params
+}
+
+func TestV3Discovery(t *testing.T) {
+	user := newUsmUserForTest("sha", AuthSHA, PrivAES128)
+	agent := newV3AgentForTest(t, user)
+	agent.usm.boots = 5
+
+	// Probes have no engine ID nor user name
+	probe, err := EncodeV3Message(&V3Message{
+		MessageID:          10,
+		MaxSize:            1500,
+		Flags:              FlagReportable,
+		SecurityModel:      SecurityModelUsm,
+		SecurityParameters: UsmSecurityParameters{}.Encode(),
+		ScopedPdu:          ScopedPdu{Pdu: GetRequestPdu{Identifier: 11}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, counter := range []Counter32{1, 2} {
+		data, err := agent.ProcessDatagram(probe)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, params := decodeReportForTest(t, data, 4, counter)
+		if m.MessageID != 10 || m.Flags != 0 ||
+			!bytes.Equal(params.AuthoritativeEngineID, agent.EngineID()) ||
+			params.AuthoritativeEngineBoots != 5 {
+			t.Fatalf("Wrong report: %#v, %#v\n", m, params)
+		}
+		if report := m.ScopedPdu.Pdu.(ReportPdu); report.Identifier != 11 {
+			t.Fatalf("Wrong report identifier %d\n", report.Identifier)
+		}
+	}
+
+	// Out of time window requests get an authenticated report with the clock
+	request := getV3ForTest(t, agent, user)
+	agent.usm.boots = 6
+	data, respond, err := agent.HandleDatagram(request)
+	if err != nil || !respond {
+		t.Fatalf("Request should get a report: %v\n", err)
+	}
+	m, params := decodeReportForTest(t, data, 2, 1)
+	authOffset := offsetIn(data, m.SecurityParameters) +
+		offsetIn(m.SecurityParameters, params.AuthenticationParameters)
+	if m.Flags != FlagAuth || params.AuthoritativeEngineBoots != 6 ||
+		!AuthSHA.verify(user.AuthKey, data, authOffset) {
+		t.Fatalf("Wrong report: %#v, %#v\n", m, params)
+	}
+
+	// Wrong digests are reported without authentication
+	request = getV3ForTest(t, agent, user)
+	request[len(request)-1]++
+	data, err = agent.ProcessDatagram(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, params = decodeReportForTest(t, data, 5, 1)
+	if m.Flags != 0 || params.UserName != user.Name {
+		t.Fatalf("Wrong report: %#v, %#v\n", m, params)
+	}
+}
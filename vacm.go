@@ -0,0 +1,271 @@
+package snmp
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+// Security models of the View-based Access Control Model, along with
+// SecurityModelUsm.
+const (
+	SecurityModelAny = 0
+	SecurityModelV1  = 1
+	SecurityModelV2c = 2
+)
+
+// GroupAccess is the access of a group (RFC 3415, vacmAccessTable): the
+// names of the views that can be read, written and notified. An empty name
+// gives no access.
+type GroupAccess struct {
+	// Context is the name of the context of the requests, the default one
+	// when empty, or a prefix of their context names with ContextPrefix.
+	Context       string
+	ContextPrefix bool
+	// SecurityModel of the requests, SecurityModelAny for all of them.
+	SecurityModel int
+	// SecurityLevel is the minimum level of the requests, as message flags:
+	// 0 for noAuthNoPriv, FlagAuth for authNoPriv or FlagAuth|FlagPriv for
+	// authPriv. SNMPv1 and SNMPv2c requests are noAuthNoPriv.
+	SecurityLevel int
+	ReadView      string
+	WriteView     string
+	NotifyView    string
+}
+
+// viewFamily is a subtree family of a MIB view.
+type viewFamily struct {
+	subtree  asn1.Oid
+	mask     []byte
+	included bool
+}
+
+// matches checks if an OID is in the family. Sub-identifiers whose bit of
+// the mask is 0 match any value; missing bits are 1.
+func (f viewFamily) matches(oid asn1.Oid) bool {
+	if len(oid) < len(f.subtree) {
+		return false
+	}
+	for i, id := range f.subtree {
+		wildcard := i/8 < len(f.mask) && f.mask[i/8]&(0x80>>uint(i%8)) == 0
+		if !wildcard && oid[i] != id {
+			return false
+		}
+	}
+	return true
+}
+
+// mibView is a MIB view, a collection of included and excluded subtree
+// families.
+type mibView []viewFamily
+
+// contains checks if an OID is in the view: the longest family matching it
+// must be included, the lexicographically greatest if several have the same
+// length (RFC 3415, section 5). A nil view contains nothing.
+func (v mibView) contains(oid asn1.Oid) bool {
+	var match *viewFamily
+	for i := range v {
+		f := &v[i]
+		if !f.matches(oid) {
+			continue
+		}
+		if match == nil || len(f.subtree) > len(match.subtree) ||
+			len(f.subtree) == len(match.subtree) && f.subtree.Cmp(match.subtree) > 0 {
+			match = f
+		}
+	}
+	return match != nil && match.included
+}
+
+// fullView is the view of the whole MIB.
+var fullView = mibView{{subtree: asn1.Oid{}, included: true}}
+
+// requestViews are the views a request can access, nil when there is no
+// access.
+type requestViews struct {
+	read   mibView
+	write  mibView
+	notify mibView
+}
+
+// vacmSecurity identifies a principal by its security model and name.
+type vacmSecurity struct {
+	model int
+	name  string
+}
+
+// vacm keeps the configuration of the View-based Access Control Model.
+type vacm struct {
+	sync.Mutex
+	groups map[vacmSecurity]string
+	access map[string][]GroupAccess
+	views  map[string]mibView
+}
+
+// AddViewSubtree adds a subtree family to a MIB view (RFC 3415,
+// vacmViewTreeFamilyTable), creating the view if needed. Each bit of mask,
+// from the most significant one, tells if the matching sub-identifier of the
+// subtree must match (1) or is a wildcard (0); missing bits are 1, so a nil
+// mask matches the subtree exactly. Excluded families remove objects from the
+// view, like a subtree of an included one.
+func (a *Agent) AddViewSubtree(view string, subtree asn1.Oid, mask []byte,
+	included bool) error {
+
+	if len(mask) > 16 {
+		return fmt.Errorf("invalid mask length %d", len(mask))
+	}
+	a.vacm.Lock()
+	defer a.vacm.Unlock()
+	if a.vacm.views == nil {
+		a.vacm.views = make(map[string]mibView)
+	}
+	family := viewFamily{append(asn1.Oid{}, subtree...),
+		append([]byte{}, mask...), included}
+	families := a.vacm.views[view]
+	for i, f := range families {
+		if f.subtree.Cmp(subtree) == 0 {
+			// Replace the family of the same subtree
+			families[i] = family
+			return nil
+		}
+	}
+	a.vacm.views[view] = append(families, family)
+	return nil
+}
+
+// SetGroup defines the group of a principal (RFC 3415,
+// vacmSecurityToGroupTable). Security names are communities for
+// SecurityModelV1 and SecurityModelV2c and user names for SecurityModelUsm.
+// An empty group removes the principal.
+func (a *Agent) SetGroup(securityModel int, securityName, group string) {
+	a.vacm.Lock()
+	defer a.vacm.Unlock()
+	if a.vacm.groups == nil {
+		a.vacm.groups = make(map[vacmSecurity]string)
+	}
+	key := vacmSecurity{securityModel, securityName}
+	if group == "" {
+		delete(a.vacm.groups, key)
+	} else {
+		a.vacm.groups[key] = group
+	}
+}
+
+// SetGroupAccess adds an access entry to a group, replacing any entry with
+// the same context, security model and level. When several entries match a
+// request, the one of its exact security model is preferred, then the one of
+// its exact context or with the longest prefix, then the one with the highest
+// level.
+func (a *Agent) SetGroupAccess(group string, access GroupAccess) {
+	a.vacm.Lock()
+	defer a.vacm.Unlock()
+	if a.vacm.access == nil {
+		a.vacm.access = make(map[string][]GroupAccess)
+	}
+	entries := a.vacm.access[group]
+	for i, e := range entries {
+		if e.Context == access.Context && e.ContextPrefix == access.ContextPrefix &&
+			e.SecurityModel == access.SecurityModel &&
+			e.SecurityLevel == access.SecurityLevel {
+			entries[i] = access
+			return
+		}
+	}
+	a.vacm.access[group] = append(entries, access)
+}
+
+// hasView checks if a view has been defined.
+func (v *vacm) hasView(view string) bool {
+	v.Lock()
+	defer v.Unlock()
+	_, ok := v.views[view]
+	return ok
+}
+
+// hasGroupAccess checks if a group has access entries.
+func (v *vacm) hasGroupAccess(group string) bool {
+	v.Lock()
+	defer v.Unlock()
+	return len(v.access[group]) > 0
+}
+
+// lookup returns the views of a principal for requests to a context with a
+// security level, which are empty when no access entry matches. ok is false
+// when the principal has no group.
+func (v *vacm) lookup(securityModel int, securityName, context string,
+	level int) (views requestViews, ok bool) {
+
+	v.Lock()
+	defer v.Unlock()
+	group, ok := v.groups[vacmSecurity{securityModel, securityName}]
+	if !ok {
+		return views, false
+	}
+	var best *GroupAccess
+	for i := range v.access[group] {
+		e := &v.access[group][i]
+		if e.SecurityModel != securityModel && e.SecurityModel != SecurityModelAny ||
+			e.SecurityLevel&level != e.SecurityLevel || !e.matchesContext(context) {
+			continue
+		}
+		if best == nil || e.preferred(best) {
+			best = e
+		}
+	}
+	if best == nil {
+		return views, true
+	}
+	// Unknown view names give no access
+	views.read = v.views[best.ReadView]
+	views.write = v.views[best.WriteView]
+	views.notify = v.views[best.NotifyView]
+	return views, true
+}
+
+// matchesContext checks if the entry applies to a context.
+func (e *GroupAccess) matchesContext(context string) bool {
+	if e.ContextPrefix {
+		return strings.HasPrefix(context, e.Context)
+	}
+	return context == e.Context
+}
+
+// preferred checks if the entry is preferred over another one matching the
+// same request (RFC 3415, section 4).
+func (e *GroupAccess) preferred(other *GroupAccess) bool {
+	if (e.SecurityModel == SecurityModelAny) != (other.SecurityModel == SecurityModelAny) {
+		return other.SecurityModel == SecurityModelAny
+	}
+	if e.ContextPrefix != other.ContextPrefix {
+		return other.ContextPrefix
+	}
+	if len(e.Context) != len(other.Context) {
+		return len(e.Context) > len(other.Context)
+	}
+	return e.SecurityLevel > other.SecurityLevel
+}
+
+// communitySecurityModel returns the security model of community based
+// requests of a SNMP version.
+func communitySecurityModel(version int) int {
+	if version == Version1 {
+		return SecurityModelV1
+	}
+	return SecurityModelV2c
+}
+
+// AddCommunity accepts requests with a community, whose access is given by
+// the VACM group of the community, for both SNMPv1 and SNMPv2c.
+func (a *Agent) AddCommunity(community, group string) {
+	a.communities = append(a.communities, community)
+	a.SetGroup(SecurityModelV1, community, group)
+	a.SetGroup(SecurityModelV2c, community, group)
+}
+
+// AddBinaryCommunity works like AddCommunity for a community that isn't
+// printable text, compared byte by byte.
+func (a *Agent) AddBinaryCommunity(community []byte, group string) {
+	a.AddCommunity(string(community), group)
+}
@@ -0,0 +1,190 @@
+package snmp
+
+import (
+	"testing"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+func TestViewContains(t *testing.T) {
+	agent := NewAgent()
+	agent.AddViewSubtree("view", asn1.Oid{1, 3, 6, 1, 2, 1}, nil, true)
+	agent.AddViewSubtree("view", asn1.Oid{1, 3, 6, 1, 2, 1, 4}, nil, false)
+	agent.AddViewSubtree("view", asn1.Oid{1, 3, 6, 1, 2, 1, 4, 20}, nil, true)
+	// Rows of the second interface of ifTable, for any column
+	agent.AddViewSubtree("view", asn1.Oid{1, 3, 6, 1, 2, 1, 2, 2, 1, 0, 2},
+		[]byte{0xff, 0xa0}, false)
+	if err := agent.AddViewSubtree("view", asn1.Oid{1}, make([]byte, 17),
+		true); err == nil {
+		t.Fatal("Long masks should be refused.")
+	}
+	view := agent.vacm.views["view"]
+
+	tests := []struct {
+		oid      asn1.Oid
+		expected bool
+	}{
+		{asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0}, true},
+		{asn1.Oid{1, 3, 6, 1, 2}, false},
+		{asn1.Oid{1, 3, 6, 1, 4, 1}, false},
+		{asn1.Oid{1, 3, 6, 1, 2, 1, 4, 1, 0}, false},
+		{asn1.Oid{1, 3, 6, 1, 2, 1, 4, 20, 1, 1}, true},
+		{asn1.Oid{1, 3, 6, 1, 2, 1, 2, 2, 1, 2, 1}, true},
+		{asn1.Oid{1, 3, 6, 1, 2, 1, 2, 2, 1, 2, 2}, false},
+		{asn1.Oid{1, 3, 6, 1, 2, 1, 2, 2, 1, 7, 2}, false},
+		{asn1.Oid{1, 3, 6, 1, 2, 1, 2, 2, 1, 7, 3}, true},
+	}
+	for _, test := range tests {
+		if view.contains(test.oid) != test.expected {
+			t.Fatalf("OID %s: expected %v\n", test.oid, test.expected)
+		}
+	}
+	if mibView(nil).contains(asn1.Oid{1}) {
+		t.Fatal("Nil views should contain nothing.")
+	}
+}
+
+func TestGroupAccess(t *testing.T) {
+	agent := NewAgent()
+	agent.AddViewSubtree("read", asn1.Oid{1}, nil, true)
+	agent.AddViewSubtree("write", asn1.Oid{1, 3, 6, 1, 4}, nil, true)
+	agent.SetGroup(SecurityModelUsm, "user", "group")
+	agent.SetGroupAccess("group", GroupAccess{ReadView: "read"})
+	agent.SetGroupAccess("group", GroupAccess{SecurityModel: SecurityModelUsm,
+		SecurityLevel: FlagAuth, ReadView: "read", WriteView: "write"})
+	agent.SetGroupAccess("group", GroupAccess{SecurityModel: SecurityModelUsm,
+		SecurityLevel: FlagAuth | FlagPriv, ReadView: "unknown"})
+
+	tests := []struct {
+		level        int
+		read, write  bool
+		securityName string
+	}{
+		{0, true, false, "user"},
+		{FlagAuth, true, true, "user"},
+		{FlagAuth | FlagPriv, false, false, "user"},
+		{FlagAuth, false, false, "other"},
+	}
+	for _, test := range tests {
+		views, _ := agent.vacm.lookup(SecurityModelUsm, test.securityName, "",
+			test.level)
+		if (views.read != nil) != test.read || (views.write != nil) != test.write {
+			t.Fatalf("Wrong views for %s at level %d: %v\n", test.securityName,
+				test.level, views)
+		}
+	}
+	if _, ok := agent.vacm.lookup(SecurityModelV2c, "user", "", 0); ok {
+		t.Fatal("Groups are defined per security model.")
+	}
+	agent.SetGroup(SecurityModelUsm, "user", "")
+	if _, ok := agent.vacm.lookup(SecurityModelUsm, "user", "", 0); ok {
+		t.Fatal("Principal not removed.")
+	}
+}
+
+func TestCommunityViews(t *testing.T) {
+	agent := NewAgent()
+	agent.SetSupportedVersions(Version1, Version2c)
+	for _, oid := range []asn1.Oid{{1, 3, 6, 1, 2, 1, 1, 5, 0},
+		{1, 3, 6, 1, 2, 1, 1, 6, 0}, {1, 3, 6, 1, 2, 1, 1, 7, 0}} {
+		agent.AddRwManagedObject(oid,
+			func(oid asn1.Oid) (interface{}, error) {
+				return "value", nil
+			},
+			func(oid asn1.Oid, value interface{}) error {
+				return nil
+			})
+	}
+	agent.AddViewSubtree("restricted", asn1.Oid{1, 3, 6, 1, 2, 1, 1}, nil, true)
+	agent.AddViewSubtree("restricted", asn1.Oid{1, 3, 6, 1, 2, 1, 1, 6}, nil,
+		false)
+	agent.AddViewSubtree("location", asn1.Oid{1, 3, 6, 1, 2, 1, 1, 7}, nil, true)
+	agent.SetGroupAccess("operators", GroupAccess{ReadView: "restricted",
+		WriteView: "location"})
+	agent.AddCommunity("operator", "operators")
+
+	request := func(version int, pdu interface{}) GetResponsePdu {
+		response, err := agent.ProcessMessage(&Message{Version: version,
+			Community: "operator", Pdu: pdu})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response.Pdu.(GetResponsePdu)
+	}
+	variables := []Variable{
+		{asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0}, asn1.Null{}},
+		{asn1.Oid{1, 3, 6, 1, 2, 1, 1, 6, 0}, asn1.Null{}},
+	}
+
+	// Objects out of the read view are missing
+	pdu := request(Version2c, GetRequestPdu{Variables: variables})
+	checkVariables(t, pdu.Variables, []Variable{
+		{asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0}, "value"},
+		{asn1.Oid{1, 3, 6, 1, 2, 1, 1, 6, 0}, NoSuchObject{}},
+	})
+	pdu = request(Version1, GetRequestPdu{Variables: variables})
+	if pdu.ErrorStatus != NoSuchName || pdu.ErrorIndex != 2 {
+		t.Fatalf("Wrong SNMPv1 error: %d at %d\n", pdu.ErrorStatus, pdu.ErrorIndex)
+	}
+	pdu = request(Version2c, GetNextRequestPdu{Variables: variables[:1]})
+	checkVariables(t, pdu.Variables, []Variable{
+		{asn1.Oid{1, 3, 6, 1, 2, 1, 1, 7, 0}, "value"},
+	})
+
+	// Writes out of the write view are denied
+	pdu = request(Version2c, SetRequestPdu{Variables: []Variable{
+		{asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0}, "new"}}})
+	if pdu.ErrorStatus != NoAccess {
+		t.Fatalf("Write out of view should fail: %d\n", pdu.ErrorStatus)
+	}
+	pdu = request(Version2c, SetRequestPdu{Variables: []Variable{
+		{asn1.Oid{1, 3, 6, 1, 2, 1, 1, 7, 0}, "new"}}})
+	if pdu.ErrorStatus != NoError {
+		t.Fatalf("Write in view failed: %d\n", pdu.ErrorStatus)
+	}
+
+	// The default communities are kept
+	response, err := agent.ProcessMessage(&Message{Version: Version2c,
+		Community: "private", Pdu: SetRequestPdu{Variables: []Variable{
+			{asn1.Oid{1, 3, 6, 1, 2, 1, 1, 6, 0}, "new"}}}})
+	if err != nil || response.Pdu.(GetResponsePdu).ErrorStatus != NoError {
+		t.Fatalf("Private community should write: %v\n", err)
+	}
+}
+
+func TestUserViews(t *testing.T) {
+	user := newUsmUserForTest("md5", AuthMD5, NoPriv)
+	agent := newV3AgentForTest(t, user)
+	agent.AddViewSubtree("none", asn1.Oid{1, 3, 6, 1, 4}, nil, true)
+	agent.SetGroup(SecurityModelUsm, user.Name, "restricted")
+	agent.SetGroupAccess("restricted", GroupAccess{
+		SecurityModel: SecurityModelUsm, SecurityLevel: FlagAuth,
+		ReadView: "none"})
+
+	data, err := agent.ProcessDatagram(getV3ForTest(t, agent, user))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdu := decodeV3ResponseForTest(t, data, user)
+	if pdu.Variables[0].Value != (NoSuchObject{}) {
+		t.Fatalf("sysName.0 should not be in the view: %v\n", pdu.Variables)
+	}
+}
+
+func TestSetCommunitiesKeepsViews(t *testing.T) {
+	agent := NewAgent()
+	agent.SetGroupAccess("public", GroupAccess{ReadView: "system"})
+	agent.AddViewSubtree("system", asn1.Oid{1, 3, 6, 1, 2, 1, 1}, nil, true)
+	agent.SetCommunities("publ", "priv")
+
+	// The access of the public group configured before is kept
+	views, _ := agent.vacm.lookup(SecurityModelV1, "publ", "", 0)
+	if views.read == nil || views.read.contains(asn1.Oid{1, 3, 6, 1, 4, 1}) ||
+		!views.read.contains(asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0}) {
+		t.Fatalf("Wrong read view of the public group: %v\n", views.read)
+	}
+	views, _ = agent.vacm.lookup(SecurityModelV1, "priv", "", 0)
+	if views.write == nil || !views.write.contains(asn1.Oid{1, 3, 6, 1, 4, 1}) {
+		t.Fatalf("Wrong write view of the private group: %v\n", views.write)
+	}
+}
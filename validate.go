@@ -0,0 +1,102 @@
+package snmp
+
+import (
+	"strings"
+)
+
+// ValidationError lists the errors that the variables of a request would
+// produce, as reported by ValidateDatagram.
+type ValidationError []VarError
+
+func (e ValidationError) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// ValidateDatagram checks if a binary SNMP message would be handled without
+// errors, without calling any getter or setter nor sending a response. The
+// version, the community, the existence of the requested objects and, for
+// SETs, the access and the value types are verified. Message level problems
+// are reported by a ProcessError, like in ProcessDatagram, and variable level
+// problems by a ValidationError.
+func (a *Agent) ValidateDatagram(data []byte) error {
+	// The snmp counters are left untouched by the dry run
+	var counters snmpCounters
+	request, err := a.decodeDatagram(data, &counters)
+	if err != nil {
+		return err
+	}
+	if !a.supportsVersion(request.Version) {
+		return processErrorf(Unsupported, "invalid SNMP version %d",
+			request.Version)
+	}
+	views, err := a.checkCommunity(request, &counters)
+	if err != nil {
+		return err
+	}
+
+	var next, set bool
+	switch request.Pdu.(type) {
+	case GetRequestPdu:
+	case GetNextRequestPdu:
+		next = true
+	case SetRequestPdu:
+		set = true
+	default:
+		return processErrorf(Unsupported, "PDU not supported: %T", request.Pdu)
+	}
+
+	var errs ValidationError
+	variables := pduVariables(request.Pdu)
+	creating := a.creatingRows(variables)
+	steps := 0
+	for _, v := range variables {
+		var h *managedObject
+		if next {
+			h = a.nextManagedObject(v.Name, &steps)
+		} else {
+			h = a.getManagedObject(v.Name, false)
+			if h == nil && set {
+				h = a.newInstance(v.Name)
+			}
+		}
+		if h == nil {
+			errs = append(errs, VarErrorf(NoSuchName, "unknown OID %s", v.Name))
+			continue
+		}
+		if !set {
+			continue
+		}
+		value := h.normalize(v.Value)
+		if views.write == nil {
+			errs = append(errs, VarErrorf(NoSuchName,
+				"community %s is read-only", printableCommunity(request.Community)))
+		} else if !views.write.contains(h.oid) {
+			errs = append(errs, VarErrorf(NoAccess,
+				"OID %s is not in the write view", h.oid))
+		} else if err := a.authorizeWrite(request.Community, h.oid); err != nil {
+			status := NoAccess
+			if e, ok := err.(VarError); ok {
+				status = e.Status
+			}
+			errs = append(errs, VarErrorf(status, "OID %s: %s", h.oid, err))
+		} else if h.readOnly {
+			errs = append(errs, VarErrorf(NotWritable, "OID %s is not writable", h.oid))
+		} else if h.isCounter() {
+			errs = append(errs, VarErrorf(NotWritable, "OID %s is a counter", h.oid))
+		} else if h.readCreate && !a.rowWritable(h, creating) {
+			errs = append(errs, VarErrorf(NotWritable,
+				"OID %s can't be written while its row is active", h.oid))
+		} else if !h.accepts(value) || !settable(value) {
+			errs = append(errs, VarErrorf(WrongType, "invalid type %T for %s",
+				value, h.oid))
+		}
+	}
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package snmp
+
+import (
+	"testing"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+func TestValidateDatagram(t *testing.T) {
+
+	nameOid := asn1.Oid{1, 3, 6, 1, 2, 1, 1, 5, 0}
+	counterOid := asn1.Oid{1, 3, 6, 1, 2, 1, 2, 1, 0}
+	name := "name"
+	agent := NewAgent()
+	agent.AddRwManagedObject(nameOid,
+		func(oid asn1.Oid) (interface{}, error) {
+			return name, nil
+		},
+		func(oid asn1.Oid, value interface{}) error {
+			name = value.(string)
+			return nil
+		})
+	agent.AddRoManagedObject(counterOid,
+		func(oid asn1.Oid) (interface{}, error) {
+			return Counter32(1), nil
+		})
+	agent.SetObjectType(counterOid, Counter32(0))
+
+	validate := func(community string, pdu interface{}) error {
+		data, err := EncodePdu(Version1, community, pdu)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return agent.ValidateDatagram(data)
+	}
+
+	err := validate("private", SetRequestPdu{
+		Variables: []Variable{{nameOid, "new"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "name" {
+		t.Fatalf("The value should not be changed: %q\n", name)
+	}
+
+	err = validate("private", SetRequestPdu{
+		Variables: []Variable{
+			{nameOid, "new"},
+			{counterOid, Counter32(5)},
+			{asn1.Oid{1, 3, 6, 1, 2, 1, 1, 9, 0}, 1},
+		},
+	})
+	errs, ok := err.(ValidationError)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("Wrong validation errors: %v\n", err)
+	}
+	if errs[0].Status != NotWritable || errs[1].Status != NoSuchName {
+		t.Fatalf("Wrong error statuses: %v\n", errs)
+	}
+
+	// Read-only objects are rejected without calling their setter
+	descrOid := asn1.Oid{1, 3, 6, 1, 2, 1, 1, 1, 0}
+	agent.AddRoManagedObject(descrOid, func(oid asn1.Oid) (interface{}, error) {
+		return "descr", nil
+	})
+	err = validate("private", SetRequestPdu{
+		Variables: []Variable{{descrOid, "new"}},
+	})
+	errs, ok = err.(ValidationError)
+	if !ok || len(errs) != 1 || errs[0].Status != NotWritable {
+		t.Fatalf("Wrong validation errors for a read-only object: %v\n", err)
+	}
+
+	err = validate("public", GetRequestPdu{
+		Variables: []Variable{{nameOid, asn1.Null{}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = validate("wrong", GetRequestPdu{})
+	if e, ok := err.(ProcessError); !ok || e.Kind != Drop {
+		t.Fatalf("Invalid community should be dropped: %v\n", err)
+	}
+}
@@ -0,0 +1,57 @@
+package snmp
+
+import (
+	"time"
+)
+
+// SetWriteQueue makes all setters run on a single goroutine, one at a time,
+// even when requests are processed concurrently. A SET waits for its write
+// for at most timeout and fails with GenErr if it takes longer; a write
+// already queued is not cancelled and still runs when its turn comes. A
+// timeout of zero disables the queue, which is the default. It should be
+// called before the agent starts processing requests.
+func (a *Agent) SetWriteQueue(timeout time.Duration) {
+	if a.writes != nil {
+		close(a.writes.jobs)
+		a.writes = nil
+	}
+	if timeout > 0 {
+		a.writes = newWriteQueue(timeout)
+	}
+}
+
+// writeQueue serializes writes through a worker goroutine.
+type writeQueue struct {
+	jobs    chan func()
+	timeout time.Duration
+}
+
+// newWriteQueue creates a write queue and starts its worker.
+func newWriteQueue(timeout time.Duration) *writeQueue {
+	q := &writeQueue{jobs: make(chan func()), timeout: timeout}
+	go func() {
+		for job := range q.jobs {
+			job()
+		}
+	}()
+	return q
+}
+
+// do runs write on the worker and waits for its result.
+func (q *writeQueue) do(write func() error) error {
+	// Buffered so a late write does not block the worker
+	result := make(chan error, 1)
+	timer := time.NewTimer(q.timeout)
+	defer timer.Stop()
+	select {
+	case q.jobs <- func() { result <- write() }:
+	case <-timer.C:
+		return VarErrorf(GenErr, "timeout waiting for the write queue")
+	}
+	select {
+	case err := <-result:
+		return err
+	case <-timer.C:
+		return VarErrorf(GenErr, "timeout waiting for the write")
+	}
+}
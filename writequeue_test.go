@@ -0,0 +1,94 @@
+package snmp
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PromonLogicalis/asn1"
+)
+
+func TestWriteQueue(t *testing.T) {
+
+	var mu sync.Mutex
+	active, maxActive, writes := 0, 0, 0
+	agent := NewAgent()
+	agent.SetWriteQueue(time.Second)
+	oid := asn1.Oid{1, 3, 6, 1, 4, 1, 9999, 1, 0}
+	agent.AddRwManagedObject(oid,
+		func(oid asn1.Oid) (interface{}, error) {
+			return 0, nil
+		},
+		func(oid asn1.Oid, value interface{}) error {
+			mu.Lock()
+			active++
+			if active > maxActive {
+				maxActive = active
+			}
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			mu.Lock()
+			active--
+			writes++
+			mu.Unlock()
+			return nil
+		})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			request := &Message{
+				Community: "private",
+				Pdu: SetRequestPdu{
+					Variables: []Variable{{oid, i}},
+				},
+			}
+			response, err := agent.ProcessMessage(request)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if pdu := response.Pdu.(GetResponsePdu); pdu.ErrorStatus != NoError {
+				t.Errorf("unexpected error status %d\n", pdu.ErrorStatus)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if writes != 10 {
+		t.Fatalf("expected 10 writes, got %d\n", writes)
+	}
+	if maxActive != 1 {
+		t.Fatalf("expected writes one at a time, got %d at once\n", maxActive)
+	}
+}
+
+func TestWriteQueueTimeout(t *testing.T) {
+
+	agent := NewAgent()
+	agent.SetWriteQueue(10 * time.Millisecond)
+	oid := asn1.Oid{1, 3, 6, 1, 4, 1, 9999, 1, 0}
+	agent.AddRwManagedObject(oid,
+		func(oid asn1.Oid) (interface{}, error) {
+			return 0, nil
+		},
+		func(oid asn1.Oid, value interface{}) error {
+			time.Sleep(50 * time.Millisecond)
+			return nil
+		})
+
+	request := &Message{
+		Community: "private",
+		Pdu: SetRequestPdu{
+			Variables: []Variable{{oid, 1}},
+		},
+	}
+	response, err := agent.ProcessMessage(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pdu := response.Pdu.(GetResponsePdu); pdu.ErrorStatus != GenErr {
+		t.Fatalf("expected GenErr, got %d\n", pdu.ErrorStatus)
+	}
+}